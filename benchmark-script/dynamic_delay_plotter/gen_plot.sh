@@ -4,45 +4,16 @@
 # Enable command tracing.
 set -x
 
-# p10
-go run . --increase-rate 1 --target-percentile 0.1 --initial-delay 1ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_10_r_1_i_1ms
-go run . --increase-rate 1 --target-percentile 0.1 --initial-delay 30ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_10_r_1_i_30ms
-go run . --increase-rate 1 --target-percentile 0.1 --initial-delay 100ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_10_r_1_i_100ms
-
-
-go run . --increase-rate 15 --target-percentile 0.1 --initial-delay 1ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_10_r_15_i_1ms
-go run . --increase-rate 15 --target-percentile 0.1 --initial-delay 30ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_10_r_15_i_30ms
-go run . --increase-rate 15 --target-percentile 0.1 --initial-delay 100ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_10_r_15_i_100ms
-
-go run . --increase-rate 100 --target-percentile 0.1 --initial-delay 1ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_10_r_100_i_1ms
-go run . --increase-rate 100 --target-percentile 0.1 --initial-delay 30ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_10_r_100_i_30ms
-go run . --increase-rate 100 --target-percentile 0.1 --initial-delay 100ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_10_r_100_i_100ms
-
-# p50
-go run . --increase-rate 1 --target-percentile 0.5 --initial-delay 1ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_50_r_1_i_1ms
-go run . --increase-rate 1 --target-percentile 0.5 --initial-delay 30ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_50_r_1_i_30ms
-go run . --increase-rate 1 --target-percentile 0.5 --initial-delay 100ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_50_r_1_i_100ms
-
-go run . --increase-rate 15 --target-percentile 0.5 --initial-delay 1ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_50_r_15_i_1ms
-go run . --increase-rate 15 --target-percentile 0.5 --initial-delay 30ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_50_r_15_i_30ms
-go run . --increase-rate 15 --target-percentile 0.5 --initial-delay 100ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_50_r_15_i_100ms
-
-go run . --increase-rate 100 --target-percentile 0.5 --initial-delay 1ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_50_r_100_i_1ms
-go run . --increase-rate 100 --target-percentile 0.5 --initial-delay 30ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_50_r_100_i_30ms
-go run . --increase-rate 100 --target-percentile 0.5 --initial-delay 100ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_50_r_100_i_100ms
-
-# p90
-go run . --increase-rate 1 --target-percentile 0.9 --initial-delay 1ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_90_r_1_i_1ms
-go run . --increase-rate 1 --target-percentile 0.9 --initial-delay 30ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_90_r_1_i_30ms
-go run . --increase-rate 1 --target-percentile 0.9 --initial-delay 100ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_90_r_1_i_100ms
-
-go run . --increase-rate 15 --target-percentile 0.9 --initial-delay 1ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_90_r_15_i_1ms
-go run . --increase-rate 15 --target-percentile 0.9 --initial-delay 30ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_90_r_15_i_30ms
-go run . --increase-rate 15 --target-percentile 0.9 --initial-delay 100ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_90_r_15_i_100ms
-
-go run . --increase-rate 100 --target-percentile 0.9 --initial-delay 1ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_90_r_100_i_1ms
-go run . --increase-rate 100 --target-percentile 0.9 --initial-delay 30ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_90_r_100_i_30ms
-go run . --increase-rate 100 --target-percentile 0.9 --initial-delay 100ms --min-delay 1ms --max-delay 10m --sample-count 1000 --output-file p_90_r_100_i_100ms
+# Compares fixed, util.Delay and hedging policies on the latencies of
+# metrics/req and metrics/read, see ../policy_simulator. The comparison table
+# is written to ../policy_simulator/results/policies.csv, with a tail latency
+# and a cost plot per metrics directory.
+cd ../policy_simulator
+go run . \
+  --timeouts 100ms,500ms,1s \
+  --percentiles 0.1,0.5,0.9 \
+  --hedge-percentiles 0.9,0.99 --hedge-budgets 0.01,0.05 \
+  --increase-rate 15 --initial-delay 30ms --min-delay 1ms --max-delay 10m
 
 # Disable command tracing.
-set +x
\ No newline at end of file
+set +x
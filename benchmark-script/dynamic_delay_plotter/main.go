@@ -27,6 +27,17 @@ var (
 	sampleCount = flag.Int("sample-count", 500, "Sample count")
 
 	outputFile = flag.String("output-file", "plot", "Plot file name")
+
+	metricsDir = flag.String("metrics-dir", "./metrics/req/", "Directory of the metrics CSV files")
+
+	// Grid sweep, see sweep.go. Every combination of the values is evaluated
+	// instead of plotting a single delay.
+	sweep                  = flag.Bool("sweep", false, "Evaluate every combination of the --sweep-* values, and write plots/<output-file>.csv and .png")
+	sweepIncreaseRates     = flag.String("sweep-increase-rates", "1,15,100", "Increase rates to sweep: comma separated values or start:end:step")
+	sweepTargetPercentiles = flag.String("sweep-target-percentiles", "0.1,0.5,0.9", "Target percentiles to sweep: comma separated values or start:end:step")
+	sweepInitialDelays     = flag.String("sweep-initial-delays", "1ms,30ms,100ms", "Initial delays to sweep: comma separated values or start:end:step")
+	sweepPlots             = flag.Bool("sweep-plots", false, "Also save the plot of every combination, e.g. plots/p_90_r_15_i_30ms.png")
+	convergenceTolerance   = flag.Float64("convergence-tolerance", 0.25, "Relative distance to the target percentile of the samples within which the delay is converged")
 )
 
 // ConvertToXYs takes separate x and y slices and converts them into the correct plotter.XYs format
@@ -43,17 +54,14 @@ func ConvertToXYs(xValues, yValues []float64) plotter.XYs {
 	return xys
 }
 
-func actualSample(p *plot.Plot, dataRows []DataRow, d *util.Delay) {
-	totalCnt := *sampleCount
-	xValues := make([]float64, totalCnt)
-	yValues1 := make([]float64, totalCnt)
-	yValues2 := make([]float64, totalCnt)
-	var samplesOverThreshold int
-	for i, row := range dataRows {
-		if i >= totalCnt {
-			break
-		}
-
+// replay applies the latencies of the first sampleCount rows to d, and returns
+// the delay and the latency after each of them, in seconds, and the number of
+// latencies over the delay.
+func replay(dataRows []DataRow, d *util.Delay, sampleCount int) (thresholds, latencies []float64, samplesOverThreshold int) {
+	n := min(sampleCount, len(dataRows))
+	thresholds = make([]float64, n)
+	latencies = make([]float64, n)
+	for i, row := range dataRows[:n] {
 		actualDelay := time.Duration(row.ReadLatency * float64(time.Second))
 		if actualDelay > d.Value() {
 			samplesOverThreshold++
@@ -61,10 +69,25 @@ func actualSample(p *plot.Plot, dataRows []DataRow, d *util.Delay) {
 		} else {
 			d.Decrease()
 		}
+		thresholds[i] = d.Value().Seconds()
+		latencies[i] = actualDelay.Seconds()
+	}
+	return thresholds, latencies, samplesOverThreshold
+}
+
+func actualSample(p *plot.Plot, dataRows []DataRow, d *util.Delay) {
+	thresholds, latencies, samplesOverThreshold := replay(dataRows, d, *sampleCount)
+	// The plot always has --sample-count points, the ones past the rows
+	// at 0.
+	totalCnt := *sampleCount
+	xValues := make([]float64, totalCnt)
+	yValues1 := make([]float64, totalCnt)
+	yValues2 := make([]float64, totalCnt)
+	for i := range thresholds {
 		xValues[i] = float64(i)
-		yValues1[i] = d.Value().Seconds()
-		yValues2[i] = actualDelay.Seconds()
 	}
+	copy(yValues1, thresholds)
+	copy(yValues2, latencies)
 
 	// Add line series for the first curve (sine)
 	line1, err := plotter.NewLine(ConvertToXYs(xValues, yValues1))
@@ -180,12 +203,19 @@ func main() {
 	// Create a new plot
 	p := plot.New()
 
-	dataRows, err := GetDataRows("./metrics/req/")
+	dataRows, err := GetDataRows(*metricsDir)
 	if err != nil {
 		fmt.Println("Error while fetching datarows")
 		return
 	}
 
+	if *sweep {
+		if err := runSweep(dataRows); err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	// Set the title and axis labels
 	p.Title.Text = "Single Point Plot"
 	p.X.Label.Text = "X"
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raj-prince/custom-go-client-benchmark/util"
+	"golang.org/x/sync/errgroup"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/palette"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+)
+
+// sweepParams is one combination of a sweep.
+type sweepParams struct {
+	increaseRate     float64
+	targetPercentile float64
+	initialDelay     time.Duration
+}
+
+// name returns the plot file name of p, as gen_plot.sh used to, e.g.
+// p_90_r_15_i_30ms.
+func (p sweepParams) name() string {
+	return fmt.Sprintf("p_%s_r_%s_i_%v",
+		strconv.FormatFloat(math.Round(p.targetPercentile*1e4)/1e2, 'f', -1, 64),
+		strconv.FormatFloat(p.increaseRate, 'f', -1, 64),
+		p.initialDelay)
+}
+
+// sweepResult summarizes the replay of the samples through one combination.
+type sweepResult struct {
+	sweepParams
+
+	samples int
+	// overThreshold is the fraction of the samples over the delay, the
+	// target is 1 - targetPercentile.
+	overThreshold float64
+	// convergence is the number of samples after which the delay stays
+	// within --convergence-tolerance of the target percentile of the
+	// samples, -1 if it does not.
+	convergence int
+	// meanThreshold is the mean delay, in seconds.
+	meanThreshold float64
+}
+
+// parseSweepFloats parses a comma separated list of values, or a
+// start:end:step range, e.g. "1,15,100" or "0.1:0.9:0.4".
+func parseSweepFloats(s string) ([]float64, error) {
+	if parts := strings.Split(s, ":"); len(parts) == 3 {
+		var bounds [3]float64
+		for i, part := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return nil, err
+			}
+			bounds[i] = v
+		}
+		start, end, step := bounds[0], bounds[1], bounds[2]
+		if step <= 0 || end < start {
+			return nil, fmt.Errorf("invalid range %q: want start:end:step with start <= end and step > 0", s)
+		}
+		var values []float64
+		// The epsilon keeps end despite rounding errors.
+		for i := 0; start+float64(i)*step <= end+step*1e-9; i++ {
+			values = append(values, math.Round((start+float64(i)*step)*1e9)/1e9)
+		}
+		return values, nil
+	}
+
+	var values []float64
+	for _, field := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// parseSweepDurations parses a comma separated list of durations, or a
+// start:end:step range, e.g. "1ms,30ms,100ms" or "10ms:100ms:30ms".
+func parseSweepDurations(s string) ([]time.Duration, error) {
+	if parts := strings.Split(s, ":"); len(parts) == 3 {
+		var bounds [3]time.Duration
+		for i, part := range parts {
+			v, err := time.ParseDuration(strings.TrimSpace(part))
+			if err != nil {
+				return nil, err
+			}
+			bounds[i] = v
+		}
+		start, end, step := bounds[0], bounds[1], bounds[2]
+		if step <= 0 || end < start {
+			return nil, fmt.Errorf("invalid range %q: want start:end:step with start <= end and step > 0", s)
+		}
+		var values []time.Duration
+		for v := start; v <= end; v += step {
+			values = append(values, v)
+		}
+		return values, nil
+	}
+
+	var values []time.Duration
+	for _, field := range strings.Split(s, ",") {
+		v, err := time.ParseDuration(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// sweepGrid returns every combination of the --sweep-* values.
+func sweepGrid() ([]sweepParams, error) {
+	rates, err := parseSweepFloats(*sweepIncreaseRates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --sweep-increase-rates: %w", err)
+	}
+	percentiles, err := parseSweepFloats(*sweepTargetPercentiles)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --sweep-target-percentiles: %w", err)
+	}
+	delays, err := parseSweepDurations(*sweepInitialDelays)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --sweep-initial-delays: %w", err)
+	}
+
+	var grid []sweepParams
+	for _, p := range percentiles {
+		for _, r := range rates {
+			for _, d := range delays {
+				grid = append(grid, sweepParams{increaseRate: r, targetPercentile: p, initialDelay: d})
+			}
+		}
+	}
+	return grid, nil
+}
+
+// percentileOf returns the p-th percentile of the latencies of the first
+// sampleCount rows, in seconds.
+func percentileOf(dataRows []DataRow, sampleCount int, p float64) float64 {
+	n := min(sampleCount, len(dataRows))
+	if n == 0 {
+		return 0
+	}
+	latencies := make([]float64, n)
+	for i, row := range dataRows[:n] {
+		latencies[i] = row.ReadLatency
+	}
+	sort.Float64s(latencies)
+	return latencies[min(int(p*float64(n)), n-1)]
+}
+
+// evaluate replays the samples through the delay of params.
+func evaluate(dataRows []DataRow, params sweepParams) (sweepResult, error) {
+	d, err := util.NewDelay(params.targetPercentile, params.increaseRate, params.initialDelay, *minDelay, *maxDelay)
+	if err != nil {
+		return sweepResult{}, fmt.Errorf("%s: %w", params.name(), err)
+	}
+	thresholds, latencies, over := replay(dataRows, d, *sampleCount)
+
+	res := sweepResult{sweepParams: params, samples: len(thresholds), convergence: -1}
+	if res.samples == 0 {
+		return res, nil
+	}
+	res.overThreshold = float64(over) / float64(res.samples)
+
+	var sum float64
+	for _, t := range thresholds {
+		sum += t
+	}
+	res.meanThreshold = sum / float64(res.samples)
+
+	// The delay converged at the start of the last run of samples within
+	// the tolerance of the target.
+	target := percentileOf(dataRows, len(latencies), params.targetPercentile)
+	for i := len(thresholds) - 1; i >= 0; i-- {
+		if math.Abs(thresholds[i]-target) > *convergenceTolerance*target {
+			break
+		}
+		res.convergence = i
+	}
+
+	if *sweepPlots {
+		if err := savePlot(dataRows, params); err != nil {
+			return sweepResult{}, err
+		}
+	}
+	return res, nil
+}
+
+// savePlot saves the plot of the delay of params, as without --sweep.
+func savePlot(dataRows []DataRow, params sweepParams) error {
+	d, err := util.NewDelay(params.targetPercentile, params.increaseRate, params.initialDelay, *minDelay, *maxDelay)
+	if err != nil {
+		return err
+	}
+	p := plot.New()
+	p.Title.Text = params.name()
+	p.X.Label.Text = "Sample"
+	p.Y.Label.Text = "Seconds"
+	actualSample(p, dataRows, d)
+	return p.Save(65*vg.Inch, 30*vg.Inch, fmt.Sprintf("plots/%s.png", params.name()))
+}
+
+// runSweep evaluates every combination of the --sweep-* values concurrently,
+// and writes the summary CSV and heatmap.
+func runSweep(dataRows []DataRow) error {
+	grid, err := sweepGrid()
+	if err != nil {
+		return err
+	}
+
+	results := make([]sweepResult, len(grid))
+	var eG errgroup.Group
+	eG.SetLimit(runtime.NumCPU())
+	for i, params := range grid {
+		eG.Go(func() error {
+			res, err := evaluate(dataRows, params)
+			results[i] = res
+			return err
+		})
+	}
+	if err := eG.Wait(); err != nil {
+		return err
+	}
+
+	if err := writeSweepCSV(fmt.Sprintf("plots/%s.csv", *outputFile), results); err != nil {
+		return err
+	}
+	return plotSweepHeatmap(fmt.Sprintf("plots/%s.png", *outputFile), results)
+}
+
+// writeSweepCSV writes one row per combination to path.
+func writeSweepCSV(path string, results []sweepResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("while creating the sweep summary: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"target_percentile", "increase_rate", "initial_delay", "samples", "over_threshold", "convergence_samples", "mean_threshold_s"})
+	for _, r := range results {
+		w.Write([]string{
+			strconv.FormatFloat(r.targetPercentile, 'f', -1, 64),
+			strconv.FormatFloat(r.increaseRate, 'f', -1, 64),
+			r.initialDelay.String(),
+			strconv.Itoa(r.samples),
+			strconv.FormatFloat(r.overThreshold, 'f', 4, 64),
+			strconv.Itoa(r.convergence),
+			strconv.FormatFloat(r.meanThreshold, 'f', 6, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("while writing the sweep summary: %w", err)
+	}
+	return f.Close()
+}
+
+// sweepGridXYZ is the heatmap grid of the over-threshold error of one initial
+// delay: columns are the increase rates, rows the target percentiles.
+type sweepGridXYZ struct {
+	rates       []float64
+	percentiles []float64
+	values      map[[2]int]float64
+}
+
+func (g sweepGridXYZ) Dims() (c, r int)   { return len(g.rates), len(g.percentiles) }
+func (g sweepGridXYZ) Z(c, r int) float64 { return g.values[[2]int{c, r}] }
+func (g sweepGridXYZ) X(c int) float64    { return float64(c) }
+func (g sweepGridXYZ) Y(r int) float64    { return float64(r) }
+
+// sortedUnique returns the distinct values of vs in increasing order.
+func sortedUnique(vs []float64) []float64 {
+	sort.Float64s(vs)
+	var unique []float64
+	for i, v := range vs {
+		if i == 0 || v != vs[i-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// plotSweepHeatmap draws one heatmap per initial delay of how far the
+// over-threshold fraction is from its target, 1 - target percentile.
+func plotSweepHeatmap(path string, results []sweepResult) error {
+	var rates, percentiles []float64
+	byDelay := make(map[time.Duration][]sweepResult)
+	var delays []time.Duration
+	for _, r := range results {
+		rates = append(rates, r.increaseRate)
+		percentiles = append(percentiles, r.targetPercentile)
+		if _, ok := byDelay[r.initialDelay]; !ok {
+			delays = append(delays, r.initialDelay)
+		}
+		byDelay[r.initialDelay] = append(byDelay[r.initialDelay], r)
+	}
+	rates, percentiles = sortedUnique(rates), sortedUnique(percentiles)
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+
+	index := func(vs []float64, v float64) int { return sort.SearchFloat64s(vs, v) }
+	ticks := func(vs []float64, format func(float64) string) plot.ConstantTicks {
+		t := make(plot.ConstantTicks, len(vs))
+		for i, v := range vs {
+			t[i] = plot.Tick{Value: float64(i), Label: format(v)}
+		}
+		return t
+	}
+
+	// All heatmaps share the same color scale.
+	maxErr := 0.0
+	for _, r := range results {
+		maxErr = math.Max(maxErr, math.Abs(r.overThreshold-(1-r.targetPercentile)))
+	}
+	if maxErr == 0 {
+		maxErr = 1
+	}
+	pal := palette.Heat(12, 1)
+
+	plots := make([][]*plot.Plot, 1)
+	for _, delay := range delays {
+		g := sweepGridXYZ{rates: rates, percentiles: percentiles, values: make(map[[2]int]float64)}
+		var labels plotter.XYLabels
+		for _, r := range byDelay[delay] {
+			c, row := index(rates, r.increaseRate), index(percentiles, r.targetPercentile)
+			err := math.Abs(r.overThreshold - (1 - r.targetPercentile))
+			g.values[[2]int{c, row}] = err
+			labels.XYs = append(labels.XYs, plotter.XY{X: float64(c), Y: float64(row)})
+			labels.Labels = append(labels.Labels, fmt.Sprintf("%.3f", r.overThreshold))
+		}
+
+		h := plotter.NewHeatMap(g, pal)
+		h.Min, h.Max = 0, maxErr
+		l, err := plotter.NewLabels(labels)
+		if err != nil {
+			return fmt.Errorf("while plotting the sweep heatmap: %w", err)
+		}
+
+		p := plot.New()
+		p.Title.Text = fmt.Sprintf("Over-threshold fraction, initial delay %v\n(color: distance to 1 - target percentile)", delay)
+		p.X.Label.Text = "Increase rate"
+		p.Y.Label.Text = "Target percentile"
+		p.X.Tick.Marker = ticks(rates, func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) })
+		p.Y.Tick.Marker = ticks(percentiles, func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) })
+		p.Add(h, l)
+		plots[0] = append(plots[0], p)
+	}
+
+	const panelWidth, panelHeight = 6 * vg.Inch, 5 * vg.Inch
+	img := vgimg.New(panelWidth*vg.Length(len(plots[0])), panelHeight)
+	dc := draw.New(img)
+	tiles := draw.Tiles{Rows: 1, Cols: len(plots[0]), PadX: vg.Millimeter, PadY: vg.Millimeter}
+	canvases := plot.Align(plots, tiles, dc)
+	for i, p := range plots[0] {
+		p.Draw(canvases[0][i])
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("while creating the sweep heatmap: %w", err)
+	}
+	defer f.Close()
+	if _, err := (vgimg.PngCanvas{Canvas: img}).WriteTo(f); err != nil {
+		return fmt.Errorf("while writing the sweep heatmap: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSweepFloats(t *testing.T) {
+	tests := []struct {
+		name        string
+		s           string
+		want        []float64
+		errContains string
+	}{
+		{name: "single", s: "15", want: []float64{15}},
+		{name: "list", s: "1, 15,100", want: []float64{1, 15, 100}},
+		{name: "range", s: "0.1:0.9:0.4", want: []float64{0.1, 0.5, 0.9}},
+		// Rounding errors don't drop the end of the range.
+		{name: "range keeps end", s: "0.1:0.3:0.1", want: []float64{0.1, 0.2, 0.3}},
+		{name: "range of one", s: "5:5:1", want: []float64{5}},
+		{name: "range end between steps", s: "1:4:2", want: []float64{1, 3}},
+		{name: "invalid value", s: "1,x", errContains: "invalid syntax"},
+		{name: "empty", s: "", errContains: "invalid syntax"},
+		{name: "invalid range bound", s: "1:x:1", errContains: "invalid syntax"},
+		{name: "zero step", s: "1:5:0", errContains: "invalid range"},
+		{name: "decreasing range", s: "5:1:1", errContains: "invalid range"},
+		{name: "two bounds", s: "1:5", errContains: "invalid syntax"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSweepFloats(tt.s)
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("parseSweepFloats(%q) error = %v, want it to contain %q", tt.s, err, tt.errContains)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseSweepFloats(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSweepDurations(t *testing.T) {
+	tests := []struct {
+		name        string
+		s           string
+		want        []time.Duration
+		errContains string
+	}{
+		{name: "list", s: "1ms, 30ms,100ms", want: []time.Duration{time.Millisecond, 30 * time.Millisecond, 100 * time.Millisecond}},
+		{name: "range", s: "10ms:100ms:30ms", want: []time.Duration{10 * time.Millisecond, 40 * time.Millisecond, 70 * time.Millisecond, 100 * time.Millisecond}},
+		{name: "missing unit", s: "10", errContains: "missing unit"},
+		{name: "zero step", s: "1ms:5ms:0s", errContains: "invalid range"},
+		{name: "decreasing range", s: "5ms:1ms:1ms", errContains: "invalid range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSweepDurations(tt.s)
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("parseSweepDurations(%q) error = %v, want it to contain %q", tt.s, err, tt.errContains)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseSweepDurations(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+			}
+		})
+	}
+}
+
+// latencyRows returns n rows of the given read latency.
+func latencyRows(n int, latency time.Duration) []DataRow {
+	rows := make([]DataRow, n)
+	for i := range rows {
+		rows[i].ReadLatency = latency.Seconds()
+	}
+	return rows
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name   string
+		rows   []DataRow
+		params sweepParams
+		want   sweepResult
+	}{
+		{
+			// Every latency is over the delay, which doubles after each:
+			// 20ms, 40ms, 80ms, far from the 1s target.
+			name:   "not converged",
+			rows:   latencyRows(3, time.Second),
+			params: sweepParams{increaseRate: 1, targetPercentile: 0.5, initialDelay: 10 * time.Millisecond},
+			want:   sweepResult{samples: 3, overThreshold: 1, convergence: -1, meanThreshold: 0.14 / 3},
+		},
+		{
+			// The delay starts at the latencies and stays at the min delay.
+			name:   "converged",
+			rows:   latencyRows(4, 10*time.Millisecond),
+			params: sweepParams{increaseRate: 1, targetPercentile: 0.5, initialDelay: 10 * time.Millisecond},
+			want:   sweepResult{samples: 4, overThreshold: 0, convergence: 0, meanThreshold: 0.01},
+		},
+		{
+			name:   "no rows",
+			params: sweepParams{increaseRate: 1, targetPercentile: 0.5, initialDelay: 10 * time.Millisecond},
+			want:   sweepResult{convergence: -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evaluate(tt.rows, tt.params)
+			if err != nil {
+				t.Fatalf("evaluate() error = %v", err)
+			}
+			tt.want.sweepParams = tt.params
+			if got.sweepParams != tt.want.sweepParams || got.samples != tt.want.samples ||
+				got.overThreshold != tt.want.overThreshold || got.convergence != tt.want.convergence ||
+				math.Abs(got.meanThreshold-tt.want.meanThreshold) > 1e-9 {
+				t.Fatalf("evaluate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateInvalidParams(t *testing.T) {
+	if _, err := evaluate(latencyRows(1, time.Second), sweepParams{increaseRate: 0, targetPercentile: 0.5}); err == nil {
+		t.Fatal("evaluate() with a zero increase rate should fail")
+	}
+}
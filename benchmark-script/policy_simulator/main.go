@@ -0,0 +1,156 @@
+// policy_simulator replays the read latencies recorded in metrics CSV files
+// (see dynamic_delay_plotter/metrics/{req,read}) through candidate stall
+// timeout and hedging policies, and compares the simulated tail latency,
+// extra request cost and trigger rate of every policy:
+//
+//	go run . --timeouts 100ms,500ms --percentiles 0.9,0.99 \
+//	  --hedge-percentiles 0.95,0.99 --hedge-budgets 0.01,0.05
+//
+// The comparison table is printed and written to <output-dir>/policies.csv,
+// along with a tail latency and a cost plot per metrics directory.
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/raj-prince/custom-go-client-benchmark/util"
+)
+
+var (
+	fMetricsDirs = flag.String("metrics-dirs", "../dynamic_delay_plotter/metrics/req,../dynamic_delay_plotter/metrics/read", "Comma separated directories of metrics CSV files, each one is simulated separately")
+	fOutputDir   = flag.String("output-dir", "results", "Directory of the comparison CSV and plots")
+
+	// Candidate policies, see policy.go.
+	fTimeouts         = flag.String("timeouts", "100ms,500ms,1s", "Comma separated fixed stall timeouts")
+	fPercentiles      = flag.String("percentiles", "0.9,0.99,0.999", "Comma separated target percentiles of util.Delay stall timeouts")
+	fHedgePercentiles = flag.String("hedge-percentiles", "0.9,0.99", "Comma separated target percentiles of util.Delay hedge delays")
+	fHedgeBudgets     = flag.String("hedge-budgets", "0.01,0.05,1", "Comma separated maximum fractions of hedged reads")
+	fMaxAttempts      = flag.Int("max-attempts", 10, "Maximum attempts of a read with a stall timeout")
+
+	// util.Delay arguments.
+	fIncreaseRate = flag.Float64("increase-rate", 15, "Increase rate of util.Delay")
+	fInitialDelay = flag.Duration("initial-delay", 500*time.Millisecond, "Initial delay of util.Delay")
+	fMinDelay     = flag.Duration("min-delay", 10*time.Millisecond, "Min delay of util.Delay")
+	fMaxDelay     = flag.Duration("max-delay", 10*time.Minute, "Max delay of util.Delay")
+
+	fSeed = flag.Int64("seed", 1, "Seed of the latencies drawn for retried and backup requests")
+)
+
+// result is the outcome of a policy on the traces of a metrics directory.
+type result struct {
+	dataset string
+	*outcome
+}
+
+func main() {
+	flag.Parse()
+
+	ps, err := policies()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *fMaxAttempts < 1 {
+		log.Fatalf("invalid --max-attempts (%d): must be >= 1", *fMaxAttempts)
+	}
+	cfg := delayConfig{
+		increaseRate: *fIncreaseRate,
+		initialDelay: *fInitialDelay,
+		minDelay:     *fMinDelay,
+		maxDelay:     *fMaxDelay,
+	}
+	if err := os.MkdirAll(*fOutputDir, 0o755); err != nil {
+		log.Fatalf("while creating the output directory: %v", err)
+	}
+
+	var results []result
+	for _, dir := range strings.Split(*fMetricsDirs, ",") {
+		traces, err := util.ReadLatencyTraces(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dataset := filepath.Base(dir)
+
+		var outcomes []*outcome
+		for _, p := range ps {
+			o, err := simulate(p, traces, cfg, *fMaxAttempts, *fSeed)
+			if err != nil {
+				log.Fatal(err)
+			}
+			outcomes = append(outcomes, o)
+			results = append(results, result{dataset: dataset, outcome: o})
+		}
+
+		if err := plotTail(filepath.Join(*fOutputDir, dataset+"_tail.png"), dataset, outcomes); err != nil {
+			log.Fatal(err)
+		}
+		if err := plotCost(filepath.Join(*fOutputDir, dataset+"_cost.png"), dataset, outcomes); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	printResults(results)
+	if err := writeResults(filepath.Join(*fOutputDir, "policies.csv"), results); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// columns of the comparison table.
+var columns = []string{"dataset", "policy", "reads", "p50_ms", "p90_ms", "p99_ms", "p99_9_ms", "max_ms", "mean_ms", "trigger_rate", "extra_cost"}
+
+func (r result) row() []string {
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	}
+	return []string{
+		r.dataset,
+		r.policy.name,
+		strconv.Itoa(len(r.latencies)),
+		ms(r.percentile(0.5)),
+		ms(r.percentile(0.9)),
+		ms(r.percentile(0.99)),
+		ms(r.percentile(0.999)),
+		ms(r.percentile(1)),
+		ms(r.mean()),
+		strconv.FormatFloat(r.triggerRate(), 'f', 4, 64),
+		strconv.FormatFloat(r.extraCost(), 'f', 4, 64),
+	}
+}
+
+// printResults prints the comparison table to stdout.
+func printResults(results []result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, strings.Join(columns, "\t")+"\t")
+	for _, r := range results {
+		fmt.Fprintln(w, strings.Join(r.row(), "\t")+"\t")
+	}
+	w.Flush()
+}
+
+// writeResults writes the comparison table as CSV to path.
+func writeResults(path string, results []result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("while creating the results file: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write(columns)
+	for _, r := range results {
+		w.Write(r.row())
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("while writing the results: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// tailPercentiles are the points of the tail latency plot.
+var tailPercentiles = func() []float64 {
+	var ps []float64
+	// From p50 to p99.99, evenly spaced in number of nines.
+	for nines := math.Log10(2); nines <= 4; nines += 0.05 {
+		ps = append(ps, 1-math.Pow(10, -nines))
+	}
+	return ps
+}()
+
+// plotTail plots the simulated latency against the percentile of every
+// outcome to path.
+func plotTail(path, dataset string, outcomes []*outcome) error {
+	p := plot.New()
+	p.Title.Text = fmt.Sprintf("Simulated tail latency (%s)", dataset)
+	p.X.Label.Text = "Percentile"
+	p.Y.Label.Text = "Latency (ms)"
+	p.Y.Scale = plot.LogScale{}
+	p.Y.Tick.Marker = plot.LogTicks{}
+	// X is the number of nines of the percentile.
+	p.X.Tick.Marker = plot.ConstantTicks([]plot.Tick{
+		{Value: math.Log10(2), Label: "p50"},
+		{Value: 1, Label: "p90"},
+		{Value: 2, Label: "p99"},
+		{Value: 3, Label: "p99.9"},
+		{Value: 4, Label: "p99.99"},
+	})
+	p.Legend.Top = true
+	p.Legend.Left = true
+
+	var lines []interface{}
+	for _, o := range outcomes {
+		xys := make(plotter.XYs, len(tailPercentiles))
+		for i, pct := range tailPercentiles {
+			xys[i].X = -math.Log10(1 - pct)
+			// The log scale needs positive values.
+			xys[i].Y = math.Max(toMs(o.percentile(pct)), 1e-3)
+		}
+		lines = append(lines, o.policy.name, xys)
+	}
+	if err := plotutil.AddLines(p, lines...); err != nil {
+		return fmt.Errorf("while plotting %s: %w", path, err)
+	}
+	if err := p.Save(12*vg.Inch, 8*vg.Inch, path); err != nil {
+		return fmt.Errorf("while saving %s: %w", path, err)
+	}
+	return nil
+}
+
+// plotCost plots the p99 latency against the extra request cost of every
+// outcome to path.
+func plotCost(path, dataset string, outcomes []*outcome) error {
+	p := plot.New()
+	p.Title.Text = fmt.Sprintf("p99 latency vs extra requests (%s)", dataset)
+	p.X.Label.Text = "Extra requests per read"
+	p.Y.Label.Text = "p99 latency (ms)"
+
+	xys := make(plotter.XYs, len(outcomes))
+	labels := make([]string, len(outcomes))
+	for i, o := range outcomes {
+		xys[i].X = o.extraCost()
+		xys[i].Y = toMs(o.percentile(0.99))
+		labels[i] = o.policy.name
+	}
+	s, err := plotter.NewScatter(xys)
+	if err != nil {
+		return fmt.Errorf("while plotting %s: %w", path, err)
+	}
+	l, err := plotter.NewLabels(plotter.XYLabels{XYs: xys, Labels: labels})
+	if err != nil {
+		return fmt.Errorf("while plotting %s: %w", path, err)
+	}
+	p.Add(s, l, plotter.NewGrid())
+
+	if err := p.Save(12*vg.Inch, 8*vg.Inch, path); err != nil {
+		return fmt.Errorf("while saving %s: %w", path, err)
+	}
+	return nil
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raj-prince/custom-go-client-benchmark/util"
+)
+
+// Policy kinds.
+const (
+	// kindNone replays the latencies as recorded.
+	kindNone = "none"
+	// kindTimeout cancels and retries a request slower than a fixed timeout.
+	kindTimeout = "timeout"
+	// kindDelay cancels and retries a request slower than a util.Delay, as
+	// the read stall retry of the client does.
+	kindDelay = "delay"
+	// kindHedge sends a backup request when a request is slower than a
+	// util.Delay, within a budget, and keeps the first response.
+	kindHedge = "hedge"
+)
+
+// policy is a candidate policy to replay the traces through.
+type policy struct {
+	name string
+	kind string
+
+	// timeout of kindTimeout.
+	timeout time.Duration
+	// percentile of the util.Delay of kindDelay and kindHedge.
+	percentile float64
+	// budget is the maximum number of backup requests per request of
+	// kindHedge, 1 hedges every slow request.
+	budget float64
+}
+
+// delayConfig holds the util.Delay arguments shared by the policies.
+type delayConfig struct {
+	increaseRate float64
+	initialDelay time.Duration
+	minDelay     time.Duration
+	maxDelay     time.Duration
+}
+
+// policies returns the candidate policies set by the flags, starting with
+// kindNone.
+func policies() ([]policy, error) {
+	ps := []policy{{name: kindNone, kind: kindNone}}
+
+	timeouts, err := parseDurations(*fTimeouts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --timeouts: %w", err)
+	}
+	for _, t := range timeouts {
+		ps = append(ps, policy{name: "timeout=" + t.String(), kind: kindTimeout, timeout: t})
+	}
+
+	percentiles, err := parseFloats(*fPercentiles)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --percentiles: %w", err)
+	}
+	for _, p := range percentiles {
+		ps = append(ps, policy{name: "delay=p" + formatPercentile(p), kind: kindDelay, percentile: p})
+	}
+
+	hedgePercentiles, err := parseFloats(*fHedgePercentiles)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --hedge-percentiles: %w", err)
+	}
+	budgets, err := parseFloats(*fHedgeBudgets)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --hedge-budgets: %w", err)
+	}
+	for _, p := range hedgePercentiles {
+		for _, b := range budgets {
+			if b <= 0 || b > 1 {
+				return nil, fmt.Errorf("invalid --hedge-budgets (%v): must be in (0, 1]", b)
+			}
+			ps = append(ps, policy{
+				name:       fmt.Sprintf("hedge=p%s,budget=%s", formatPercentile(p), strconv.FormatFloat(b, 'g', -1, 64)),
+				kind:       kindHedge,
+				percentile: p,
+				budget:     b,
+			})
+		}
+	}
+	return ps, nil
+}
+
+// outcome is the result of replaying traces through a policy.
+type outcome struct {
+	policy policy
+
+	// latencies are the simulated latencies seen by the caller, sorted.
+	latencies []time.Duration
+	// triggered is the number of requests which were retried or hedged.
+	triggered int
+	// extra is the number of requests sent on top of one per read.
+	extra int
+}
+
+// percentile returns the p-th percentile of the simulated latencies.
+func (o *outcome) percentile(p float64) time.Duration {
+	if len(o.latencies) == 0 {
+		return 0
+	}
+	i := int(p * float64(len(o.latencies)))
+	return o.latencies[min(i, len(o.latencies)-1)]
+}
+
+// mean returns the mean simulated latency.
+func (o *outcome) mean() time.Duration {
+	if len(o.latencies) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, l := range o.latencies {
+		sum += l
+	}
+	return sum / time.Duration(len(o.latencies))
+}
+
+// triggerRate returns the fraction of the reads which were retried or
+// hedged.
+func (o *outcome) triggerRate() float64 {
+	if len(o.latencies) == 0 {
+		return 0
+	}
+	return float64(o.triggered) / float64(len(o.latencies))
+}
+
+// extraCost returns the number of extra requests per read.
+func (o *outcome) extraCost() float64 {
+	if len(o.latencies) == 0 {
+		return 0
+	}
+	return float64(o.extra) / float64(len(o.latencies))
+}
+
+// simulate replays every trace through its own instance of p, as every
+// trace was recorded by a separate client.
+//
+// The recorded latency is the one of the first request of a read. The
+// latency of a retried or backup request is unknown, it is drawn from the
+// latencies of the same trace, i.e. requests are assumed independent.
+func simulate(p policy, traces []util.LatencyTrace, cfg delayConfig, maxAttempts int, seed int64) (*outcome, error) {
+	o := &outcome{policy: p}
+	for i, trace := range traces {
+		rnd := rand.New(rand.NewSource(seed + int64(i)))
+		sample := func() time.Duration {
+			return trace.Latencies[rnd.Intn(len(trace.Latencies))]
+		}
+
+		var delay *util.Delay
+		if p.kind == kindDelay || p.kind == kindHedge {
+			var err error
+			delay, err = util.NewDelay(p.percentile, cfg.increaseRate, cfg.initialDelay, cfg.minDelay, cfg.maxDelay)
+			if err != nil {
+				return nil, fmt.Errorf("policy %s: %w", p.name, err)
+			}
+		}
+
+		// tokens are the backup requests the hedge budget allows, up to
+		// one.
+		tokens := 0.0
+		for _, latency := range trace.Latencies {
+			switch p.kind {
+			case kindNone:
+				o.latencies = append(o.latencies, latency)
+
+			case kindTimeout, kindDelay:
+				var total time.Duration
+				for attempt := 1; ; attempt++ {
+					timeout := p.timeout
+					if delay != nil {
+						timeout = delay.Value()
+						delay.Update(latency)
+					}
+					if latency <= timeout || attempt == maxAttempts {
+						total += latency
+						break
+					}
+					if attempt == 1 {
+						o.triggered++
+					}
+					o.extra++
+					total += timeout
+					latency = sample()
+				}
+				o.latencies = append(o.latencies, total)
+
+			case kindHedge:
+				tokens = min(tokens+p.budget, 1)
+				threshold := delay.Value()
+				if latency > threshold && tokens >= 1 {
+					tokens--
+					o.triggered++
+					o.extra++
+					latency = min(latency, threshold+sample())
+				}
+				// As util.Hedger, the delay adapts to the latency seen by
+				// the caller.
+				delay.Update(latency)
+				o.latencies = append(o.latencies, latency)
+			}
+		}
+	}
+	sort.Slice(o.latencies, func(i, j int) bool { return o.latencies[i] < o.latencies[j] })
+	return o, nil
+}
+
+// parseFloats parses a comma separated list of floats, an empty list is
+// valid.
+func parseFloats(s string) ([]float64, error) {
+	var values []float64
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// parseDurations parses a comma separated list of durations, an empty list
+// is valid.
+func parseDurations(s string) ([]time.Duration, error) {
+	var values []time.Duration
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		v, err := time.ParseDuration(field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// formatPercentile formats 0.999 as "99.9".
+func formatPercentile(p float64) string {
+	return strconv.FormatFloat(p*100, 'g', -1, 64)
+}
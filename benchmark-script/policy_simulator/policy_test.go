@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/raj-prince/custom-go-client-benchmark/util"
+)
+
+// testTraces returns two traces of 100 reads of 10ms, every tenth of 200ms.
+func testTraces() []util.LatencyTrace {
+	var latencies []time.Duration
+	for i := 0; i < 100; i++ {
+		if i%10 == 9 {
+			latencies = append(latencies, 200*time.Millisecond)
+		} else {
+			latencies = append(latencies, 10*time.Millisecond)
+		}
+	}
+	return []util.LatencyTrace{{Name: "a", Latencies: latencies}, {Name: "b", Latencies: latencies}}
+}
+
+func TestSimulate(t *testing.T) {
+	cfg := delayConfig{increaseRate: 15, initialDelay: 30 * time.Millisecond, minDelay: time.Millisecond, maxDelay: time.Minute}
+	tests := []struct {
+		name          string
+		policy        policy
+		wantTriggered int
+		wantExtra     int
+		wantP99       time.Duration
+	}{
+		{
+			name:    "none",
+			policy:  policy{name: kindNone, kind: kindNone},
+			wantP99: 200 * time.Millisecond,
+		},
+		{
+			// Every slow read is retried, one of the retries is slow
+			// again and retried once more.
+			name:          "timeout",
+			policy:        policy{name: "timeout=50ms", kind: kindTimeout, timeout: 50 * time.Millisecond},
+			wantTriggered: 20,
+			wantExtra:     21,
+			wantP99:       60 * time.Millisecond,
+		},
+		{
+			name:          "delay",
+			policy:        policy{name: "delay=p50", kind: kindDelay, percentile: 0.5},
+			wantTriggered: 138,
+			wantExtra:     150,
+			wantP99:       30260636 * time.Nanosecond,
+		},
+		{
+			// The budget allows 5 backup requests per trace of 100 reads.
+			name:          "hedge",
+			policy:        policy{name: "hedge=p50,budget=0.05", kind: kindHedge, percentile: 0.5, budget: 0.05},
+			wantTriggered: 10,
+			wantExtra:     10,
+			wantP99:       200 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := simulate(tt.policy, testTraces(), cfg, 3, 1)
+			if err != nil {
+				t.Fatalf("simulate() error = %v", err)
+			}
+			if len(o.latencies) != 200 || o.triggered != tt.wantTriggered || o.extra != tt.wantExtra {
+				t.Fatalf("simulate() = %d reads, %d triggered, %d extra, want 200, %d, %d",
+					len(o.latencies), o.triggered, o.extra, tt.wantTriggered, tt.wantExtra)
+			}
+			if got := o.percentile(0.99); got != tt.wantP99 {
+				t.Fatalf("p99 = %v, want %v", got, tt.wantP99)
+			}
+
+			// The same seed replays the same outcome.
+			again, err := simulate(tt.policy, testTraces(), cfg, 3, 1)
+			if err != nil {
+				t.Fatalf("simulate() error = %v", err)
+			}
+			if !reflect.DeepEqual(again, o) {
+				t.Fatal("simulate() with the same seed has a different outcome")
+			}
+		})
+	}
+}
+
+func TestSimulateInvalidDelay(t *testing.T) {
+	cfg := delayConfig{increaseRate: 15, minDelay: time.Second, maxDelay: time.Millisecond}
+	if _, err := simulate(policy{name: "delay=p50", kind: kindDelay, percentile: 0.5}, testTraces(), cfg, 3, 1); err == nil {
+		t.Fatal("simulate() with a min delay above the max delay should fail")
+	}
+}
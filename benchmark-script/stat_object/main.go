@@ -15,22 +15,42 @@ import (
 
 	"cloud.google.com/go/storage"
 	"github.com/googleapis/gax-go/v2"
+	"github.com/raj-prince/custom-go-client-benchmark/fakegcs"
+	"github.com/raj-prince/custom-go-client-benchmark/util"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 
 	_ "google.golang.org/grpc/balancer/rls"
 	_ "google.golang.org/grpc/xds/googledirectpath"
 )
 
 var (
-	bucketName    = flag.String("bucket", "princer-working-dirs", "GCS bucket name.")
-	objectPrefix  = flag.String("obj-prefix", "grpc_test.txt", "Object prefix.")
-	objectSuffix  = flag.String("obj-suffix", "", "Object suffix.")
-	numOfWorkers  = flag.Int("workers", 4, "Number of concurrent workers (threads).")
-	numOfCalls    = flag.Int("calls", 50, "Number of stat calls per worker.")
-	maxConns      = flag.Int("max-conns", 100, "Max connections per host for HTTP client.")
+	bucketName   = flag.String("bucket", "princer-working-dirs", "GCS bucket name.")
+	objectPrefix = flag.String("obj-prefix", "grpc_test.txt", "Object prefix.")
+	objectSuffix = flag.String("obj-suffix", "", "Object suffix.")
+	numOfWorkers = flag.Int("workers", 4, "Number of concurrent workers (threads).")
+	numOfCalls   = flag.Int("calls", 50, "Number of stat calls per worker.")
+	maxConns     = flag.Int("max-conns", 100, "Max connections per host for HTTP client.")
+
+	// Endpoint overrides, e.g. to target an emulator. Requests sent to an
+	// overridden endpoint are unauthenticated and, for gRPC, in plaintext.
+	httpEndpoint = flag.String("http-endpoint", "", "Override the HTTP endpoint, e.g. http://localhost:9000/storage/v1/")
+	grpcEndpoint = flag.String("grpc-endpoint", "", "Override the gRPC endpoint, e.g. localhost:9001")
+
+	// Open-loop mode: stat calls are issued at a fixed arrival rate, each
+	// worker serving one call at a time.
+	qps                 = flag.Float64("qps", 0, "Issue stat calls at this rate across all workers (open loop), 0 keeps each worker calling back to back.")
+	arrivalDistribution = flag.String("arrival-distribution", util.PoissonArrivals, "Inter-arrival times of the open loop: poisson or constant.")
+	maxBacklog          = flag.Int("max-backlog", 1000, "Calls of the open loop waiting for a free worker, later ones are dropped.")
+
+	// In-process fake GCS holding one object per worker.
+	fakeGCS       = flag.Bool("fake-gcs", false, "Run against an in-process fake GCS instead of the real service.")
+	fakeLatency   = flag.Duration("fake-latency", 0, "Latency added by the fake GCS to every request.")
+	fakeErrorRate = flag.Float64("fake-error-rate", 0, "Probability that the fake GCS fails a request with a retryable error.")
 )
 
 type Result struct {
@@ -42,7 +62,13 @@ type Result struct {
 	P50Latency float64
 	P90Latency float64
 	P99Latency float64
-	Error      string
+	// Dropped is the number of open loop calls dropped because the backlog
+	// was full, Unissued the number left in the backlog when the run stopped,
+	// and PeakBacklog the largest backlog seen.
+	Dropped     int
+	Unissued    int
+	PeakBacklog int
+	Error       string
 }
 
 func CreateHTTP1Client(ctx context.Context) (*storage.Client, error) {
@@ -52,6 +78,12 @@ func CreateHTTP1Client(ctx context.Context) (*storage.Client, error) {
 		TLSNextProto:        make(map[string]func(string, *tls.Conn) http.RoundTripper),
 	}
 
+	if *httpEndpoint != "" {
+		return storage.NewClient(ctx,
+			option.WithEndpoint(*httpEndpoint),
+			option.WithHTTPClient(&http.Client{Transport: transport}))
+	}
+
 	tokenSource, err := google.DefaultTokenSource(ctx, "https://www.googleapis.com/auth/devstorage.full_control")
 	if err != nil {
 		return nil, err
@@ -69,6 +101,15 @@ func CreateHTTP1Client(ctx context.Context) (*storage.Client, error) {
 }
 
 func CreateGrpcClient(ctx context.Context, directPath bool) (*storage.Client, error) {
+	if *grpcEndpoint != "" {
+		return storage.NewGRPCClient(ctx,
+			option.WithEndpoint(*grpcEndpoint),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+			option.WithGRPCConnectionPool(1),
+			storage.WithDisabledClientMetrics())
+	}
+
 	if directPath {
 		os.Setenv("GOOGLE_CLOUD_DISABLE_DIRECT_PATH", "false")
 	} else {
@@ -99,32 +140,37 @@ func runBenchmark(ctx context.Context, name string, client *storage.Client) *Res
 	var eG errgroup.Group
 	benchStart := time.Now()
 
-	for i := 0; i < *numOfWorkers; i++ {
-		idx := i
-		eG.Go(func() error {
-			// Construct object name matching worker index.
-			// E.g., prefix0, prefix1, etc.
-			objectName := *objectPrefix + strconv.Itoa(idx) + *objectSuffix
-			object := bucketHandle.Object(objectName)
-
-			localLatencies := make([]time.Duration, 0, *numOfCalls)
-			for j := 0; j < *numOfCalls; j++ {
-				start := time.Now()
-				_, err := object.Attrs(ctx)
-				if err != nil {
-					return fmt.Errorf("attrs failed for object %s: %w", objectName, err)
+	var openLoopStats util.OpenLoopStats
+	var err error
+	if *qps > 0 {
+		latencies, openLoopStats, err = runOpenLoop(ctx, bucketHandle)
+	} else {
+		for i := 0; i < *numOfWorkers; i++ {
+			idx := i
+			eG.Go(func() error {
+				// Construct object name matching worker index.
+				// E.g., prefix0, prefix1, etc.
+				objectName := *objectPrefix + strconv.Itoa(idx) + *objectSuffix
+				object := bucketHandle.Object(objectName)
+
+				localLatencies := make([]time.Duration, 0, *numOfCalls)
+				for j := 0; j < *numOfCalls; j++ {
+					start := time.Now()
+					_, err := object.Attrs(ctx)
+					if err != nil {
+						return fmt.Errorf("attrs failed for object %s: %w", objectName, err)
+					}
+					localLatencies = append(localLatencies, time.Since(start))
 				}
-				localLatencies = append(localLatencies, time.Since(start))
-			}
 
-			mu.Lock()
-			latencies = append(latencies, localLatencies...)
-			mu.Unlock()
-			return nil
-		})
+				mu.Lock()
+				latencies = append(latencies, localLatencies...)
+				mu.Unlock()
+				return nil
+			})
+		}
+		err = eG.Wait()
 	}
-
-	err := eG.Wait()
 	benchDuration := time.Since(benchStart)
 	if err != nil {
 		return &Result{
@@ -157,21 +203,90 @@ func runBenchmark(ctx context.Context, name string, client *storage.Client) *Res
 	qps := float64(totalOps) / benchDuration.Seconds()
 
 	return &Result{
-		Name:       name,
-		TotalOps:   totalOps,
-		Duration:   benchDuration,
-		QPS:        qps,
-		AvgLatency: avgLatency,
-		P50Latency: p50,
-		P90Latency: p90,
-		P99Latency: p99,
+		Name:        name,
+		TotalOps:    totalOps,
+		Duration:    benchDuration,
+		QPS:         qps,
+		AvgLatency:  avgLatency,
+		P50Latency:  p50,
+		P90Latency:  p90,
+		P99Latency:  p99,
+		Dropped:     int(openLoopStats.Dropped),
+		Unissued:    int(openLoopStats.Unissued),
+		PeakBacklog: openLoopStats.PeakBacklog,
 	}
 }
 
+// runOpenLoop issues numOfWorkers * numOfCalls stat calls at --qps and
+// returns their latencies, measured from their intended send time, and the
+// arrival accounting of the loop. Worker i stats object i, a failed call
+// doesn't stop the run and the first error is returned.
+func runOpenLoop(ctx context.Context, bucketHandle *storage.BucketHandle) ([]time.Duration, util.OpenLoopStats, error) {
+	loop, err := util.NewOpenLoop(util.OpenLoopConfig{
+		Rate:         *qps,
+		Distribution: *arrivalDistribution,
+		Workers:      *numOfWorkers,
+		MaxBacklog:   *maxBacklog,
+		MaxArrivals:  uint64(*numOfWorkers * *numOfCalls),
+		Seed:         time.Now().UnixNano(),
+	})
+	if err != nil {
+		return nil, util.OpenLoopStats{}, err
+	}
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+	var firstErr error
+	stats := loop.Run(ctx, func(ctx context.Context, worker int, intended time.Time) {
+		objectName := *objectPrefix + strconv.Itoa(worker) + *objectSuffix
+		_, err := bucketHandle.Object(objectName).Attrs(ctx)
+		latency := time.Since(intended)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("attrs failed for object %s: %w", objectName, err)
+			}
+			return
+		}
+		latencies = append(latencies, latency)
+	})
+	return latencies, stats, firstErr
+}
+
+// startFakeGCS starts an in-process fake GCS holding one object per worker
+// and points both endpoint overrides at it. The returned func stops it.
+func startFakeGCS() (func(), error) {
+	server, err := fakegcs.NewServer(fakegcs.Options{
+		Latency:   *fakeLatency,
+		ErrorRate: *fakeErrorRate,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < *numOfWorkers; i++ {
+		server.PutRandomObject(*bucketName, *objectPrefix+strconv.Itoa(i)+*objectSuffix, 1024)
+	}
+	*httpEndpoint = server.HTTPEndpoint()
+	*grpcEndpoint = server.GRPCEndpoint()
+	return server.Close, nil
+}
+
 func main() {
 	flag.Parse()
 	ctx := context.Background()
 
+	if *fakeGCS {
+		cleanup, err := startFakeGCS()
+		if err != nil {
+			fmt.Printf("while starting fake GCS: %v\n", err)
+			os.Exit(1)
+		}
+		defer cleanup()
+	}
+
 	fmt.Println("=======================================================================")
 	fmt.Printf("Starting StatObject Benchmark\n")
 	fmt.Printf("Bucket: %s, Workers: %d, Calls/Worker: %d\n", *bucketName, *numOfWorkers, *numOfCalls)
@@ -213,10 +328,12 @@ func main() {
 		grpcCloudClient.Close()
 	}
 
-	// 3. Run gRPC Direct-Path (DirectPath enabled)
+	// 3. Run gRPC Direct-Path (DirectPath enabled), which doesn't apply to
+	// an overridden endpoint.
 	fmt.Print("Running gRPC Direct-Path benchmark... ")
-	grpcDirectClient, err := CreateGrpcClient(ctx, true)
-	if err != nil {
+	if *grpcEndpoint != "" {
+		fmt.Println("SKIPPED")
+	} else if grpcDirectClient, err := CreateGrpcClient(ctx, true); err != nil {
 		results = append(results, &Result{Name: "gRPC Direct-Path", Error: err.Error()})
 		fmt.Println("FAILED")
 	} else {
@@ -231,18 +348,18 @@ func main() {
 	}
 
 	// Output Comparison Table
-	fmt.Println("\n========================= BENCHMARK RESULTS =========================\n")
+	fmt.Print("\n========================= BENCHMARK RESULTS =========================\n\n")
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.AlignRight|tabwriter.Debug)
-	fmt.Fprintln(w, "Protocol\tTotal Ops\tElapsed Time\tQPS\tAvg Latency\tP50 Latency\tP90 Latency\tP99 Latency\tStatus/Error")
-	fmt.Fprintln(w, "--------\t---------\t------------\t---\t-----------\t-----------\t-----------\t-----------\t------------")
+	fmt.Fprintln(w, "Protocol\tTotal Ops\tElapsed Time\tQPS\tAvg Latency\tP50 Latency\tP90 Latency\tP99 Latency\tDropped\tUnissued\tPeak Backlog\tStatus/Error")
+	fmt.Fprintln(w, "--------\t---------\t------------\t---\t-----------\t-----------\t-----------\t-----------\t-------\t--------\t------------\t------------")
 
 	for _, r := range results {
 		if r.Error != "" {
-			fmt.Fprintf(w, "%s\t%d\t%s\t%.2f\t%.2f ms\t%.2f ms\t%.2f ms\t%.2f ms\tError: %s\n",
-				r.Name, r.TotalOps, r.Duration, r.QPS, r.AvgLatency, r.P50Latency, r.P90Latency, r.P99Latency, r.Error)
+			fmt.Fprintf(w, "%s\t%d\t%s\t%.2f\t%.2f ms\t%.2f ms\t%.2f ms\t%.2f ms\t%d\t%d\t%d\tError: %s\n",
+				r.Name, r.TotalOps, r.Duration, r.QPS, r.AvgLatency, r.P50Latency, r.P90Latency, r.P99Latency, r.Dropped, r.Unissued, r.PeakBacklog, r.Error)
 		} else {
-			fmt.Fprintf(w, "%s\t%d\t%s\t%.2f\t%.2f ms\t%.2f ms\t%.2f ms\t%.2f ms\tSuccess\n",
-				r.Name, r.TotalOps, r.Duration.Round(time.Millisecond), r.QPS, r.AvgLatency, r.P50Latency, r.P90Latency, r.P99Latency)
+			fmt.Fprintf(w, "%s\t%d\t%s\t%.2f\t%.2f ms\t%.2f ms\t%.2f ms\t%.2f ms\t%d\t%d\t%d\tSuccess\n",
+				r.Name, r.TotalOps, r.Duration.Round(time.Millisecond), r.QPS, r.AvgLatency, r.P50Latency, r.P90Latency, r.P99Latency, r.Dropped, r.Unissued, r.PeakBacklog)
 		}
 	}
 	w.Flush()
@@ -1,9 +1,9 @@
 set -e
 
-grpc_file="grpc_${1}.txt"
-go run . -client-protocol grpc | tr 'ms' ' ' > $grpc_file 2>&1
-gsutil cp $grpc_file gs://princer-working-dirs/
-
-http_file="http_${1}.txt"
-go run . -client-protocol http | tr 'ms' ' ' > $http_file 2>&1
-gsutil cp $http_file gs://princer-working-dirs/
+# Each run writes a JSON and a CSV report with the same keys, so the two
+# protocols can be compared with e.g. `diff grpc_<n>.csv http_<n>.csv`.
+for protocol in grpc http; do
+  prefix="${protocol}_${1}"
+  go run . -client-protocol $protocol -report-json ${prefix}.json -report-csv ${prefix}.csv > ${prefix}.txt 2>&1
+  gsutil cp ${prefix}.json ${prefix}.csv ${prefix}.txt gs://princer-working-dirs/
+done
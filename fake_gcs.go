@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/raj-prince/custom-go-client-benchmark/fakegcs"
+)
+
+// startFakeGCS starts an in-process fake GCS holding one object per worker
+// and points both endpoint overrides at it. The returned func stops it.
+func startFakeGCS() (cleanup func(), err error) {
+	server, err := fakegcs.NewServer(fakegcs.Options{
+		Latency:        *fakeLatency,
+		BytesPerSecond: *fakeBandwidth,
+		ErrorRate:      *fakeErrorRate,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < *numOfWorker; i++ {
+		server.PutRandomObject(*bucketName, *objectNamePrefix+strconv.Itoa(i)+*objectNameSuffix, *fakeObjectSize)
+	}
+
+	*httpEndpoint = server.HTTPEndpoint()
+	*grpcEndpoint = server.GRPCEndpoint()
+	log.Printf("Fake GCS started, http: %s, grpc: %s", *httpEndpoint, *grpcEndpoint)
+
+	return server.Close, nil
+}
@@ -0,0 +1,355 @@
+package fakegcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	// The storage client registers the google.storage.v2 descriptors, which
+	// are used below to build the request and response messages.
+	_ "cloud.google.com/go/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcChunkSize is the maximum object data sent per message, as in GCS.
+const grpcChunkSize = 2 * 1024 * 1024
+
+// storageServiceDesc describes the subset of google.storage.v2.Storage served
+// by the fake. The generated stubs are internal to the storage module, so the
+// handlers work on messages resolved from the proto registry.
+var storageServiceDesc = grpc.ServiceDesc{
+	ServiceName: "google.storage.v2.Storage",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetObject",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				req := newMessage("GetObjectRequest")
+				if err := dec(req.Interface()); err != nil {
+					return nil, err
+				}
+				return srv.(*grpcService).getObject(ctx, req)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ReadObject",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(*grpcService).readObject(stream)
+			},
+		},
+		{
+			StreamName:    "BidiReadObject",
+			ServerStreams: true,
+			ClientStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(*grpcService).bidiReadObject(stream)
+			},
+		},
+	},
+}
+
+// message is a google.storage.v2 message manipulated through reflection.
+type message struct {
+	protoreflect.Message
+}
+
+// newMessage returns an empty google.storage.v2 message of the given name.
+func newMessage(name string) message {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName("google.storage.v2." + name))
+	if err != nil {
+		panic(fmt.Sprintf("fakegcs: google.storage.v2.%s is not registered: %v", name, err))
+	}
+	return message{mt.New()}
+}
+
+func (m message) field(name string) protoreflect.FieldDescriptor {
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if fd == nil {
+		panic(fmt.Sprintf("fakegcs: %s has no field %q", m.Descriptor().FullName(), name))
+	}
+	return fd
+}
+
+// set sets a scalar or message field and returns m for chaining.
+func (m message) set(name string, v any) message {
+	var value protoreflect.Value
+	switch v := v.(type) {
+	case message:
+		value = protoreflect.ValueOfMessage(v.Message)
+	case *timestamppb.Timestamp:
+		value = protoreflect.ValueOfMessage(v.ProtoReflect())
+	default:
+		value = protoreflect.ValueOf(v)
+	}
+	m.Set(m.field(name), value)
+	return m
+}
+
+// add appends v to a repeated message field.
+func (m message) add(name string, v message) {
+	m.Mutable(m.field(name)).List().Append(protoreflect.ValueOfMessage(v.Message))
+}
+
+func (m message) str(name string) string {
+	return m.Get(m.field(name)).String()
+}
+
+func (m message) int(name string) int64 {
+	return m.Get(m.field(name)).Int()
+}
+
+func (m message) bytes(name string) []byte {
+	return m.Get(m.field(name)).Bytes()
+}
+
+func (m message) msg(name string) message {
+	return message{m.Get(m.field(name)).Message()}
+}
+
+func (m message) list(name string) []message {
+	l := m.Get(m.field(name)).List()
+	msgs := make([]message, l.Len())
+	for i := range msgs {
+		msgs[i] = message{l.Get(i).Message()}
+	}
+	return msgs
+}
+
+// grpcService implements the fake google.storage.v2.Storage service.
+type grpcService struct {
+	s *Server
+}
+
+// bucketName strips the projects/_/buckets/ prefix of a bucket resource name.
+func bucketName(resource string) string {
+	if i := strings.LastIndex(resource, "/"); i >= 0 {
+		return resource[i+1:]
+	}
+	return resource
+}
+
+// readHandle encodes the generation served by a BidiReadObject stream, so a
+// stream reopened with the handle reads the same generation.
+func readHandle(obj *object) []byte {
+	return []byte("gen:" + strconv.FormatInt(obj.generation, 10))
+}
+
+func generationFromHandle(handle []byte) int64 {
+	gen, _ := strconv.ParseInt(strings.TrimPrefix(string(handle), "gen:"), 10, 64)
+	return gen
+}
+
+// objectProto returns the google.storage.v2.Object describing obj.
+func objectProto(obj *object) message {
+	checksums := newMessage("ObjectChecksums").
+		set("crc32c", obj.crc32c).
+		set("md5_hash", obj.md5)
+	return newMessage("Object").
+		set("name", obj.name).
+		set("bucket", "projects/_/buckets/"+obj.bucket).
+		set("generation", obj.generation).
+		set("metageneration", obj.metageneration).
+		set("storage_class", "STANDARD").
+		set("size", int64(len(obj.data))).
+		set("content_type", "application/octet-stream").
+		set("checksums", checksums).
+		set("create_time", timestamppb.New(obj.created)).
+		set("update_time", timestamppb.New(obj.created)).
+		set("finalize_time", timestamppb.New(obj.created))
+}
+
+// checksummedData returns the ChecksummedData message carrying content.
+func checksummedData(content []byte) message {
+	return newMessage("ChecksummedData").
+		set("content", content).
+		set("crc32c", crc32.Checksum(content, crc32cTable))
+}
+
+// start runs the common prologue of every RPC and returns the object.
+func (g *grpcService) start(ctx context.Context, bucket, name string, generation int64) (*object, error) {
+	inject, err := g.s.startRequest(ctx)
+	if err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	if inject {
+		return nil, status.Error(codes.Unavailable, "fakegcs: injected error")
+	}
+
+	obj := g.s.lookup(bucket, name, generation)
+	if obj == nil {
+		return nil, status.Errorf(codes.NotFound, "fakegcs: no such object: %s/%s", bucket, name)
+	}
+	return obj, nil
+}
+
+// resolveRange converts a possibly negative offset and a length where 0 means
+// "till the end" into absolute bounds within obj.
+func resolveRange(obj *object, offset, length int64) (start, end int64, err error) {
+	size := int64(len(obj.data))
+	if offset < 0 {
+		offset = max(size+offset, 0)
+	}
+	if offset > size {
+		return 0, 0, status.Errorf(codes.OutOfRange, "fakegcs: offset %d is past the object size %d", offset, size)
+	}
+	end = size
+	if length > 0 {
+		end = min(offset+length, size)
+	}
+	return offset, end, nil
+}
+
+func (g *grpcService) getObject(ctx context.Context, req message) (any, error) {
+	obj, err := g.start(ctx, bucketName(req.str("bucket")), req.str("object"), req.int("generation"))
+	if err != nil {
+		return nil, err
+	}
+	return objectProto(obj).Interface(), nil
+}
+
+func (g *grpcService) readObject(stream grpc.ServerStream) error {
+	req := newMessage("ReadObjectRequest")
+	if err := stream.RecvMsg(req.Interface()); err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	obj, err := g.start(ctx, bucketName(req.str("bucket")), req.str("object"), req.int("generation"))
+	if err != nil {
+		return err
+	}
+	start, end, err := resolveRange(obj, req.int("read_offset"), req.int("read_limit"))
+	if err != nil {
+		return err
+	}
+
+	t := newThrottle(g.s.options().BytesPerSecond)
+	first := true
+	for offset := start; first || offset < end; first = false {
+		chunk := obj.data[offset:min(offset+grpcChunkSize, end)]
+		if err := t.wait(ctx, len(chunk)); err != nil {
+			return status.FromContextError(err).Err()
+		}
+
+		resp := newMessage("ReadObjectResponse").set("checksummed_data", checksummedData(chunk))
+		if first {
+			resp.set("metadata", objectProto(obj)).
+				set("object_checksums", objectProto(obj).msg("checksums")).
+				set("content_range", newMessage("ContentRange").
+					set("start", start).
+					set("end", end).
+					set("complete_length", int64(len(obj.data))))
+		}
+		if err := stream.SendMsg(resp.Interface()); err != nil {
+			return err
+		}
+		atomic.AddUint64(&g.s.bytesServed, uint64(len(chunk)))
+		offset += int64(len(chunk))
+	}
+	return nil
+}
+
+func (g *grpcService) bidiReadObject(stream grpc.ServerStream) error {
+	req := newMessage("BidiReadObjectRequest")
+	if err := stream.RecvMsg(req.Interface()); err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	spec := req.msg("read_object_spec")
+	generation := spec.int("generation")
+	if handle := spec.msg("read_handle").bytes("handle"); generation == 0 && len(handle) > 0 {
+		generation = generationFromHandle(handle)
+	}
+	obj, err := g.start(ctx, bucketName(spec.str("bucket")), spec.str("object"), generation)
+	if err != nil {
+		return err
+	}
+
+	// The first response carries the object metadata and the read handle.
+	resp := newMessage("BidiReadObjectResponse").
+		set("metadata", objectProto(obj)).
+		set("read_handle", newMessage("BidiReadHandle").set("handle", readHandle(obj)))
+	if err := stream.SendMsg(resp.Interface()); err != nil {
+		return err
+	}
+
+	for first := true; ; first = false {
+		if !first {
+			req = newMessage("BidiReadObjectRequest")
+			if err := stream.RecvMsg(req.Interface()); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+			if len(req.list("read_ranges")) == 0 {
+				continue
+			}
+			inject, err := g.s.startRequest(ctx)
+			if err != nil {
+				return status.FromContextError(err).Err()
+			}
+			if inject {
+				return status.Error(codes.Unavailable, "fakegcs: injected error")
+			}
+		}
+
+		for _, r := range req.list("read_ranges") {
+			if err := g.sendRange(stream, obj, r); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendRange streams the data of one BidiReadObject range, flagging the last
+// message with range_end.
+func (g *grpcService) sendRange(stream grpc.ServerStream, obj *object, r message) error {
+	ctx := stream.Context()
+	start, end, err := resolveRange(obj, r.int("read_offset"), r.int("read_length"))
+	if err != nil {
+		return err
+	}
+
+	t := newThrottle(g.s.options().BytesPerSecond)
+	for offset := start; ; {
+		chunk := obj.data[offset:min(offset+grpcChunkSize, end)]
+		if err := t.wait(ctx, len(chunk)); err != nil {
+			return status.FromContextError(err).Err()
+		}
+
+		last := offset+int64(len(chunk)) >= end
+		data := newMessage("ObjectRangeData").
+			set("checksummed_data", checksummedData(chunk)).
+			set("read_range", newMessage("ReadRange").
+				set("read_offset", offset).
+				set("read_length", int64(len(chunk))).
+				set("read_id", r.int("read_id"))).
+			set("range_end", last)
+		resp := newMessage("BidiReadObjectResponse")
+		resp.add("object_data_ranges", data)
+		if err := stream.SendMsg(resp.Interface()); err != nil {
+			return err
+		}
+		atomic.AddUint64(&g.s.bytesServed, uint64(len(chunk)))
+
+		offset += int64(len(chunk))
+		if last {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package fakegcs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// httpChunkSize is the size of the writes paced by the bandwidth cap.
+const httpChunkSize = 32 * 1024
+
+// httpHandler serves the JSON API (metadata and alt=media) and the XML API
+// media downloads used by the HTTP storage client.
+type httpHandler struct {
+	s *Server
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "fakegcs: only reads are supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bucket, name, isJSON, ok := parseObjectPath(r.URL.EscapedPath())
+	if !ok {
+		http.Error(w, "fakegcs: unsupported path "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+
+	inject, err := h.s.startRequest(r.Context())
+	if err != nil {
+		return
+	}
+	if inject {
+		http.Error(w, "fakegcs: injected error", http.StatusServiceUnavailable)
+		return
+	}
+
+	generation, _ := strconv.ParseInt(r.URL.Query().Get("generation"), 10, 64)
+	obj := h.s.lookup(bucket, name, generation)
+	if obj == nil {
+		http.Error(w, "fakegcs: no such object: "+bucket+"/"+name, http.StatusNotFound)
+		return
+	}
+
+	if isJSON && r.URL.Query().Get("alt") != "media" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(objectResource(obj))
+		return
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("X-Goog-Generation", strconv.FormatInt(obj.generation, 10))
+	header.Set("X-Goog-Metageneration", strconv.FormatInt(obj.metageneration, 10))
+	header.Set("X-Goog-Stored-Content-Length", strconv.Itoa(len(obj.data)))
+	header.Add("X-Goog-Hash", "crc32c="+encodeCRC32C(obj.crc32c))
+	header.Add("X-Goog-Hash", "md5="+base64.StdEncoding.EncodeToString(obj.md5))
+
+	// ServeContent takes care of the Range header and of the 206 responses.
+	tw := &throttledResponseWriter{
+		ResponseWriter: w,
+		r:              r,
+		s:              h.s,
+		t:              newThrottle(h.s.options().BytesPerSecond),
+	}
+	http.ServeContent(tw, r, name, obj.created, bytes.NewReader(obj.data))
+}
+
+// parseObjectPath extracts the bucket and object from the JSON API paths
+// ([/download]/storage/v1/b/<bucket>/o/<object>) and the XML API path
+// (/<bucket>/<object>).
+func parseObjectPath(escapedPath string) (bucket, name string, isJSON, ok bool) {
+	p := strings.TrimPrefix(escapedPath, "/download")
+	if rest, found := strings.CutPrefix(p, "/storage/v1/b/"); found {
+		b, o, found := strings.Cut(rest, "/o/")
+		if !found {
+			return "", "", false, false
+		}
+		bucket, err1 := url.PathUnescape(b)
+		name, err2 := url.PathUnescape(o)
+		return bucket, name, true, err1 == nil && err2 == nil && bucket != "" && name != ""
+	}
+
+	b, o, found := strings.Cut(strings.TrimPrefix(escapedPath, "/"), "/")
+	if !found {
+		return "", "", false, false
+	}
+	bucket, err1 := url.PathUnescape(b)
+	name, err2 := url.PathUnescape(o)
+	return bucket, name, false, err1 == nil && err2 == nil && bucket != "" && name != ""
+}
+
+// objectResource returns the JSON API representation of obj.
+func objectResource(obj *object) map[string]any {
+	return map[string]any{
+		"kind":           "storage#object",
+		"id":             obj.bucket + "/" + obj.name + "/" + strconv.FormatInt(obj.generation, 10),
+		"bucket":         obj.bucket,
+		"name":           obj.name,
+		"generation":     strconv.FormatInt(obj.generation, 10),
+		"metageneration": strconv.FormatInt(obj.metageneration, 10),
+		"size":           strconv.Itoa(len(obj.data)),
+		"contentType":    "application/octet-stream",
+		"crc32c":         encodeCRC32C(obj.crc32c),
+		"md5Hash":        base64.StdEncoding.EncodeToString(obj.md5),
+		"timeCreated":    obj.created.Format(time.RFC3339Nano),
+		"updated":        obj.created.Format(time.RFC3339Nano),
+		"storageClass":   "STANDARD",
+	}
+}
+
+// encodeCRC32C encodes a CRC32C the way GCS does: base64 of the big-endian
+// bytes.
+func encodeCRC32C(crc uint32) string {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, crc)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// throttledResponseWriter paces the body of a response to the bandwidth cap
+// and accounts for the bytes served.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	r *http.Request
+	s *Server
+	t *throttle
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), httpChunkSize)]
+		if err := w.t.wait(w.r.Context(), len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		atomic.AddUint64(&w.s.bytesServed, uint64(n))
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
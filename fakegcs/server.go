@@ -0,0 +1,269 @@
+// Package fakegcs provides an in-process fake of the GCS read paths, so the
+// benchmarks in this repository can run without credentials or network.
+//
+// A Server serves the JSON and XML media endpoints over HTTP and the
+// GetObject, ReadObject and BidiReadObject RPCs over plaintext gRPC. Objects
+// are kept in memory, and every request can be slowed down, throttled or
+// failed through Options.
+package fakegcs
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Options controls the behaviour of every request served by the fake.
+type Options struct {
+	// Latency is added before the first byte of every request. For
+	// BidiReadObject streams it is added to every message carrying ranges.
+	Latency time.Duration
+
+	// BytesPerSecond caps the bandwidth of each response, 0 means unlimited.
+	BytesPerSecond int64
+
+	// ErrorRate is the probability in [0, 1] that a request fails with a
+	// retryable error (HTTP 503 or gRPC Unavailable) before sending data.
+	ErrorRate float64
+}
+
+// Stats reports what the fake has served so far.
+type Stats struct {
+	Requests       uint64
+	InjectedErrors uint64
+	BytesServed    uint64
+}
+
+// object is a single generation of a stored object.
+type object struct {
+	bucket         string
+	name           string
+	data           []byte
+	generation     int64
+	metageneration int64
+	crc32c         uint32
+	md5            []byte
+	created        time.Time
+}
+
+// Server is an in-process fake GCS backend.
+type Server struct {
+	mu             sync.RWMutex
+	objects        map[string]*object
+	opts           Options
+	nextGeneration int64
+	rnd            *rand.Rand
+	forcedErrors   int
+
+	requests       uint64
+	injectedErrors uint64
+	bytesServed    uint64
+
+	httpServer *httptest.Server
+	grpcServer *grpc.Server
+	grpcAddr   string
+}
+
+// NewServer starts the HTTP and gRPC endpoints of a new fake.
+func NewServer(opts Options) (*Server, error) {
+	if opts.ErrorRate < 0 || opts.ErrorRate > 1 {
+		return nil, fmt.Errorf("invalid error rate (%v): must be within [0, 1]", opts.ErrorRate)
+	}
+	if opts.BytesPerSecond < 0 {
+		return nil, fmt.Errorf("invalid bandwidth (%d): must be >= 0", opts.BytesPerSecond)
+	}
+
+	s := &Server{
+		objects:        make(map[string]*object),
+		opts:           opts,
+		nextGeneration: time.Now().UnixMicro(),
+		rnd:            rand.New(rand.NewSource(1)),
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("while listening for gRPC: %w", err)
+	}
+	s.grpcServer = grpc.NewServer()
+	s.grpcServer.RegisterService(&storageServiceDesc, &grpcService{s: s})
+	s.grpcAddr = lis.Addr().String()
+	go s.grpcServer.Serve(lis)
+
+	s.httpServer = httptest.NewServer(&httpHandler{s: s})
+	return s, nil
+}
+
+// HTTPEndpoint returns the endpoint to pass to option.WithEndpoint for an
+// HTTP storage client.
+func (s *Server) HTTPEndpoint() string {
+	return s.httpServer.URL + "/storage/v1/"
+}
+
+// GRPCEndpoint returns the host:port to pass to option.WithEndpoint for a
+// gRPC storage client. The endpoint doesn't use TLS.
+func (s *Server) GRPCEndpoint() string {
+	return s.grpcAddr
+}
+
+// Close stops both endpoints.
+func (s *Server) Close() {
+	s.httpServer.Close()
+	s.grpcServer.Stop()
+}
+
+// SetOptions replaces the options applied to subsequent requests.
+func (s *Server) SetOptions(opts Options) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts = opts
+}
+
+// FailNext makes the next n requests fail with a retryable error, on top of
+// Options.ErrorRate.
+func (s *Server) FailNext(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forcedErrors += n
+}
+
+// PutObject stores data as a new generation of bucket/name and returns the
+// generation. Older generations are no longer served.
+func (s *Server) PutObject(bucket, name string, data []byte) int64 {
+	sum := md5.Sum(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextGeneration++
+	s.objects[bucket+"/"+name] = &object{
+		bucket:         bucket,
+		name:           name,
+		data:           data,
+		generation:     s.nextGeneration,
+		metageneration: 1,
+		crc32c:         crc32.Checksum(data, crc32cTable),
+		md5:            sum[:],
+		created:        time.Now(),
+	}
+	return s.nextGeneration
+}
+
+// PutRandomObject stores size pseudo-random bytes as bucket/name and returns
+// the content.
+func (s *Server) PutRandomObject(bucket, name string, size int64) []byte {
+	data := make([]byte, size)
+	s.mu.Lock()
+	s.rnd.Read(data)
+	s.mu.Unlock()
+
+	s.PutObject(bucket, name, data)
+	return data
+}
+
+// DeleteObject removes bucket/name.
+func (s *Server) DeleteObject(bucket, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, bucket+"/"+name)
+}
+
+// GetStats returns the request counters of the fake.
+func (s *Server) GetStats() Stats {
+	return Stats{
+		Requests:       atomic.LoadUint64(&s.requests),
+		InjectedErrors: atomic.LoadUint64(&s.injectedErrors),
+		BytesServed:    atomic.LoadUint64(&s.bytesServed),
+	}
+}
+
+// lookup returns the object, or nil if it doesn't exist or the requested
+// generation (when > 0) is not the live one.
+func (s *Server) lookup(bucket, name string, generation int64) *object {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj := s.objects[bucket+"/"+name]
+	if obj == nil || (generation > 0 && generation != obj.generation) {
+		return nil
+	}
+	return obj
+}
+
+// options returns the options of the current request.
+func (s *Server) options() Options {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.opts
+}
+
+// startRequest accounts for a new request, waits for the configured latency
+// and reports whether an error must be injected.
+func (s *Server) startRequest(ctx context.Context) (inject bool, err error) {
+	atomic.AddUint64(&s.requests, 1)
+
+	s.mu.Lock()
+	opts := s.opts
+	if s.forcedErrors > 0 {
+		s.forcedErrors--
+		inject = true
+	} else if opts.ErrorRate > 0 {
+		inject = s.rnd.Float64() < opts.ErrorRate
+	}
+	s.mu.Unlock()
+
+	if err := sleep(ctx, opts.Latency); err != nil {
+		return false, err
+	}
+	if inject {
+		atomic.AddUint64(&s.injectedErrors, 1)
+	}
+	return inject, nil
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttle paces the bytes sent by one response to bytesPerSecond.
+type throttle struct {
+	bytesPerSecond int64
+	start          time.Time
+	sent           int64
+}
+
+func newThrottle(bytesPerSecond int64) *throttle {
+	return &throttle{bytesPerSecond: bytesPerSecond, start: time.Now()}
+}
+
+// wait accounts for n more bytes and blocks until sending them doesn't exceed
+// the bandwidth cap.
+func (t *throttle) wait(ctx context.Context, n int) error {
+	t.sent += int64(n)
+	if t.bytesPerSecond <= 0 {
+		return nil
+	}
+	due := t.start.Add(time.Duration(float64(t.sent) / float64(t.bytesPerSecond) * float64(time.Second)))
+	return sleep(ctx, time.Until(due))
+}
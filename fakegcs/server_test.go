@@ -0,0 +1,187 @@
+package fakegcs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"cloud.google.com/go/storage/experimental"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	testBucket = "test-bucket"
+	testObject = "dir/test-object"
+)
+
+func newTestServer(t *testing.T, opts Options) (*Server, []byte) {
+	t.Helper()
+	s, err := NewServer(opts)
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	data := s.PutRandomObject(testBucket, testObject, 5*1024*1024+123)
+	return s, data
+}
+
+func newHTTPClient(t *testing.T, s *Server) *storage.Client {
+	t.Helper()
+	client, err := storage.NewClient(context.Background(),
+		option.WithEndpoint(s.HTTPEndpoint()),
+		option.WithoutAuthentication())
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func newGrpcClient(t *testing.T, s *Server, opts ...option.ClientOption) *storage.Client {
+	t.Helper()
+	opts = append(opts,
+		option.WithEndpoint(s.GRPCEndpoint()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		storage.WithDisabledClientMetrics())
+	client, err := storage.NewGRPCClient(context.Background(), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func readRange(t *testing.T, client *storage.Client, offset, length int64) []byte {
+	t.Helper()
+	r, err := client.Bucket(testBucket).Object(testObject).NewRangeReader(context.Background(), offset, length)
+	require.NoError(t, err)
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return got
+}
+
+func TestNewServer_InvalidOptions(t *testing.T) {
+	_, err := NewServer(Options{ErrorRate: 2})
+	assert.Error(t, err)
+
+	_, err = NewServer(Options{BytesPerSecond: -1})
+	assert.Error(t, err)
+}
+
+func TestServer_Reads(t *testing.T) {
+	s, data := newTestServer(t, Options{})
+
+	clients := map[string]*storage.Client{
+		"http":      newHTTPClient(t, s),
+		"grpc":      newGrpcClient(t, s),
+		"grpc-bidi": newGrpcClient(t, s, experimental.WithGRPCBidiReads()),
+	}
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			attrs, err := client.Bucket(testBucket).Object(testObject).Attrs(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), attrs.Size)
+			assert.NotZero(t, attrs.Generation)
+
+			assert.Equal(t, data, readRange(t, client, 0, -1))
+			assert.Equal(t, data[1024:1024+4096], readRange(t, client, 1024, 4096))
+			assert.Equal(t, data[len(data)-100:], readRange(t, client, int64(len(data)-100), -1))
+		})
+	}
+}
+
+func TestServer_NotFound(t *testing.T) {
+	s, _ := newTestServer(t, Options{})
+
+	for name, client := range map[string]*storage.Client{"http": newHTTPClient(t, s), "grpc": newGrpcClient(t, s)} {
+		t.Run(name, func(t *testing.T) {
+			_, err := client.Bucket(testBucket).Object("missing").Attrs(context.Background())
+			assert.ErrorIs(t, err, storage.ErrObjectNotExist)
+		})
+	}
+}
+
+func TestServer_MultiRangeDownloader(t *testing.T) {
+	s, data := newTestServer(t, Options{})
+	client := newGrpcClient(t, s, experimental.WithGRPCBidiReads())
+
+	mrd, err := client.Bucket(testBucket).Object(testObject).NewMultiRangeDownloader(context.Background())
+	require.NoError(t, err)
+
+	ranges := [][2]int64{{0, 100}, {4 * 1024 * 1024, 3 * 1024 * 1024}, {12345, 1}}
+	bufs := make([]bytes.Buffer, len(ranges))
+	errs := make([]error, len(ranges))
+	for i, r := range ranges {
+		mrd.Add(&bufs[i], r[0], r[1], func(_, _ int64, err error) { errs[i] = err })
+	}
+	mrd.Wait()
+	require.NoError(t, mrd.Close())
+
+	for i, r := range ranges {
+		require.NoError(t, errs[i])
+		end := min(r[0]+r[1], int64(len(data)))
+		assert.Equal(t, data[r[0]:end], bufs[i].Bytes(), "range %d", i)
+	}
+	assert.Equal(t, int64(len(data)), mrd.Attrs.Size)
+}
+
+func TestServer_ErrorInjection(t *testing.T) {
+	s, _ := newTestServer(t, Options{})
+	client := newGrpcClient(t, s)
+	client.SetRetry(storage.WithPolicy(storage.RetryNever))
+	object := client.Bucket(testBucket).Object(testObject)
+
+	s.FailNext(1)
+	_, err := object.Attrs(context.Background())
+	assert.Error(t, err)
+
+	_, err = object.Attrs(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), s.GetStats().InjectedErrors)
+
+	s.SetOptions(Options{ErrorRate: 1})
+	_, err = object.Attrs(context.Background())
+	assert.Error(t, err)
+}
+
+func TestServer_Latency(t *testing.T) {
+	s, _ := newTestServer(t, Options{Latency: 100 * time.Millisecond})
+	client := newHTTPClient(t, s)
+
+	start := time.Now()
+	readRange(t, client, 0, 10)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestServer_Bandwidth(t *testing.T) {
+	s, _ := newTestServer(t, Options{BytesPerSecond: 1024 * 1024})
+
+	for name, client := range map[string]*storage.Client{"http": newHTTPClient(t, s), "grpc": newGrpcClient(t, s)} {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			readRange(t, client, 0, 256*1024)
+			assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+		})
+	}
+}
+
+func TestServer_PutObjectBumpsGeneration(t *testing.T) {
+	s, _ := newTestServer(t, Options{})
+	client := newGrpcClient(t, s)
+	object := client.Bucket(testBucket).Object(testObject)
+
+	before, err := object.Attrs(context.Background())
+	require.NoError(t, err)
+	s.PutObject(testBucket, testObject, []byte("new content"))
+
+	after, err := object.Attrs(context.Background())
+	require.NoError(t, err)
+	assert.Greater(t, after.Generation, before.Generation)
+	assert.Equal(t, int64(len("new content")), after.Size)
+
+	_, err = object.Generation(before.Generation).Attrs(context.Background())
+	assert.ErrorIs(t, err, storage.ErrObjectNotExist)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"github.com/raj-prince/custom-go-client-benchmark/util"
+)
+
+// hedger hedges NewRangeReader calls when --hedge is set.
+var hedger *util.Hedger
+
+// newHedger returns the Hedger configured by the --hedge-* flags.
+func newHedger() (*util.Hedger, error) {
+	cfg := util.DelayConfig{
+		TargetPercentile: *hedgePercentile,
+		IncreaseRate:     *hedgeIncreaseRate,
+		InitialDelay:     *hedgeInitialDelay,
+		MinDelay:         *hedgeMinDelay,
+		MaxDelay:         *hedgeMaxDelay,
+	}
+	switch *hedgeDelayKey {
+	case "none":
+		d, err := util.NewConcurrentDelay(cfg.TargetPercentile, cfg.IncreaseRate, cfg.InitialDelay, cfg.MinDelay, cfg.MaxDelay)
+		if err != nil {
+			return nil, err
+		}
+		return util.NewHedger(d), nil
+	case "size", "protocol-size":
+		delays, err := util.NewDelayRegistry(cfg, *hedgeIdleTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return util.NewRegistryHedger(delays), nil
+	default:
+		return nil, fmt.Errorf("invalid --hedge-delay-key %q: must be none, size or protocol-size", *hedgeDelayKey)
+	}
+}
+
+// hedgeKey returns the key of the hedge delay of r, see --hedge-delay-key.
+func hedgeKey(r ReadRange) string {
+	size := "object"
+	if r.Length >= 0 {
+		size = util.SizeClass(r.Length)
+	}
+	switch *hedgeDelayKey {
+	case "size":
+		return size
+	case "protocol-size":
+		return *clientProtocol + "/" + size
+	default:
+		return ""
+	}
+}
+
+// newRangeReader opens a reader on r, hedging the call with --hedge. The
+// returned func must be called once the reader is closed.
+func newRangeReader(ctx context.Context, object *storage.ObjectHandle, r ReadRange) (*storage.Reader, func(), error) {
+	if hedger == nil {
+		rc, err := object.NewRangeReader(ctx, r.Offset, r.Length)
+		return rc, func() {}, err
+	}
+
+	res, err := util.HedgeKey(ctx, hedger, hedgeKey(r), func(ctx context.Context) (*storage.Reader, error) {
+		return object.NewRangeReader(ctx, r.Offset, r.Length)
+	}, func(rc *storage.Reader) {
+		rc.Close()
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.Value, res.Cancel, nil
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	// Register the pprof endpoints under the web server root at /debug/pprof
 	_ "net/http/pprof"
@@ -16,16 +17,18 @@ import (
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/trace"
 
 	"cloud.google.com/go/profiler"
 	"cloud.google.com/go/storage"
 	"cloud.google.com/go/storage/experimental"
 	"github.com/googleapis/gax-go/v2"
+	"github.com/raj-prince/custom-go-client-benchmark/util"
 	"go.opencensus.io/stats"
 	"golang.org/x/oauth2"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 var (
@@ -73,6 +76,55 @@ var (
 	// Enable read stall retry.
 	enableReadStallRetry = flag.Bool("enable-read-stall-retry", false, "Enable read stall retry")
 
+	// Read pattern of each worker, see workload.go.
+	workloadType   = flag.String("workload", sequentialWorkload, "Read pattern: sequential, random, strided or mixed")
+	rangeSize      = flag.Int64("range-size", 0, "Bytes read per call, 0 reads the whole object (sequential only)")
+	stride         = flag.Int64("stride", 0, "Distance between two strided reads, 0 means 2 * range-size")
+	mixedWeights   = flag.String("mixed-weights", "1,1,1", "Relative weight of the sequential,random,strided patterns in the mixed workload")
+	workloadSeed   = flag.Int64("workload-seed", 1, "Seed for the random and mixed workloads, worker i uses seed + i")
+	workloadConfig *WorkloadConfig
+
+	// Endpoint overrides, e.g. to target an emulator. Requests sent to an
+	// overridden endpoint are unauthenticated and, for gRPC, in plaintext.
+	httpEndpoint = flag.String("http-endpoint", "", "Override the HTTP endpoint, e.g. http://localhost:9000/storage/v1/")
+	grpcEndpoint = flag.String("grpc-endpoint", "", "Override the gRPC endpoint, e.g. localhost:9001")
+
+	// In-process fake GCS, see fake_gcs.go.
+	fakeGCS        = flag.Bool("fake-gcs", false, "Run against an in-process fake GCS instead of the real service")
+	fakeObjectSize = flag.Int64("fake-object-size", int64(MB), "Size of the objects created in the fake GCS")
+	fakeLatency    = flag.Duration("fake-latency", 0, "Latency added by the fake GCS to every request")
+	fakeBandwidth  = flag.Int64("fake-bandwidth", 0, "Per-request bandwidth cap of the fake GCS in bytes/s, 0 means unlimited")
+	fakeErrorRate  = flag.Float64("fake-error-rate", 0, "Probability that the fake GCS fails a request with a retryable error")
+
+	// Run bounds, see run_window.go. The run also stops after
+	// --read-call-per-worker reads per worker.
+	runDuration = flag.Duration("duration", 0, "Stop the run after this long past the warm-up, 0 means no limit")
+	warmup      = flag.Duration("warmup", 0, "Reads started during this initial period are not measured")
+	maxBytes    = flag.Int64("max-bytes", 0, "Stop the run after reading this many measured bytes across all workers, 0 means no limit")
+	window      *runWindow
+
+	// Open-loop mode, see open_loop.go. Reads are issued at a fixed arrival
+	// rate and each worker serves one read at a time.
+	arrivalRate         = flag.Float64("arrival-rate", 0, "Issue reads at this rate per second across all workers (open loop), 0 keeps each worker reading back to back")
+	targetMBps          = flag.Float64("target-mbps", 0, "Issue reads at the rate reaching this many MiB/s across all workers (open loop)")
+	arrivalDistribution = flag.String("arrival-distribution", util.PoissonArrivals, "Inter-arrival times of the open loop: poisson or constant")
+	maxBacklog          = flag.Int("max-backlog", 1000, "Reads of the open loop waiting for a free worker, later ones are dropped")
+
+	// Hedged reads, see hedge.go. A NewRangeReader call still waiting for the
+	// response after the hedge delay is sent again, the first response wins.
+	hedge             = flag.Bool("hedge", false, "Hedge NewRangeReader calls after a dynamic delay")
+	hedgePercentile   = flag.Float64("hedge-percentile", 0.99, "Latency percentile the hedge delay converges to")
+	hedgeIncreaseRate = flag.Float64("hedge-increase-rate", 15, "Number of slow calls it takes for the hedge delay to double")
+	hedgeInitialDelay = flag.Duration("hedge-initial-delay", 100*time.Millisecond, "Initial hedge delay")
+	hedgeMinDelay     = flag.Duration("hedge-min-delay", time.Millisecond, "Minimum hedge delay")
+	hedgeMaxDelay     = flag.Duration("hedge-max-delay", time.Minute, "Maximum hedge delay")
+	hedgeDelayKey     = flag.String("hedge-delay-key", "none", "Keep a separate hedge delay per key: none, size (range size class) or protocol-size")
+	hedgeIdleTimeout  = flag.Duration("hedge-idle-timeout", 10*time.Minute, "Forget the hedge delay of a key unused for this long (0: never)")
+
+	// End-of-run report, see report.go.
+	reportJSON = flag.String("report-json", "", "Write the end-of-run report as JSON to this file")
+	reportCSV  = flag.String("report-csv", "", "Write the end-of-run report as CSV to this file")
+
 	eG errgroup.Group
 )
 
@@ -98,18 +150,25 @@ func CreateHTTPClient(ctx context.Context, isHTTP2 bool) (client *storage.Client
 		}
 	}
 
-	tokenSource, err := GetTokenSource(ctx, "")
-	if err != nil {
-		return nil, fmt.Errorf("while generating tokenSource, %v", err)
+	var opts []option.ClientOption
+	var base http.RoundTripper = transport
+	if *httpEndpoint != "" {
+		opts = append(opts, option.WithEndpoint(*httpEndpoint))
+	} else {
+		tokenSource, err := GetTokenSource(ctx, "")
+		if err != nil {
+			return nil, fmt.Errorf("while generating tokenSource, %v", err)
+		}
+		base = &oauth2.Transport{
+			Base:   transport,
+			Source: tokenSource,
+		}
 	}
 
 	// Custom http client for Go Client.
 	httpClient := &http.Client{
-		Transport: &oauth2.Transport{
-			Base:   transport,
-			Source: tokenSource,
-		},
-		Timeout: 0,
+		Transport: base,
+		Timeout:   0,
 	}
 
 	// Setting UserAgent through RoundTripper middleware
@@ -117,19 +176,28 @@ func CreateHTTPClient(ctx context.Context, isHTTP2 bool) (client *storage.Client
 		wrapped:   httpClient.Transport,
 		UserAgent: "prince",
 	}
+	opts = append(opts, option.WithHTTPClient(httpClient))
 
 	if *enableReadStallRetry {
-		return storage.NewClient(ctx, option.WithHTTPClient(httpClient),
-			experimental.WithReadStallTimeout(&experimental.ReadStallTimeoutConfig{
-				Min: time.Second,
-				TargetPercentile: 0.99,
-			}))
+		opts = append(opts, experimental.WithReadStallTimeout(&experimental.ReadStallTimeoutConfig{
+			Min: time.Second,
+			TargetPercentile: 0.99,
+		}))
 	}
-	return storage.NewClient(ctx, option.WithHTTPClient(httpClient))
+	return storage.NewClient(ctx, opts...)
 }
 
 // CreateGrpcClient creates grpc client.
 func CreateGrpcClient(ctx context.Context) (client *storage.Client, err error) {
+	if *grpcEndpoint != "" {
+		return storage.NewGRPCClient(ctx,
+			option.WithEndpoint(*grpcEndpoint),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+			option.WithGRPCConnectionPool(grpcConnPoolSize),
+			storage.WithDisabledClientMetrics())
+	}
+
 	tokenSource, err := GetTokenSource(ctx, "")
 	if err != nil {
 		return nil, err
@@ -137,49 +205,126 @@ func CreateGrpcClient(ctx context.Context) (client *storage.Client, err error) {
 	return storage.NewGRPCClient(ctx, option.WithGRPCConnectionPool(grpcConnPoolSize), option.WithTokenSource(tokenSource), storage.WithDisabledClientMetrics())
 }
 
-// ReadObject creates reader object corresponding to workerID with the help of bucketHandle.
-func ReadObject(ctx context.Context, workerID int, bucketHandle *storage.BucketHandle) (err error) {
-
-	objectName := *objectNamePrefix + strconv.Itoa(workerID) + *objectNameSuffix
-
-	for i := 0; i < *numOfReadCallPerWorker; i++ {
-		var span trace.Span
-		traceCtx, span := otel.GetTracerProvider().Tracer(tracerName).Start(ctx, "ReadObject")
-		span.SetAttributes(
-			attribute.KeyValue{Key: "bucket", Value: attribute.StringValue(*bucketName)},
-		)
-		start := time.Now()
-		object := bucketHandle.Object(objectName)
-		rc, err := object.NewReader(traceCtx)
+// newWorkerWorkload builds the workload of a worker, fetching the object size
+// only when the read pattern needs it.
+func newWorkerWorkload(ctx context.Context, workerID int, object *storage.ObjectHandle) (Workload, error) {
+	var objectSize int64
+	if workloadConfig.needsObjectSize() {
+		attrs, err := object.Attrs(ctx)
 		if err != nil {
-			return fmt.Errorf("while creating reader object: %v", err)
+			return nil, fmt.Errorf("while fetching object attrs: %v", err)
 		}
-		firstByteTime := time.Since(start)
-		stats.Record(ctx, firstByteReadLatency.M(float64(firstByteTime.Milliseconds())))
+		objectSize = attrs.Size
+	}
 
-		// Calls Reader.WriteTo implicitly.
-		_, err = io.Copy(io.Discard, rc)
+	rnd := rand.New(rand.NewSource(*workloadSeed + int64(workerID)))
+	return NewWorkload(workloadConfig, objectSize, rnd)
+}
+
+// readRange reads r from object and discards the content, recording the first
+// byte and the complete read latency unless the read started during the
+// warm-up. Latencies are measured from start, the time the read was due.
+func readRange(ctx context.Context, object *storage.ObjectHandle, workload Workload, r ReadRange, ws *workerStats, start time.Time) (err error) {
+	traceCtx, span := otel.GetTracerProvider().Tracer(tracerName).Start(ctx, "ReadObject")
+	defer span.End()
+	span.SetAttributes(
+		attribute.KeyValue{Key: "bucket", Value: attribute.StringValue(*bucketName)},
+		attribute.KeyValue{Key: "workload", Value: attribute.StringValue(workload.Name())},
+		attribute.KeyValue{Key: "offset", Value: attribute.Int64Value(r.Offset)},
+		attribute.KeyValue{Key: "length", Value: attribute.Int64Value(r.Length)},
+	)
+
+	rc, release, err := newRangeReader(traceCtx, object, r)
+	if err != nil {
+		return fmt.Errorf("while creating reader object: %v", err)
+	}
+	defer release()
+	firstByteTime := time.Since(start)
+
+	// Calls Reader.WriteTo implicitly.
+	n, err := io.Copy(io.Discard, rc)
+	if err != nil {
+		rc.Close()
+		return fmt.Errorf("while reading and discarding content: %v", err)
+	}
+	duration := time.Since(start)
+
+	if err = rc.Close(); err != nil {
+		return fmt.Errorf("while closing the reader object: %v", err)
+	}
+
+	if !window.measured(start) {
+		return nil
+	}
+	stats.Record(ctx, firstByteReadLatency.M(float64(firstByteTime.Milliseconds())))
+	stats.Record(ctx, readLatency.M(float64(duration.Milliseconds())))
+	ws.firstByte.Record(firstByteTime)
+	ws.total.Record(duration)
+	ws.operations++
+	ws.bytes += uint64(n)
+	window.addBytes(n)
+	return nil
+}
+
+// ReadObject creates reader object corresponding to workerID with the help of
+// bucketHandle, and records the results in ws. It returns nil once ctx is
+// cancelled, which ends the run.
+func ReadObject(ctx context.Context, workerID int, bucketHandle *storage.BucketHandle, ws *workerStats) (err error) {
+	ws.start = time.Now()
+	defer func() {
+		ws.end = time.Now()
 		if err != nil {
-			return fmt.Errorf("while reading and discarding content: %v", err)
+			ws.errors++
 		}
+	}()
 
-		duration := time.Since(start)
-		stats.Record(ctx, readLatency.M(float64(duration.Milliseconds())))
+	object := bucketHandle.Object(ws.object)
 
-		err = rc.Close()
-		span.End()
-		if err != nil {
-			return fmt.Errorf("while closing the reader object: %v", err)
+	workload, err := newWorkerWorkload(ctx, workerID, object)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
 		}
+		return fmt.Errorf("while creating workload: %w", err)
 	}
 
-	return
+	for i := 0; i < *numOfReadCallPerWorker && ctx.Err() == nil; i++ {
+		if err = readRange(ctx, object, workload, workload.Next(), ws, time.Now()); err != nil {
+			// A read interrupted by the end of the run isn't an error.
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
 }
 
 func main() {
 	flag.Parse()
 	ctx := context.Background()
 
+	weights, err := parseMixedWeights(*mixedWeights)
+	if err != nil {
+		log.Fatalf("while parsing --mixed-weights: %v", err)
+	}
+	workloadConfig = &WorkloadConfig{
+		Type:         *workloadType,
+		RangeSize:    *rangeSize,
+		Stride:       *stride,
+		MixedWeights: weights,
+	}
+	if err := workloadConfig.validate(); err != nil {
+		log.Fatalf("invalid workload: %v", err)
+	}
+
+	if *hedge {
+		if hedger, err = newHedger(); err != nil {
+			log.Fatalf("invalid hedge delay: %v", err)
+		}
+	}
+
 	if *enableTracing {
 		cleanup := enableTraceExport(ctx, *traceSampleRate)
 		defer cleanup()
@@ -212,8 +357,15 @@ func main() {
 		}
 	}
 
+	if *fakeGCS {
+		cleanup, err := startFakeGCS()
+		if err != nil {
+			log.Fatalf("while starting fake GCS: %v", err)
+		}
+		defer cleanup()
+	}
+
 	var client *storage.Client
-	var err error
 	if *clientProtocol == "http" {
 		client, err = CreateHTTPClient(ctx, false)
 	} else {
@@ -239,27 +391,64 @@ func main() {
 	registerLatencyView()
 	registerFirstByteLatencyView()
 
-	err = enableSDExporter()
-	if err != nil {
-		fmt.Printf("while enabling stackdriver exporter: %v", err)
-		os.Exit(1)
+	// The fake GCS is meant to run without credentials, so metrics are only
+	// aggregated locally.
+	if !*fakeGCS {
+		err = enableSDExporter()
+		if err != nil {
+			fmt.Printf("while enabling stackdriver exporter: %v", err)
+			os.Exit(1)
+		}
+		defer closeSDExporter()
 	}
-	defer closeSDExporter()
 
 	// Run the actual workload
+	workers := make([]*workerStats, *numOfWorker)
+	runCtx, w := newRunWindow(ctx, *warmup, *runDuration, *maxBytes)
+	window = w
 	for i := 0; i < *numOfWorker; i++ {
-		idx := i
-		eG.Go(func() error {
-			err = ReadObject(ctx, idx, bucketHandle)
-			if err != nil {
-				err = fmt.Errorf("while reading object %v: %w", *objectNamePrefix+strconv.Itoa(idx)+*objectNameSuffix, err)
+		workers[i] = newWorkerStats(*objectNamePrefix + strconv.Itoa(i) + *objectNameSuffix)
+	}
+
+	var loopStats util.OpenLoopStats
+	if openLoop() {
+		loopStats, err = runOpenLoop(runCtx, bucketHandle, workers)
+	} else {
+		for i := 0; i < *numOfWorker; i++ {
+			idx := i
+			eG.Go(func() error {
+				err := ReadObject(runCtx, idx, bucketHandle, workers[idx])
+				if err != nil {
+					err = fmt.Errorf("while reading object %v: %w", workers[idx].object, err)
+					return err
+				}
 				return err
-			}
-			return err
-		})
+			})
+		}
+		err = eG.Wait()
 	}
+	window.stop()
 
-	err = eG.Wait()
+	report := newReport(window.measureStart, time.Now(), workers, err)
+	if openLoop() {
+		report.OpenLoop = newOpenLoopReport(loopStats)
+	}
+	if hedger != nil {
+		report.Hedge = newHedgeReport(hedger.Stats())
+	}
+	report.Print()
+	if *reportJSON != "" {
+		if err := report.WriteJSON(*reportJSON); err != nil {
+			fmt.Fprintf(os.Stderr, "while writing %s: %v\n", *reportJSON, err)
+			os.Exit(1)
+		}
+	}
+	if *reportCSV != "" {
+		if err := report.WriteCSV(*reportCSV); err != nil {
+			fmt.Fprintf(os.Stderr, "while writing %s: %v\n", *reportCSV, err)
+			os.Exit(1)
+		}
+	}
 
 	if err == nil {
 		fmt.Println("Read benchmark completed successfully!")
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/raj-prince/custom-go-client-benchmark/util"
+)
+
+// openLoop reports whether reads are issued at a fixed arrival rate rather
+// than back to back by each worker.
+func openLoop() bool {
+	return *arrivalRate > 0 || *targetMBps > 0
+}
+
+// openLoopRate returns the arrival rate of the open loop in reads per second.
+// --target-mbps is converted with the read size of the workload, or the size
+// of the first object when every call reads a whole object.
+func openLoopRate(ctx context.Context, bucketHandle *storage.BucketHandle) (float64, error) {
+	if *arrivalRate > 0 {
+		return *arrivalRate, nil
+	}
+
+	readSize := workloadConfig.RangeSize
+	if readSize == 0 {
+		attrs, err := bucketHandle.Object(*objectNamePrefix + "0" + *objectNameSuffix).Attrs(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("while fetching the object size: %w", err)
+		}
+		readSize = attrs.Size
+	}
+	if readSize <= 0 {
+		return 0, fmt.Errorf("can't convert --target-mbps to a rate with read size %d", readSize)
+	}
+	return *targetMBps * float64(MB) / float64(readSize), nil
+}
+
+// runOpenLoop issues reads at the open loop arrival rate until ctx is done or
+// every worker could have done --read-call-per-worker reads. Worker i serves
+// its arrivals with its own object and workload, and records the results in
+// workers[i]. Latencies are measured from the intended send time. A failed
+// read doesn't stop the run, the first error is returned.
+func runOpenLoop(ctx context.Context, bucketHandle *storage.BucketHandle, workers []*workerStats) (util.OpenLoopStats, error) {
+	rate, err := openLoopRate(ctx, bucketHandle)
+	if err != nil {
+		return util.OpenLoopStats{}, err
+	}
+	loop, err := util.NewOpenLoop(util.OpenLoopConfig{
+		Rate:         rate,
+		Distribution: *arrivalDistribution,
+		Workers:      len(workers),
+		MaxBacklog:   *maxBacklog,
+		MaxArrivals:  uint64(len(workers)) * uint64(*numOfReadCallPerWorker),
+		Seed:         *workloadSeed,
+	})
+	if err != nil {
+		return util.OpenLoopStats{}, err
+	}
+
+	objects := make([]*storage.ObjectHandle, len(workers))
+	workloads := make([]Workload, len(workers))
+	for i, ws := range workers {
+		objects[i] = bucketHandle.Object(ws.object)
+		if workloads[i], err = newWorkerWorkload(ctx, i, objects[i]); err != nil {
+			return util.OpenLoopStats{}, fmt.Errorf("while creating workload of %v: %w", ws.object, err)
+		}
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	start := time.Now()
+	for _, ws := range workers {
+		ws.start = start
+	}
+	stats := loop.Run(ctx, func(ctx context.Context, worker int, intended time.Time) {
+		ws := workers[worker]
+		err := readRange(ctx, objects[worker], workloads[worker], workloads[worker].Next(), ws, intended)
+		// A read interrupted by the end of the run isn't an error.
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		ws.errors++
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = fmt.Errorf("while reading object %v: %w", ws.object, err)
+		}
+		mu.Unlock()
+	})
+	end := time.Now()
+	for _, ws := range workers {
+		ws.end = end
+	}
+	return stats, firstErr
+}
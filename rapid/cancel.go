@@ -0,0 +1,85 @@
+package rapid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrRangeCanceled is reported for a range whose context is done before the
+// range completes. It wraps the cause of the context, so errors.Is also
+// matches context.Canceled or context.DeadlineExceeded.
+var ErrRangeCanceled = errors.New("range canceled")
+
+func rangeCanceled(ctx context.Context) error {
+	return fmt.Errorf("%w: %w", ErrRangeCanceled, context.Cause(ctx))
+}
+
+// cancelableRange reports a range to its callback when it completes or when
+// its context is done, whichever happens first. A MultiRangeDownloader can't
+// cancel a single range, so a canceled range keeps downloading in the
+// background, but its data is no longer written to the output.
+type cancelableRange struct {
+	mu       sync.Mutex
+	output   io.Writer
+	callback func(int64, int64, error)
+	done     bool
+	// stop stops reporting the cancellation of the range.
+	stop func() bool
+}
+
+// cancelable returns the output and callback to add a range at offset with,
+// so that it is reported as canceled when ctx is done. They are returned as
+// is if ctx is never done.
+func cancelable(ctx context.Context, offset int64, output io.Writer, callback func(int64, int64, error)) (io.Writer, func(int64, int64, error)) {
+	if ctx.Done() == nil {
+		return output, callback
+	}
+
+	r := &cancelableRange{output: output, callback: callback}
+	r.mu.Lock()
+	r.stop = context.AfterFunc(ctx, func() {
+		r.finish(offset, 0, rangeCanceled(ctx))
+	})
+	r.mu.Unlock()
+	return r, r.complete
+}
+
+// Write writes p to the output until the range is reported.
+func (r *cancelableRange) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.done || r.output == nil {
+		// Dropped, as for the losing download of a hedged range.
+		return len(p), nil
+	}
+	return r.output.Write(p)
+}
+
+// complete reports the completion of the range, unless it was canceled.
+func (r *cancelableRange) complete(offset, n int64, err error) {
+	r.mu.Lock()
+	stop := r.stop
+	r.mu.Unlock()
+	stop()
+
+	r.finish(offset, n, err)
+}
+
+// finish reports the range to the callback, once.
+func (r *cancelableRange) finish(offset, n int64, err error) {
+	r.mu.Lock()
+	if r.done {
+		r.mu.Unlock()
+		return
+	}
+	r.done = true
+	r.mu.Unlock()
+
+	if r.callback != nil {
+		r.callback(offset, n, err)
+	}
+}
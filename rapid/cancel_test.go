@@ -0,0 +1,116 @@
+package rapid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMRDPool_AddCanceledContext(t *testing.T) {
+	pool, mocks := newMockPool(2, SelectRoundRobin)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var buf bytes.Buffer
+	err := pool.Add(ctx, &buf, 0, 1024, nil)
+	if !errors.Is(err, ErrRangeCanceled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Add() error = %v, want ErrRangeCanceled", err)
+	}
+	for _, mock := range mocks {
+		if mock.pending() != 0 {
+			t.Fatalf("downloader %d got a canceled range", mock.id)
+		}
+	}
+}
+
+func TestMRDPool_CancelInFlight(t *testing.T) {
+	pool, mocks := newMockPool(1, SelectRoundRobin)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int32
+	done := make(chan error, 2)
+	var buf bytes.Buffer
+	if err := pool.Add(ctx, &buf, 0, 1024, func(offset, length int64, err error) {
+		calls.Add(1)
+		done <- err
+	}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	// The mock downloader completes the range only on Wait.
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrRangeCanceled) || !errors.Is(err, context.Canceled) {
+			t.Fatalf("callback error = %v, want ErrRangeCanceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback not called on cancellation")
+	}
+
+	// The range stays in flight on the downloader until it completes, and is
+	// reported only once.
+	if got := pool.GetStats().Downloaders[0].InFlight; got != 1 {
+		t.Fatalf("in flight after cancellation: got %d, want 1", got)
+	}
+	mocks[0].Wait()
+	if got := pool.GetStats().Downloaders[0].InFlight; got != 0 {
+		t.Fatalf("in flight after completion: got %d, want 0", got)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("callback called %d times, want 1", got)
+	}
+}
+
+func TestMRDPool_RangeDeadline(t *testing.T) {
+	pool := &MRDPool{
+		downloaders: []MultiRangeDownloader{&delayedDownloader{delay: 200 * time.Millisecond, fill: 1}},
+		poolSize:    1,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var buf bytes.Buffer
+	done := make(chan error, 1)
+	if err := pool.Add(ctx, &buf, 0, 16, func(_, _ int64, err error) { done <- err }); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := <-done; !errors.Is(err, ErrRangeCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("callback error = %v, want a deadline exceeded ErrRangeCanceled", err)
+	}
+
+	// The data of the canceled range is dropped.
+	time.Sleep(300 * time.Millisecond)
+	if buf.Len() != 0 {
+		t.Fatalf("canceled range wrote %d bytes to the output", buf.Len())
+	}
+}
+
+func TestMRDPool_HedgedAddCanceled(t *testing.T) {
+	pool := &MRDPool{
+		downloaders: []MultiRangeDownloader{
+			&delayedDownloader{delay: time.Second},
+			&delayedDownloader{delay: time.Second},
+		},
+		poolSize: 2,
+		cfg:      &MRDPoolConfig{Hedger: newTestHedger(t, 10*time.Millisecond)},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var buf bytes.Buffer
+	done := make(chan error, 1)
+	start := time.Now()
+	if err := pool.Add(ctx, &buf, 0, 16, func(_, _ int64, err error) { done <- err }); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := <-done; !errors.Is(err, ErrRangeCanceled) {
+		t.Fatalf("callback error = %v, want ErrRangeCanceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("canceled hedged Add took %v", elapsed)
+	}
+}
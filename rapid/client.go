@@ -0,0 +1,26 @@
+package rapid
+
+import (
+	"context"
+
+	"cloud.google.com/go/storage"
+	"cloud.google.com/go/storage/experimental"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// NewEndpointGrpcClient creates a gRPC client with bidi reads enabled, as
+// required by MultiRangeDownloader, that talks to endpoint (host:port) in
+// plaintext and without authentication. It is meant for emulators and
+// fakegcs.
+func NewEndpointGrpcClient(ctx context.Context, endpoint string) (*storage.Client, error) {
+	return storage.NewGRPCClient(ctx,
+		option.WithEndpoint(endpoint),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		option.WithGRPCConnectionPool(1),
+		storage.WithDisabledClientMetrics(),
+		experimental.WithGRPCBidiReads(),
+	)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// byteBudget bounds the bytes of the ranges in flight, see
+// --max-in-flight-bytes: without --discard-io every range in flight holds a
+// buffer of its length. Scheduling blocks while the budget is spent. The
+// bytes in flight are tracked even without a limit, for their peak.
+type byteBudget struct {
+	// sem holds the budget, nil without a limit.
+	sem   *semaphore.Weighted
+	limit int64
+
+	inFlight atomic.Int64
+	peak     atomic.Int64
+	// waits counts the ranges that waited for the budget, for waitTime
+	// nanoseconds in total.
+	waits    atomic.Uint64
+	waitTime atomic.Int64
+}
+
+// newByteBudget returns a budget of limit bytes, unlimited if 0.
+func newByteBudget(limit int64) *byteBudget {
+	b := &byteBudget{limit: limit}
+	if limit > 0 {
+		b.sem = semaphore.NewWeighted(limit)
+	}
+	return b
+}
+
+// weight returns the budget taken by a range of n bytes: a range larger than
+// the budget takes all of it, so that it can still be scheduled.
+func (b *byteBudget) weight(n int64) int64 {
+	if b.sem == nil {
+		return n
+	}
+	return min(n, b.limit)
+}
+
+// acquire takes n bytes of the budget, waiting until they are available or
+// ctx is done.
+func (b *byteBudget) acquire(ctx context.Context, n int64) error {
+	n = b.weight(n)
+	if b.sem != nil && !b.sem.TryAcquire(n) {
+		start := time.Now()
+		if err := b.sem.Acquire(ctx, n); err != nil {
+			return err
+		}
+		b.waits.Add(1)
+		b.waitTime.Add(int64(time.Since(start)))
+	}
+
+	inFlight := b.inFlight.Add(n)
+	for {
+		peak := b.peak.Load()
+		if inFlight <= peak || b.peak.CompareAndSwap(peak, inFlight) {
+			return nil
+		}
+	}
+}
+
+// release gives back the n bytes of a completed range.
+func (b *byteBudget) release(n int64) {
+	n = b.weight(n)
+	b.inFlight.Add(-n)
+	if b.sem != nil {
+		b.sem.Release(n)
+	}
+}
+
+// print logs the peak bytes in flight and the waits for the budget.
+func (b *byteBudget) print() {
+	limit := "unlimited"
+	if b.sem != nil {
+		limit = formatMB(b.limit)
+	}
+	logger.Info("Peak In-Flight Bytes: %s (budget %s)", formatMB(b.peak.Load()), limit)
+	if waits := b.waits.Load(); waits > 0 {
+		logger.Info("Budget Waits: %d, Average Wait: %v", waits, time.Duration(b.waitTime.Load()/int64(waits)))
+	}
+}
+
+// formatMB formats n bytes in MB.
+func formatMB(n int64) string {
+	return fmt.Sprintf("%.2f MB", float64(n)/(1024*1024))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestByteBudget_AcquireRelease(t *testing.T) {
+	b := newByteBudget(100)
+	ctx := context.Background()
+
+	if err := b.acquire(ctx, 60); err != nil {
+		t.Fatalf("acquire(60) error = %v", err)
+	}
+	if err := b.acquire(ctx, 40); err != nil {
+		t.Fatalf("acquire(40) error = %v", err)
+	}
+	if got := b.inFlight.Load(); got != 100 {
+		t.Fatalf("inFlight = %d, want 100", got)
+	}
+
+	// The budget is spent, the next range waits for a release.
+	acquired := make(chan error)
+	go func() { acquired <- b.acquire(ctx, 50) }()
+	select {
+	case err := <-acquired:
+		t.Fatalf("acquire(50) returned %v with the budget spent", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	b.release(60)
+	if err := <-acquired; err != nil {
+		t.Fatalf("acquire(50) after release error = %v", err)
+	}
+	if got := b.inFlight.Load(); got != 90 {
+		t.Fatalf("inFlight = %d, want 90", got)
+	}
+	if got := b.waits.Load(); got != 1 {
+		t.Fatalf("waits = %d, want 1", got)
+	}
+	// The peak is the most bytes in flight at once.
+	if got := b.peak.Load(); got != 100 {
+		t.Fatalf("peak = %d, want 100", got)
+	}
+
+	b.release(40)
+	b.release(50)
+	if got := b.inFlight.Load(); got != 0 {
+		t.Fatalf("inFlight = %d after releasing everything, want 0", got)
+	}
+}
+
+func TestByteBudget_Weight(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int64
+		n     int64
+		want  int64
+	}{
+		{name: "unlimited", limit: 0, n: 1 << 40, want: 1 << 40},
+		{name: "below limit", limit: 100, n: 30, want: 30},
+		{name: "at limit", limit: 100, n: 100, want: 100},
+		{name: "above limit", limit: 100, n: 250, want: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newByteBudget(tt.limit).weight(tt.n); got != tt.want {
+				t.Fatalf("weight(%d) = %d, want %d", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestByteBudget_LargeRange(t *testing.T) {
+	b := newByteBudget(100)
+	ctx := context.Background()
+
+	// A range larger than the budget takes all of it instead of blocking
+	// forever.
+	if err := b.acquire(ctx, 250); err != nil {
+		t.Fatalf("acquire(250) error = %v", err)
+	}
+	if got := b.inFlight.Load(); got != 100 {
+		t.Fatalf("inFlight = %d, want 100", got)
+	}
+	b.release(250)
+	if err := b.acquire(ctx, 100); err != nil {
+		t.Fatalf("acquire(100) after release error = %v", err)
+	}
+}
+
+func TestByteBudget_Unlimited(t *testing.T) {
+	b := newByteBudget(0)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := b.acquire(ctx, 1<<30); err != nil {
+			t.Fatalf("acquire() error = %v", err)
+		}
+	}
+	b.release(1 << 30)
+	// Without a limit the bytes in flight are still tracked, for their peak.
+	if got := b.inFlight.Load(); got != 2<<30 {
+		t.Fatalf("inFlight = %d, want %d", got, 2<<30)
+	}
+	if got := b.peak.Load(); got != 3<<30 {
+		t.Fatalf("peak = %d, want %d", got, 3<<30)
+	}
+}
+
+func TestByteBudget_AcquireCanceled(t *testing.T) {
+	b := newByteBudget(100)
+	if err := b.acquire(context.Background(), 100); err != nil {
+		t.Fatalf("acquire(100) error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	acquired := make(chan error)
+	go func() { acquired <- b.acquire(ctx, 10) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-acquired:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("acquire() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire() didn't return after ctx was canceled")
+	}
+	// The canceled range took nothing.
+	if got := b.inFlight.Load(); got != 100 {
+		t.Fatalf("inFlight = %d, want 100", got)
+	}
+	if got := b.waits.Load(); got != 0 {
+		t.Fatalf("waits = %d, want 0", got)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+
+	"github.com/raj-prince/custom-go-client-benchmark/rapid"
+	"github.com/raj-prince/custom-go-client-benchmark/rapid/workerpool"
+)
+
+// downloadCommand is the subcommand downloading the object to --output with
+// rapid.DownloadToFile, instead of benchmarking reads of it.
+const downloadCommand = "download"
+
+// downloadOutput returns the file the object is downloaded to.
+func downloadOutput() string {
+	if *fOutput != "" {
+		return *fOutput
+	}
+	return path.Base(*fObjectName)
+}
+
+// runDownload downloads the object of pool to --output in --io-size ranges.
+// An interrupted download, including by SIGINT or SIGTERM, is resumed by the
+// next run with --resume.
+func runDownload(ctx context.Context, pool *rapid.MRDPool, workerPool workerpool.WorkerPool) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	output := downloadOutput()
+	logger.Info("Downloading gs://%s/%s to %s...", *fBucketName, *fObjectName, output)
+	stats, err := rapid.DownloadToFile(ctx, pool, workerPool, output, rapid.DownloadOptions{
+		RangeSize:   *fIoSize,
+		Preallocate: *fPreallocate,
+		Resume:      *fResume,
+	})
+	if err != nil {
+		if stats != nil {
+			logger.Info("Partial download kept in %s.part, run again to resume", output)
+		}
+		return err
+	}
+
+	logger.Info("\n=== Download Complete ===")
+	logger.Info("Output: %s", output)
+	logger.Info("Generation: %d", stats.Generation)
+	logger.Info("Size: %.2f MB", float64(stats.Size)/(1024*1024))
+	logger.Info("Ranges: %d (%d resumed)", stats.Ranges, stats.Resumed)
+	logger.Info("Duration: %v", stats.Duration)
+	if stats.Ranges > 0 {
+		// Approximately, the last range may be shorter.
+		downloaded := float64(stats.Size) * float64(stats.Ranges-stats.Resumed) / float64(stats.Ranges)
+		logger.Info("Average Throughput: %.2f MB/s", downloaded/(1024*1024)/stats.Duration.Seconds())
+	}
+	return nil
+}
+
+// printDownloadConfig prints the configuration of the download subcommand.
+func printDownloadConfig() {
+	logger.Info("Starting MRD Pool Download")
+	logger.Info("Configuration:")
+	logger.Info("  Range Size: %d bytes (%.2f MB)", *fIoSize, float64(*fIoSize)/(1024*1024))
+	logger.Info("  MRD Pool Size: %d", *fPoolSize)
+	logger.Info("  Normal Workers: %d", *fNormalWorkers)
+	if *fMaxAttempts > 1 {
+		logger.Info("  Range Attempts: %d (backoff %v to %v, x%.1f)", *fMaxAttempts, *fRetryInitialBackoff, *fRetryMaxBackoff, *fRetryBackoffMultiplier)
+	}
+	logger.Info("  Bucket: %s", *fBucketName)
+	logger.Info("  Object: %s", *fObjectName)
+	logger.Info("  Output: %s", downloadOutput())
+	logger.Info("  Preallocate: %v, Resume: %v", *fPreallocate, *fResume)
+	if *fEndpoint != "" {
+		logger.Info("  Endpoint: %s", *fEndpoint)
+	}
+}
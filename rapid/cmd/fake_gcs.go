@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/raj-prince/custom-go-client-benchmark/fakegcs"
+)
+
+// startFakeGCS starts an in-process fake GCS holding the benchmarked object
+// and points the endpoint override at it. The returned func stops it.
+func startFakeGCS() (cleanup func(), err error) {
+	server, err := fakegcs.NewServer(fakegcs.Options{
+		Latency:        *fFakeLatency,
+		BytesPerSecond: *fFakeBandwidth,
+		ErrorRate:      *fFakeErrorRate,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	server.PutRandomObject(*fBucketName, *fObjectName, *fFakeObjectSize)
+	*fEndpoint = server.GRPCEndpoint()
+	logger.Info("Fake GCS started at %s", *fEndpoint)
+
+	return server.Close, nil
+}
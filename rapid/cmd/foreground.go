@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/raj-prince/custom-go-client-benchmark/rapid"
+	"github.com/raj-prince/custom-go-client-benchmark/rapid/workerpool"
+	"github.com/raj-prince/custom-go-client-benchmark/util"
+)
+
+// foreground reports whether a simulated reader reads foreground ranges, see
+// --foreground-ranges.
+func foreground() bool {
+	return *fForegroundRanges > 0
+}
+
+// classLatencies are the latencies of the foreground and prefetch ranges,
+// from their scheduling to their completion, so they include the time spent
+// in the worker pool queue.
+type classLatencies struct {
+	mu         sync.Mutex
+	foreground *util.Histogram
+	prefetch   *util.Histogram
+	// stalls are the waits of the reader for the range at its cursor.
+	stalls *util.Histogram
+}
+
+func newClassLatencies() *classLatencies {
+	return &classLatencies{
+		foreground: util.NewHistogram(),
+		prefetch:   util.NewHistogram(),
+		stalls:     util.NewHistogram(),
+	}
+}
+
+// record records the latency of a range scheduled at start, urgent if a
+// foreground range. l may be nil, without a simulated reader.
+func (l *classLatencies) record(urgent bool, start time.Time) {
+	if l == nil {
+		return
+	}
+	d := time.Since(start)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if urgent {
+		l.foreground.Record(d)
+	} else {
+		l.prefetch.Record(d)
+	}
+}
+
+// recordStall records a wait of the reader since start.
+func (l *classLatencies) recordStall(start time.Time) {
+	d := time.Since(start)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stalls.Record(d)
+}
+
+// print logs the latencies of both classes.
+func (l *classLatencies) print() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	logger.Info("\n=== Latency by Class ===")
+	logger.Info("Foreground (urgent, %d ranges): %s", l.foreground.Count(), formatPercentiles(l.foreground))
+	logger.Info("Prefetch (normal, %d ranges): %s", l.prefetch.Count(), formatPercentiles(l.prefetch))
+	logger.Info("Reader stalls (%d): %s", l.stalls.Count(), formatPercentiles(l.stalls))
+}
+
+// runForegroundReader simulates a reader going through the object front to
+// back until ctx is done, see --foreground-ranges. It keeps the ranges right
+// after its cursor in flight as urgent tasks, for the priority workers, and
+// moves its cursor once the range at the cursor is read, in order. The
+// ranges of --range-pattern are its prefetches, scheduled as normal tasks.
+//
+// Foreground ranges don't wait for the in-flight byte budget, they are few
+// and must not queue behind the prefetches.
+func runForegroundReader(ctx context.Context, downloadCtx context.Context, objectSize int64, pool *rapid.MRDPool, workerPool workerpool.WorkerPool, latencies *classLatencies) {
+	ranges := rapid.SplitRanges(objectSize, *fIoSize)
+	// window holds the done channels of the ranges after the cursor, in
+	// order.
+	var window []chan struct{}
+	var seq int64
+
+reader:
+	for ctx.Err() == nil {
+		for len(window) < *fForegroundRanges {
+			downloadRange := ranges[seq%int64(len(ranges))]
+			done := make(chan struct{})
+			writer, callback := newRangeOutput(seq, downloadRange, createDownloadCallback(seq))
+			start := time.Now()
+			err := workerPool.ScheduleContext(ctx, true, rapid.NewDownloadTask(downloadCtx, downloadRange, pool, writer, func(off, length int64, err error) {
+				callback(off, length, err)
+				if err == nil {
+					latencies.record(true, start)
+				}
+				close(done)
+			}).WithTimeout(*fRangeTimeout))
+			if err != nil {
+				break reader
+			}
+			window = append(window, done)
+			seq++
+		}
+
+		// Read the range at the cursor, the reader stalls until it is
+		// downloaded.
+		start := time.Now()
+		<-window[0]
+		window = window[1:]
+		latencies.recordStall(start)
+	}
+
+	// The ranges still in flight are downloaded until downloadCtx is done.
+	for _, done := range window {
+		<-done
+	}
+}
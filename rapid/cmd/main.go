@@ -3,9 +3,13 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
+	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -13,6 +17,7 @@ import (
 	"cloud.google.com/go/storage/experimental"
 	"github.com/raj-prince/custom-go-client-benchmark/rapid"
 	"github.com/raj-prince/custom-go-client-benchmark/rapid/workerpool"
+	"github.com/raj-prince/custom-go-client-benchmark/util"
 	"google.golang.org/api/option"
 
 	// Side effect to run grpc client with direct-path on gcp machine.
@@ -61,18 +66,108 @@ var (
 	fObjectName      = flag.String("object", "", "GCS object name (required)")
 	fDuration        = flag.Duration("duration", 60*time.Second, "Test duration (default: 60s)")
 	fPoolSize        = flag.Int("pool-size", 1, "MRD pool size (default: 1)")
-	fPriorityWorkers = flag.Int("priority-workers", 0, "Number of priority workers (default: 2)")
+	fMaxPoolSize     = flag.Int("max-pool-size", 0, "Grow the MRD pool up to this size with demand, 0 keeps --pool-size fixed")
+	fMinPoolSize     = flag.Int("min-pool-size", 1, "Shrink an elastic MRD pool down to this size when idle")
+	fScaleUpInFlight = flag.Int("scale-up-in-flight", 4, "Open another MRD when the open ones have more ranges in flight than this on average")
+	fIdleTimeout     = flag.Duration("idle-timeout", 30*time.Second, "Close the MRDs of an elastic pool idle for this long, 0 never closes them")
+	fSelection       = flag.String("selection", string(rapid.SelectRoundRobin), "MRD selection policy: round-robin, least-outstanding, power-of-two or latency-weighted")
+	fPriorityWorkers = flag.Int("priority-workers", 0, "Number of workers serving only urgent ranges, see --foreground-ranges")
 	fNormalWorkers   = flag.Int("normal-workers", 10, "Number of normal workers (default: 10)")
+	fWorkerPool      = flag.String("worker-pool", "static", "Worker pool: static starts every worker up front, dynamic starts them on demand up to --priority-workers and --normal-workers")
+	fWorkerKeepAlive = flag.Duration("worker-keep-alive", 10*time.Second, "Retire the workers of a dynamic worker pool idle for this long")
 	fDiscardIO       = flag.Bool("discard-io", false, "Discard downloaded IO instead of storing in buffer")
+	fMaxInFlight     = flag.Int64("max-in-flight-bytes", 0, "Block scheduling while the ranges in flight total this many bytes, 0 means no limit")
 	fDebug           = flag.Bool("debug", false, "Enable debug logging")
+	fRangeTimeout    = flag.Duration("range-timeout", 0, "Cancel a range not downloaded within this time, 0 means no timeout")
+	fDrainTimeout    = flag.Duration("drain-timeout", 30*time.Second, "At the end of --duration, finish the ranges already scheduled for up to this long, then cancel them; 0 cancels them right away")
+
+	// Range generation, see rapid.RangeGenerator. Ranges are read until
+	// --duration, starting over once the object or the trace is read.
+	fRangePattern       = flag.String("range-pattern", string(rapid.PatternSequential), "Ranges read: sequential, uniform (random blocks), zipf (hot spots) or trace (--trace-file)")
+	fIoSizeDistribution = flag.String("io-size-distribution", string(rapid.SizesFixed), "Lengths of the ranges: fixed (--io-size), uniform (--min-io-size to --max-io-size) or exponential (mean --io-size)")
+	fMinIoSize          = flag.Int64("min-io-size", 0, "Minimum length of the ranges with a uniform or exponential --io-size-distribution")
+	fMaxIoSize          = flag.Int64("max-io-size", 0, "Maximum length of the ranges with a uniform or exponential --io-size-distribution")
+	fZipfS              = flag.Float64("zipf-s", 1.1, "Skew of the zipf range pattern, above 1: the higher, the hotter the hot spots")
+	fTraceFile          = flag.String("trace-file", "", "Trace replayed by the trace range pattern, one \"offset length\" per line")
+	fSeed               = flag.Int64("seed", 0, "Seed of the random range patterns and lengths, 0 picks one")
+
+	// Simulated reader, see foreground.go.
+	fForegroundRanges = flag.Int("foreground-ranges", 0, "Simulate a sequential reader keeping this many ranges after its cursor in flight as urgent, the other ranges are normal prefetches; 0 disables it")
+
+	// download subcommand, see download.go.
+	fOutput      = flag.String("output", "", "download: file the object is downloaded to, the object name in the current directory by default")
+	fPreallocate = flag.Bool("preallocate", false, "download: reserve the disk space of the object before downloading it")
+	fResume      = flag.Bool("resume", true, "download: resume an interrupted download of the same object generation")
+
+	// Data verification, see verify.go.
+	fVerify     = flag.Bool("verify", false, "Assemble the ranges and check their CRC32C and the object's, instead of --discard-io")
+	fVerifyFile = flag.String("verify-file", "", "Assemble the ranges in this file instead of in memory with --verify")
+
+	// Per-range retries: a range failing mid-flight is added again after an
+	// exponential backoff.
+	fMaxAttempts            = flag.Int("max-attempts", 1, "Download attempts of a range failing mid-flight, 1 never retries")
+	fRetryInitialBackoff    = flag.Duration("retry-initial-backoff", 100*time.Millisecond, "Backoff before the first retry of a range")
+	fRetryMaxBackoff        = flag.Duration("retry-max-backoff", 10*time.Second, "Maximum backoff between retries of a range")
+	fRetryBackoffMultiplier = flag.Float64("retry-backoff-multiplier", 2, "Backoff multiplier after every retry of a range")
+
+	// Open-loop mode, see open_loop.go. Ranges are scheduled at a fixed
+	// arrival rate instead of all at once.
+	fArrivalRate         = flag.Float64("arrival-rate", 0, "Schedule ranges at this rate per second (open loop), 0 keeps a range in flight per worker (closed loop)")
+	fTargetMBps          = flag.Float64("target-mbps", 0, "Schedule ranges at the rate reaching this many MiB/s (open loop), for the mean length of the ranges")
+	fArrivalDistribution = flag.String("arrival-distribution", util.PoissonArrivals, "Inter-arrival times of the open loop: poisson or constant")
+	fMaxOutstanding      = flag.Int("max-outstanding", 0, "Ranges of the open loop in flight at once, 0 means the number of workers")
+	fMaxBacklog          = flag.Int("max-backlog", 1000, "Ranges of the open loop waiting to be scheduled, later ones are dropped")
+
+	// Hedged reads: a range still downloading after the hedge delay is added
+	// again to another MRD, the first download wins.
+	fHedge             = flag.Bool("hedge", false, "Hedge range downloads after a dynamic delay")
+	fHedgePercentile   = flag.Float64("hedge-percentile", 0.99, "Latency percentile the hedge delay converges to")
+	fHedgeIncreaseRate = flag.Float64("hedge-increase-rate", 15, "Number of slow ranges it takes for the hedge delay to double")
+	fHedgeInitialDelay = flag.Duration("hedge-initial-delay", 100*time.Millisecond, "Initial hedge delay")
+	fHedgeMinDelay     = flag.Duration("hedge-min-delay", time.Millisecond, "Minimum hedge delay")
+	fHedgeMaxDelay     = flag.Duration("hedge-max-delay", time.Minute, "Maximum hedge delay")
+
+	// Endpoint override, e.g. to target an emulator. Requests sent to an
+	// overridden endpoint are unauthenticated and in plaintext.
+	fEndpoint = flag.String("endpoint", "", "Override the gRPC endpoint, e.g. localhost:9001")
+
+	// In-process fake GCS, see fake_gcs.go.
+	fFakeGCS        = flag.Bool("fake-gcs", false, "Run against an in-process fake GCS instead of the real service")
+	fFakeObjectSize = flag.Int64("fake-object-size", 256*1024*1024, "Size of the object created in the fake GCS")
+	fFakeLatency    = flag.Duration("fake-latency", 0, "Latency added by the fake GCS to every request")
+	fFakeBandwidth  = flag.Int64("fake-bandwidth", 0, "Per-request bandwidth cap of the fake GCS in bytes/s, 0 means unlimited")
+	fFakeErrorRate  = flag.Float64("fake-error-rate", 0, "Probability that the fake GCS fails a request with a retryable error")
 
 	// Metrics
 	totalBytesRead  uint64
 	totalOperations uint64
 	totalErrors     uint64
+	// totalCanceled counts the ranges canceled by --range-timeout or by the
+	// end of --drain-timeout, they are not errors.
+	totalCanceled uint64
+	// totalDiscarded counts the ranges still queued in the worker pool at
+	// the end of --drain-timeout, never executed.
+	totalDiscarded uint64
+	// totalMismatches counts the ranges, or the whole object, failing
+	// --verify.
+	totalMismatches uint64
+
+	// objectVerifier checks the downloaded data with --verify.
+	objectVerifier *verifier
+
+	// inFlightBudget bounds the bytes of the ranges in flight, see budget.go.
+	inFlightBudget *byteBudget
+
+	// rangeLatencies are the latencies of the foreground and prefetch
+	// ranges with --foreground-ranges, nil otherwise.
+	rangeLatencies *classLatencies
 )
 
 func CreateGrpcClient(ctx context.Context) (client *storage.Client, err error) {
+	if *fEndpoint != "" {
+		return rapid.NewEndpointGrpcClient(ctx, *fEndpoint)
+	}
+
 	tokenSource, err := rapid.GetTokenSource(ctx, "")
 	if err != nil {
 		return nil, err
@@ -85,43 +180,26 @@ func CreateGrpcClient(ctx context.Context) (client *storage.Client, err error) {
 	)
 }
 
-// getObjectSize retrieves the size of the object from GCS
-func getObjectSize(ctx context.Context, client *storage.Client, bucket, object string) (int64, error) {
+// getObjectAttrs retrieves the attributes of the object from GCS, e.g. its
+// size and CRC32C
+func getObjectAttrs(ctx context.Context, client *storage.Client, bucket, object string) (*storage.ObjectAttrs, error) {
 	objectHandle := client.Bucket(bucket).Object(object)
-	attrs, err := objectHandle.Attrs(ctx)
-	if err != nil {
-		return 0, err
-	}
-	return attrs.Size, nil
-}
-
-// calculateRanges computes the download ranges based on object size and IO size
-func calculateRanges(objectSize, ioSize int64) []rapid.Range {
-	numRanges := (objectSize + ioSize - 1) / ioSize
-	ranges := make([]rapid.Range, 0, numRanges)
-
-	for rangeIdx := int64(0); rangeIdx < numRanges; rangeIdx++ {
-		offset := rangeIdx * ioSize
-		length := ioSize
-
-		// Adjust length for the last range
-		if offset+length > objectSize {
-			length = objectSize - offset
-		}
-
-		ranges = append(ranges, rapid.Range{
-			Offset: offset,
-			Length: length,
-		})
-	}
-
-	return ranges
+	return objectHandle.Attrs(ctx)
 }
 
 // createDownloadCallback creates a callback function for a download task
 func createDownloadCallback(rangeID int64) func(int64, int64, error) {
 	return func(off, len int64, err error) {
-		if err != nil {
+		if errors.Is(err, errMismatch) {
+			atomic.AddUint64(&totalMismatches, 1)
+			logger.Error("Range %d (offset %d, length %d) failed verification: %v", rangeID, off, len, err)
+		} else if errors.Is(err, workerpool.ErrPoolStopped) {
+			atomic.AddUint64(&totalDiscarded, 1)
+			logger.Debug("Range %d (offset %d, length %d) discarded: %v", rangeID, off, len, err)
+		} else if errors.Is(err, rapid.ErrRangeCanceled) {
+			atomic.AddUint64(&totalCanceled, 1)
+			logger.Debug("Range %d (offset %d, length %d) canceled: %v", rangeID, off, len, err)
+		} else if err != nil {
 			atomic.AddUint64(&totalErrors, 1)
 			logger.Error("Range %d (offset %d, length %d) failed: %v", rangeID, off, len, err)
 		} else {
@@ -132,51 +210,166 @@ func createDownloadCallback(rangeID int64) func(int64, int64, error) {
 	}
 }
 
-// scheduleDownloadTasks schedules all download tasks to the worker pool
-func scheduleDownloadTasks(
+// newRangeOutput returns the writer of the range r, the seq-th one
+// scheduled, and its callback: the verifier's with --verify, or else a buffer
+// or io.Discard.
+func newRangeOutput(seq int64, r rapid.Range, callback func(int64, int64, error)) (io.Writer, func(int64, int64, error)) {
+	if objectVerifier != nil {
+		w := objectVerifier.writer(r)
+		return w, w.callback(callback)
+	}
+	if *fDiscardIO {
+		return io.Discard, callback
+	}
+	return &bytes.Buffer{}, callback
+}
+
+// newWorkerPool returns the worker pool of --worker-pool, not started.
+func newWorkerPool() (workerpool.WorkerPool, error) {
+	switch *fWorkerPool {
+	case "static":
+		return workerpool.NewStaticWorkerPool(uint32(*fPriorityWorkers), uint32(*fNormalWorkers), 10000000)
+	case "dynamic":
+		return workerpool.NewDynamicWorkerPool(uint32(*fPriorityWorkers), uint32(*fNormalWorkers), *fWorkerKeepAlive, 10000000)
+	default:
+		return nil, fmt.Errorf("unknown worker pool %q: must be static or dynamic", *fWorkerPool)
+	}
+}
+
+// newRangeGenerator returns the generator of the ranges read from an object
+// of objectSize bytes, see --range-pattern.
+func newRangeGenerator(objectSize int64) (rapid.RangeGenerator, error) {
+	pattern, err := rapid.ParseRangePattern(*fRangePattern)
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := rapid.ParseSizeDistribution(*fIoSizeDistribution)
+	if err != nil {
+		return nil, err
+	}
+	seed := *fSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	logger.Debug("Range generator seed: %d", seed)
+	return rapid.NewRangeGenerator(rapid.RangeGeneratorConfig{
+		Pattern:    pattern,
+		ObjectSize: objectSize,
+		IOSize:     *fIoSize,
+		Sizes:      sizes,
+		MinIOSize:  *fMinIoSize,
+		MaxIOSize:  *fMaxIoSize,
+		ZipfS:      *fZipfS,
+		TraceFile:  *fTraceFile,
+		Seed:       seed,
+	})
+}
+
+// runClosedLoop keeps as many ranges of gen in flight as there are workers
+// until ctx is done, scheduling the next range as soon as one completes. The
+// ranges are downloaded until downloadCtx is done. It returns the number of
+// ranges scheduled, once they are all reported.
+func runClosedLoop(
 	ctx context.Context,
-	ranges []rapid.Range,
+	downloadCtx context.Context,
+	gen rapid.RangeGenerator,
 	pool *rapid.MRDPool,
 	workerPool workerpool.WorkerPool,
 ) int64 {
+	slots := make(chan struct{}, max(*fPriorityWorkers+*fNormalWorkers, 1))
+	var wg sync.WaitGroup
 	tasksScheduled := int64(0)
 
-	for rangeIdx, downloadRange := range ranges {
-		// Check if context is done
+	for {
 		select {
+		case slots <- struct{}{}:
 		case <-ctx.Done():
-			logger.Info("Context cancelled, stopping task scheduling after %d tasks", tasksScheduled)
-			return tasksScheduled
-		default:
 		}
-
-		// Create a buffer for this range
-		var writer io.Writer
-		if *fDiscardIO {
-			writer = io.Discard
-		} else {
-			writer = &bytes.Buffer{}
+		if ctx.Err() != nil {
+			logger.Debug("Context done, stopping task scheduling after %d tasks", tasksScheduled)
+			break
 		}
 
-		// Create callback for this range
-		callback := createDownloadCallback(int64(rangeIdx))
-
-		// Create download task
-		task := rapid.NewDownloadTask(downloadRange, pool, writer, callback)
+		// Wait for the budget of the next range
+		downloadRange := gen.Next()
+		if err := inFlightBudget.acquire(ctx, downloadRange.Length); err != nil {
+			<-slots
+			logger.Debug("Context done, stopping task scheduling after %d tasks", tasksScheduled)
+			break
+		}
 
-		// Schedule task to worker pool (use normal priority)
-		workerPool.Schedule(false, task)
+		// Create the output and the callback for the range
+		writer, callback := newRangeOutput(tasksScheduled, downloadRange, createDownloadCallback(tasksScheduled))
+
+		// Create download task, its slot and budget are freed once it
+		// completes
+		wg.Add(1)
+		start := time.Now()
+		task := rapid.NewDownloadTask(downloadCtx, downloadRange, pool, writer, func(off, length int64, err error) {
+			callback(off, length, err)
+			if err == nil {
+				rangeLatencies.record(false, start)
+			}
+			inFlightBudget.release(downloadRange.Length)
+			<-slots
+			wg.Done()
+		}).WithTimeout(*fRangeTimeout)
+
+		// Schedule task to worker pool (use normal priority), waiting for
+		// room in its queue
+		if err := workerPool.ScheduleContext(ctx, false, task); err != nil {
+			inFlightBudget.release(downloadRange.Length)
+			<-slots
+			wg.Done()
+			logger.Debug("Stopping task scheduling after %d tasks: %v", tasksScheduled, err)
+			break
+		}
 		tasksScheduled++
 
-		// Throttle if too many tasks are pending
 		if tasksScheduled%1000 == 0 {
 			logger.Debug("Scheduled %d tasks...", tasksScheduled)
 		}
 	}
 
+	wg.Wait()
 	return tasksScheduled
 }
 
+// drainWorkerPool drains workerPool once ctx is done, the end of the
+// benchmark, for up to --drain-timeout. The downloads of the ranges still in
+// flight are then canceled with cancelDownloads, and the queued ones
+// discarded, see discardTask.
+func drainWorkerPool(ctx context.Context, workerPool workerpool.WorkerPool, cancelDownloads context.CancelFunc) error {
+	<-ctx.Done()
+	logger.Debug("Draining the worker pool for up to %v", *fDrainTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), *fDrainTimeout)
+	defer cancel()
+	stop := context.AfterFunc(drainCtx, cancelDownloads)
+	defer stop()
+	return workerPool.Drain(drainCtx)
+}
+
+// discardTask reports a range discarded by the worker pool, never
+// downloaded, to its callback.
+func discardTask(_ bool, task workerpool.Task) {
+	if downloadTask, ok := task.(*rapid.DownloadTask); ok {
+		downloadTask.Discard(workerpool.ErrPoolStopped)
+	}
+}
+
+// taskFailed handles a task of the worker pool that failed. The ranges that
+// failed are already reported to their callbacks, but a task that panicked
+// may not have reported its range, which is then reported as failed.
+func taskFailed(_ bool, task workerpool.Task, err error) {
+	var panicErr *workerpool.PanicError
+	if !errors.As(err, &panicErr) {
+		return
+	}
+	if downloadTask, ok := task.(*rapid.DownloadTask); ok {
+		downloadTask.Discard(err)
+	}
+}
+
 // waitForCompletion waits for all downloads to complete and checks for errors
 func waitForCompletion(pool *rapid.MRDPool) error {
 	logger.Info("Waiting for all tasks to complete...")
@@ -189,6 +382,12 @@ func waitForCompletion(pool *rapid.MRDPool) error {
 }
 
 func main() {
+	// The download subcommand downloads the object instead of benchmarking.
+	download := len(os.Args) > 1 && os.Args[1] == downloadCommand
+	if download {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	// Parse and validate configuration
 	if err := parseAndValidateConfig(); err != nil {
 		log.Fatal("--bucket and --object are required")
@@ -202,8 +401,20 @@ func main() {
 		logger.Info("Debug logging enabled")
 	}
 
+	if *fFakeGCS {
+		cleanup, err := startFakeGCS()
+		if err != nil {
+			logger.Fatalf("Failed to start fake GCS: %v", err)
+		}
+		defer cleanup()
+	}
+
 	// Print configuration
-	printConfig()
+	if download {
+		printDownloadConfig()
+	} else {
+		printConfig()
+	}
 
 	// Create go storage client.
 	ctx := context.Background()
@@ -215,67 +426,171 @@ func main() {
 	logger.Debug("Created storage client successfully")
 
 	// Get object size
-	objectSize, err := getObjectSize(ctx, client, *fBucketName, *fObjectName)
+	attrs, err := getObjectAttrs(ctx, client, *fBucketName, *fObjectName)
 	if err != nil {
 		logger.Fatalf("Failed to get object attributes: %v", err)
 	}
+	objectSize := attrs.Size
 	logger.Debug("Object size: %d bytes (%.2f MB)", objectSize, float64(objectSize)/(1024*1024))
 
-	// Generate download ranges based on IO size.
-	ranges := calculateRanges(objectSize, *fIoSize)
-	logger.Debug("Will download %d ranges of size %d bytes", len(ranges), *fIoSize)
+	// Generate download ranges, see --range-pattern.
+	ranges, err := newRangeGenerator(objectSize)
+	if err != nil {
+		logger.Fatalf("Invalid range generator: %v", err)
+	}
+
+	if *fVerify {
+		objectVerifier, err = newVerifier(attrs, *fVerifyFile)
+		if err != nil {
+			logger.Fatalf("Failed to set up verification: %v", err)
+		}
+	}
 
 	// Create MRD pool.
+	selection, err := rapid.ParseSelectionPolicy(*fSelection)
+	if err != nil {
+		logger.Fatalf("Invalid --selection: %v", err)
+	}
 	poolConfig := &rapid.MRDPoolConfig{
-		PoolSize: *fPoolSize,
-		Client:   client,
-		Bucket:   *fBucketName,
-		Object:   *fObjectName,
+		PoolSize:  *fPoolSize,
+		Client:    client,
+		Bucket:    *fBucketName,
+		Object:    *fObjectName,
+		Selection: selection,
+	}
+	if *fVerify || download {
+		// Read the generation the attributes, and so the CRC32C and size,
+		// are of.
+		poolConfig.Generation = attrs.Generation
+	}
+	if *fMaxPoolSize > 0 {
+		poolConfig.MaxPoolSize = *fMaxPoolSize
+		poolConfig.MinPoolSize = *fMinPoolSize
+		poolConfig.ScaleUpInFlight = *fScaleUpInFlight
+		poolConfig.IdleTimeout = *fIdleTimeout
+	}
+	if *fMaxAttempts > 1 {
+		poolConfig.MaxAttempts = *fMaxAttempts
+		poolConfig.InitialBackoff = *fRetryInitialBackoff
+		poolConfig.MaxBackoff = *fRetryMaxBackoff
+		poolConfig.BackoffMultiplier = *fRetryBackoffMultiplier
+	}
+	if *fHedge {
+		delay, err := util.NewConcurrentDelay(*fHedgePercentile, *fHedgeIncreaseRate, *fHedgeInitialDelay, *fHedgeMinDelay, *fHedgeMaxDelay)
+		if err != nil {
+			logger.Fatalf("Invalid hedge delay: %v", err)
+		}
+		poolConfig.Hedger = util.NewHedger(delay)
 	}
 	pool, err := rapid.NewMRDPool(poolConfig)
 	if err != nil {
 		logger.Fatalf("Failed to create MRD pool: %v", err)
 	}
-	logger.Debug("Created MRD pool with %d instances", *fPoolSize)
+	logger.Debug("Created MRD pool with %d instances, reading generation %d", *fPoolSize, pool.Generation())
 
-	// Create static worker pool
-	workerPool, err := workerpool.NewStaticWorkerPool(uint32(*fPriorityWorkers), uint32(*fNormalWorkers), 10000000)
+	// Create worker pool
+	workerPool, err := newWorkerPool()
 	if err != nil {
 		logger.Fatalf("Failed to create worker pool: %v", err)
 	}
+	workerPool.OnDiscard(discardTask)
+	workerPool.OnError(taskFailed)
 	workerPool.Start()
-	logger.Debug("Created worker pool with %d priority and %d normal workers", *fPriorityWorkers, *fNormalWorkers)
+	logger.Debug("Created %s worker pool with %d priority and %d normal workers", *fWorkerPool, *fPriorityWorkers, *fNormalWorkers)
+
+	if download {
+		err := runDownload(ctx, pool, workerPool)
+		workerPool.Stop()
+		pool.Close()
+		if err != nil {
+			logger.Fatalf("Download failed: %v", err)
+		}
+		return
+	}
+
+	inFlightBudget = newByteBudget(*fMaxInFlight)
 
 	// Create context with timeout for the benchmark
 	benchCtx, cancel := context.WithTimeout(ctx, *fDuration)
 	defer cancel()
 
 	// Start stats reporter
-	go statsReporter(benchCtx, 5*time.Second)
+	go statsReporter(benchCtx, 5*time.Second, workerPool)
+
+	// Drain the worker pool at the end of the benchmark: the ranges already
+	// scheduled are downloaded, until --drain-timeout.
+	downloadCtx, cancelDownloads := context.WithCancel(ctx)
+	defer cancelDownloads()
+	drained := make(chan error, 1)
+	go func() {
+		drained <- drainWorkerPool(benchCtx, workerPool, cancelDownloads)
+	}()
 
 	startTime := time.Now()
 	logger.Debug("Starting download tasks...")
 
+	// Start the simulated reader, its foreground ranges are urgent
+	readerDone := make(chan struct{})
+	if foreground() {
+		rangeLatencies = newClassLatencies()
+		go func() {
+			defer close(readerDone)
+			runForegroundReader(benchCtx, downloadCtx, objectSize, pool, workerPool, rangeLatencies)
+		}()
+	} else {
+		close(readerDone)
+	}
+
 	// Schedule download tasks
-	tasksScheduled := scheduleDownloadTasks(benchCtx, ranges, pool, workerPool)
-	logger.Debug("Scheduled %d total tasks", tasksScheduled)
+	var loop *openLoopResult
+	if openLoop() {
+		loop, err = runOpenLoop(benchCtx, downloadCtx, objectSize, ranges, pool, workerPool)
+		if err != nil {
+			logger.Fatalf("Failed to run the open loop: %v", err)
+		}
+	} else {
+		tasksScheduled := runClosedLoop(benchCtx, downloadCtx, ranges, pool, workerPool)
+		logger.Debug("Scheduled %d total tasks", tasksScheduled)
+	}
 
 	// Wait for completion
+	<-readerDone
 	if err := waitForCompletion(pool); err != nil {
 		logger.Error("Pool reported errors: %v", err)
 	}
 
 	elapsed := time.Since(startTime)
 
-	workerPool.Stop()
+	if err := <-drained; err != nil {
+		logger.Info("Worker pool not drained within %v, the ranges left were canceled or discarded", *fDrainTimeout)
+	}
 	pool.Close()
 
+	if objectVerifier != nil {
+		if err := objectVerifier.finish(); err != nil {
+			logger.Error("Verification failed: %v", err)
+		}
+	}
+
 	// Print final statistics
 	printFinalStatistics(elapsed, pool)
+	printWorkerPoolStatistics(workerPool.Stats())
+	if loop != nil {
+		loop.print()
+	}
+	if rangeLatencies != nil {
+		rangeLatencies.print()
+	}
+	if poolConfig.Hedger != nil {
+		printHedgeStatistics(poolConfig.Hedger.Stats())
+	}
 
 	if totalErrors > 0 {
 		logger.Fatalf("\nBenchmark completed with %d errors", totalErrors)
 	}
+	if totalMismatches > 0 {
+		logger.Fatalf("\nBenchmark completed with %d verification mismatches", totalMismatches)
+	}
 
 	logger.Info("\nBenchmark completed successfully!")
 }
@@ -288,20 +603,81 @@ func printFinalStatistics(elapsed time.Duration, pool *rapid.MRDPool) {
 	logger.Info("Total Bytes Read: %.2f MB", float64(totalBytesRead)/(1024*1024))
 	logger.Info("Total Operations: %d", totalOperations)
 	logger.Info("Total Errors: %d", totalErrors)
+	logger.Info("Total Canceled: %d", totalCanceled)
+	logger.Info("Total Discarded: %d", totalDiscarded)
+	if *fVerify {
+		logger.Info("Total Mismatches: %d", totalMismatches)
+	}
 	logger.Info("Average Throughput: %.2f MB/s", float64(totalBytesRead)/elapsed.Seconds()/(1024*1024))
 	logger.Info("Average IOPS: %.2f", float64(totalOperations)/elapsed.Seconds())
+	inFlightBudget.print()
 
 	// Print pool statistics
 	poolStats := pool.GetStats()
 	logger.Info("\n=== Pool Statistics ===")
 	logger.Info("Pool Size: %d", poolStats.PoolSize)
+	logger.Info("Object Generation: %d", poolStats.Generation)
 	logger.Info("Total Requests: %d", poolStats.RequestCount)
+	if *fMaxPoolSize > 0 {
+		logger.Info("Scale Ups: %d, Scale Downs: %d", poolStats.ScaleUps, poolStats.ScaleDowns)
+		for _, e := range poolStats.ScaleEvents {
+			action := "closed"
+			if e.Opened {
+				action = "opened"
+			}
+			logger.Debug("%s: MRD %d %s, pool size %d: %s", e.Time.Format(time.RFC3339Nano), e.Index, action, e.PoolSize, e.Reason)
+		}
+	}
+	if len(poolStats.Attempts) > 0 {
+		logger.Info("Retries: %d", poolStats.Retries)
+		for i, n := range poolStats.Attempts {
+			logger.Info("Ranges completed in %d attempt(s): %d", i+1, n)
+		}
+	}
+	for _, d := range poolStats.Downloaders {
+		logger.Info("MRD %d: Completed: %d, EWMA Latency: %v, In Flight: %d", d.Index, d.Completed, d.Latency, d.InFlight)
+	}
+}
+
+// printHedgeStatistics prints the hedge rate and what hedging saved at the
+// tail. Primary latencies are lower bounds, as the losing download of a range
+// is dropped before it completes.
+func printHedgeStatistics(s util.HedgeStats) {
+	logger.Info("\n=== Hedge Statistics ===")
+	logger.Info("Hedged Ranges: %d of %d (%.2f%%), Backup Wins: %d", s.Hedged, s.Calls, 100*s.HedgeRate(), s.BackupWins)
+	logger.Info("Hedge Threshold: %v", s.Threshold)
+	logger.Info("Latency: %s", formatPercentiles(s.Latency))
+	logger.Info("Primary Latency (lower bound): %s", formatPercentiles(s.PrimaryLatency))
+	logger.Info("Improvement: p99 >= %v, p99.9 >= %v", s.TailImprovement(0.99), s.TailImprovement(0.999))
+}
+
+// printWorkerPoolStatistics prints the tasks of both lanes of the worker pool,
+// and how long they waited for a worker.
+func printWorkerPoolStatistics(s workerpool.Stats) {
+	logger.Info("\n=== Worker Pool Statistics ===")
+	for _, lane := range []struct {
+		name  string
+		stats workerpool.LaneStats
+	}{{"Priority", s.Priority}, {"Normal", s.Normal}} {
+		logger.Info("%s: Scheduled: %d, Executed: %d, Failed: %d, Panicked: %d, Rejected: %d, Discarded: %d, Mean Queue Time: %v, Mean Execute Time: %v",
+			lane.name, lane.stats.Scheduled, lane.stats.Executed, lane.stats.Failed, lane.stats.Panicked, lane.stats.Rejected, lane.stats.Discarded, lane.stats.MeanQueueTime(), lane.stats.MeanExecuteTime())
+	}
 }
 
 // parseAndValidateConfig parses command-line flags and validates the configuration
 func parseAndValidateConfig() error {
 	flag.Parse()
 
+	// The fake GCS creates the object, so the names have defaults.
+	if *fFakeGCS {
+		if *fBucketName == "" {
+			*fBucketName = "fake-bucket"
+		}
+		if *fObjectName == "" {
+			*fObjectName = "fake-object"
+		}
+	}
+
 	// Validate required flags
 	if *fBucketName == "" {
 		return flag.ErrHelp
@@ -319,16 +695,52 @@ func printConfig() {
 	logger.Info("Configuration:")
 	logger.Info("  IO Size: %d bytes (%.2f MB)", *fIoSize, float64(*fIoSize)/(1024*1024))
 	logger.Info("  MRD Pool Size: %d", *fPoolSize)
+	if *fMaxPoolSize > 0 {
+		logger.Info("  MRD Pool Size Range: %d-%d (scale up above %d ranges in flight per MRD, idle timeout %v)", *fMinPoolSize, *fMaxPoolSize, *fScaleUpInFlight, *fIdleTimeout)
+	}
+	logger.Info("  MRD Selection: %s", *fSelection)
+	logger.Info("  Range Pattern: %s, IO Sizes: %s", *fRangePattern, *fIoSizeDistribution)
+	if *fTraceFile != "" {
+		logger.Info("  Trace File: %s", *fTraceFile)
+	}
+	logger.Info("  Worker Pool: %s", *fWorkerPool)
+	if *fWorkerPool == "dynamic" {
+		logger.Info("  Worker Keep-Alive: %v", *fWorkerKeepAlive)
+	}
 	logger.Info("  Priority Workers: %d", *fPriorityWorkers)
+	if foreground() {
+		logger.Info("  Foreground Ranges: %d (urgent), the others are prefetches", *fForegroundRanges)
+	}
 	logger.Info("  Normal Workers: %d", *fNormalWorkers)
 	logger.Info("  Duration: %v", *fDuration)
+	if *fMaxInFlight > 0 {
+		logger.Info("  Max In-Flight Bytes: %s", formatMB(*fMaxInFlight))
+	}
+	if *fRangeTimeout > 0 {
+		logger.Info("  Range Timeout: %v", *fRangeTimeout)
+	}
+	logger.Info("  Drain Timeout: %v", *fDrainTimeout)
+	if *fVerify {
+		target := "memory"
+		if *fVerifyFile != "" {
+			target = *fVerifyFile
+		}
+		logger.Info("  Verify: CRC32C, assembled in %s", target)
+	}
+	if *fMaxAttempts > 1 {
+		logger.Info("  Range Attempts: %d (backoff %v to %v, x%.1f)", *fMaxAttempts, *fRetryInitialBackoff, *fRetryMaxBackoff, *fRetryBackoffMultiplier)
+	}
 	logger.Info("  Bucket: %s", *fBucketName)
 	logger.Info("  Object: %s", *fObjectName)
+	if *fEndpoint != "" {
+		logger.Info("  Endpoint: %s", *fEndpoint)
+	}
 	logger.Info("  Debug Mode: %v", *fDebug)
 }
 
-// statsReporter periodically reports throughput statistics
-func statsReporter(ctx context.Context, interval time.Duration) {
+// statsReporter periodically reports throughput statistics, and the
+// saturation of workerPool
+func statsReporter(ctx context.Context, interval time.Duration, workerPool workerpool.WorkerPool) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -361,6 +773,16 @@ func statsReporter(ctx context.Context, interval time.Duration) {
 				currentOps,
 				currentErrors,
 			)
+			poolStats := workerPool.Stats()
+			logger.Info("Worker Pool: Busy: %d/%d | Priority Queued: %d/%d | Normal Queued: %d/%d | Executed: %d",
+				poolStats.BusyWorkers,
+				poolStats.Workers,
+				poolStats.Priority.Queued,
+				poolStats.Priority.Capacity,
+				poolStats.Normal.Queued,
+				poolStats.Normal.Capacity,
+				poolStats.Priority.Executed+poolStats.Normal.Executed,
+			)
 
 			lastBytes = currentBytes
 			lastOps = currentOps
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/raj-prince/custom-go-client-benchmark/rapid"
+	"github.com/raj-prince/custom-go-client-benchmark/rapid/workerpool"
+	"github.com/raj-prince/custom-go-client-benchmark/util"
+)
+
+// openLoop reports whether ranges are scheduled at a fixed arrival rate.
+func openLoop() bool {
+	return *fArrivalRate > 0 || *fTargetMBps > 0
+}
+
+// rangeLengthSamples is the number of ranges drawn to estimate the mean range
+// length converting --target-mbps to an arrival rate.
+const rangeLengthSamples = 10000
+
+// meanRangeLength returns the mean length of the next n ranges of gen.
+func meanRangeLength(gen rapid.RangeGenerator, n int) float64 {
+	var total int64
+	for i := 0; i < n; i++ {
+		total += gen.Next().Length
+	}
+	return float64(total) / float64(n)
+}
+
+// openLoopResult is the outcome of runOpenLoop.
+type openLoopResult struct {
+	rate    float64
+	stats   util.OpenLoopStats
+	latency *util.Histogram
+}
+
+// runOpenLoop schedules ranges to workerPool at the open loop arrival rate
+// until ctx is done, the next range of ranges at every arrival, downloaded
+// until downloadCtx is done. Up to
+// --max-outstanding ranges are in flight, later arrivals wait in the backlog.
+// Range latencies are measured from their intended send time, so they include
+// the time spent in the backlog and in the worker pool queue.
+func runOpenLoop(ctx context.Context, downloadCtx context.Context, objectSize int64, ranges rapid.RangeGenerator, pool *rapid.MRDPool, workerPool workerpool.WorkerPool) (*openLoopResult, error) {
+	rate := *fArrivalRate
+	if rate == 0 {
+		// The ranges are not all --io-size long with other size
+		// distributions, a trace, or at the end of the object: sample a
+		// generator of the same ranges for their mean length.
+		sample, err := newRangeGenerator(objectSize)
+		if err != nil {
+			return nil, err
+		}
+		mean := meanRangeLength(sample, rangeLengthSamples)
+		rate = *fTargetMBps * 1024 * 1024 / mean
+		logger.Info("Open loop: %.2f MiB/s of ranges of %.0f bytes on average", *fTargetMBps, mean)
+	}
+	outstanding := *fMaxOutstanding
+	if outstanding == 0 {
+		outstanding = *fPriorityWorkers + *fNormalWorkers
+	}
+	loop, err := util.NewOpenLoop(util.OpenLoopConfig{
+		Rate:         rate,
+		Distribution: *fArrivalDistribution,
+		Workers:      outstanding,
+		MaxBacklog:   *fMaxBacklog,
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Open loop: %.2f ranges/s, %s arrivals, %d outstanding", rate, *fArrivalDistribution, outstanding)
+
+	var mu sync.Mutex
+	latency := util.NewHistogram()
+	var next atomic.Int64
+	stats := loop.Run(ctx, func(ctx context.Context, _ int, intended time.Time) {
+		seq := next.Add(1) - 1
+		mu.Lock()
+		downloadRange := ranges.Next()
+		mu.Unlock()
+
+		// Later arrivals wait in the backlog while the budget is spent.
+		if err := inFlightBudget.acquire(ctx, downloadRange.Length); err != nil {
+			return
+		}
+
+		done := make(chan struct{})
+		writer, callback := newRangeOutput(seq, downloadRange, createDownloadCallback(seq))
+		start := time.Now()
+		err := workerPool.ScheduleContext(ctx, false, rapid.NewDownloadTask(downloadCtx, downloadRange, pool, writer, func(off, length int64, err error) {
+			callback(off, length, err)
+			inFlightBudget.release(downloadRange.Length)
+			if err == nil {
+				rangeLatencies.record(false, start)
+				mu.Lock()
+				latency.Record(time.Since(intended))
+				mu.Unlock()
+			}
+			close(done)
+		}).WithTimeout(*fRangeTimeout))
+		if err != nil {
+			inFlightBudget.release(downloadRange.Length)
+			return
+		}
+		<-done
+	})
+
+	return &openLoopResult{rate: rate, stats: stats, latency: latency}, nil
+}
+
+// print logs the arrivals and the latencies of the open loop.
+func (r *openLoopResult) print() {
+	logger.Info("\n=== Open Loop ===")
+	logger.Info("Target Rate: %.2f ranges/s", r.rate)
+	logger.Info("Arrivals: %d, Issued: %d, Dropped: %d, Unissued: %d, Peak Backlog: %d",
+		r.stats.Arrivals, r.stats.Issued, r.stats.Dropped, r.stats.Unissued, r.stats.PeakBacklog)
+	logger.Info("Latency from intended send time: %s", formatPercentiles(r.latency))
+}
+
+// formatPercentiles formats the p50, p90, p99 and p99.9 of h.
+func formatPercentiles(h *util.Histogram) string {
+	return fmt.Sprintf("p50 %v | p90 %v | p99 %v | p99.9 %v | max %v",
+		h.Percentile(0.5), h.Percentile(0.9), h.Percentile(0.99), h.Percentile(0.999), h.Max())
+}
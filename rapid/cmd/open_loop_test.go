@@ -0,0 +1,45 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raj-prince/custom-go-client-benchmark/rapid"
+)
+
+func TestMeanRangeLength(t *testing.T) {
+	trace := filepath.Join(t.TempDir(), "trace")
+	if err := os.WriteFile(trace, []byte("0 100\n100,300\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		cfg  rapid.RangeGeneratorConfig
+		want float64
+	}{
+		{name: "fixed", cfg: rapid.RangeGeneratorConfig{ObjectSize: 1 << 20, IOSize: 1024}, want: 1024},
+		// The last range is cut at the end of the object.
+		{name: "fixed cut", cfg: rapid.RangeGeneratorConfig{ObjectSize: 1500, IOSize: 1000}, want: 750},
+		{
+			name: "uniform",
+			cfg:  rapid.RangeGeneratorConfig{ObjectSize: 1 << 30, IOSize: 1024, Sizes: rapid.SizesUniform, MinIOSize: 1000, MaxIOSize: 3000},
+			want: 2000,
+		},
+		{name: "trace", cfg: rapid.RangeGeneratorConfig{Pattern: rapid.PatternTrace, ObjectSize: 1000, TraceFile: trace}, want: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Seed = 1
+			gen, err := rapid.NewRangeGenerator(tt.cfg)
+			if err != nil {
+				t.Fatalf("NewRangeGenerator() error = %v", err)
+			}
+			// Within 2% of the mean of the distribution.
+			if got := meanRangeLength(gen, rangeLengthSamples); math.Abs(got-tt.want) > tt.want/50 {
+				t.Fatalf("meanRangeLength() = %v, want about %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"cloud.google.com/go/storage"
+	"github.com/raj-prince/custom-go-client-benchmark/rapid"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errMismatch is reported for a range whose data fails verification, counted
+// in totalMismatches apart from the download errors.
+var errMismatch = errors.New("data mismatch")
+
+// verifier checks the data of the downloaded ranges, see --verify. Ranges are
+// assembled at their offset in a file or in memory, and their CRC32C is
+// computed as they are written. A range read more than once must have the
+// same CRC32C every time. Once the ranges read cover the whole object, the
+// CRC32C of the assembled object must match the one of its attributes.
+type verifier struct {
+	attrs  *storage.ObjectAttrs
+	target interface {
+		io.WriterAt
+		io.ReaderAt
+	}
+	file *os.File // the target if --verify-file is set
+
+	mu sync.Mutex
+	// crcs are the CRC32C of the ranges read.
+	crcs map[rapid.Range]uint32
+}
+
+// newVerifier returns a verifier of the object of attrs, assembling it in the
+// file at path, or in memory if path is empty.
+func newVerifier(attrs *storage.ObjectAttrs, path string) (*verifier, error) {
+	v := &verifier{attrs: attrs, crcs: make(map[rapid.Range]uint32)}
+	if path == "" {
+		v.target = &memoryWriterAt{data: make([]byte, attrs.Size)}
+		return v, nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create verify file: %w", err)
+	}
+	if err := file.Truncate(attrs.Size); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to size verify file: %w", err)
+	}
+	v.target, v.file = file, file
+	return v, nil
+}
+
+// rangeWriter writes a range at its offset in the verifier target, hashing
+// it on the way.
+type rangeWriter struct {
+	v   *verifier
+	r   rapid.Range
+	w   io.Writer
+	crc hash.Hash32
+}
+
+// writer returns the writer of the range r.
+func (v *verifier) writer(r rapid.Range) *rangeWriter {
+	return &rangeWriter{
+		v:   v,
+		r:   r,
+		w:   io.NewOffsetWriter(v.target, r.Offset),
+		crc: crc32.New(crc32cTable),
+	}
+}
+
+func (w *rangeWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.crc.Write(p[:n])
+	return n, err
+}
+
+// check checks the range once downloaded with n bytes: its length, and its
+// CRC32C against the previous reads of the range.
+func (w *rangeWriter) check(n int64) error {
+	if n != w.r.Length {
+		return fmt.Errorf("%w: read %d bytes, want %d", errMismatch, n, w.r.Length)
+	}
+
+	crc := w.crc.Sum32()
+	w.v.mu.Lock()
+	defer w.v.mu.Unlock()
+	if prev, ok := w.v.crcs[w.r]; ok && prev != crc {
+		return fmt.Errorf("%w: CRC32C %08x, previously read with %08x", errMismatch, crc, prev)
+	}
+	w.v.crcs[w.r] = crc
+	return nil
+}
+
+// callback returns callback, with the range checked first: a mismatch is
+// reported as an errMismatch error.
+func (w *rangeWriter) callback(callback func(int64, int64, error)) func(int64, int64, error) {
+	return func(off, length int64, err error) {
+		if err == nil {
+			err = w.check(length)
+		}
+		callback(off, length, err)
+	}
+}
+
+// finish checks the CRC32C of the assembled object if the ranges read cover
+// all of it, and closes the verify file.
+func (v *verifier) finish() error {
+	if v.file != nil {
+		defer v.file.Close()
+	}
+
+	v.mu.Lock()
+	read := len(v.crcs)
+	covered := v.coveredLocked()
+	v.mu.Unlock()
+	if covered < v.attrs.Size {
+		logger.Info("Verified %d ranges covering %d of %d bytes, the object CRC32C is checked once all are read", read, covered, v.attrs.Size)
+		return nil
+	}
+
+	crc := crc32.New(crc32cTable)
+	if _, err := io.Copy(crc, io.NewSectionReader(v.target, 0, v.attrs.Size)); err != nil {
+		return fmt.Errorf("failed to read the assembled object: %w", err)
+	}
+	if got := crc.Sum32(); got != v.attrs.CRC32C {
+		atomic.AddUint64(&totalMismatches, 1)
+		return fmt.Errorf("object CRC32C %08x, want %08x", got, v.attrs.CRC32C)
+	}
+	logger.Info("Verified %d ranges and the object CRC32C %08x", read, v.attrs.CRC32C)
+	return nil
+}
+
+// coveredLocked returns the number of bytes of the object covered by the
+// ranges read. v.mu must be held.
+func (v *verifier) coveredLocked() int64 {
+	ranges := make([]rapid.Range, 0, len(v.crcs))
+	for r := range v.crcs {
+		ranges = append(ranges, r)
+	}
+	slices.SortFunc(ranges, func(a, b rapid.Range) int { return cmp.Compare(a.Offset, b.Offset) })
+
+	var covered, end int64
+	for _, r := range ranges {
+		if r.Offset+r.Length <= end {
+			continue
+		}
+		covered += r.Offset + r.Length - max(r.Offset, end)
+		end = r.Offset + r.Length
+	}
+	return covered
+}
+
+// memoryWriterAt is an object assembled in memory.
+type memoryWriterAt struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+func (m *memoryWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if off < 0 || off+int64(len(p)) > int64(len(m.data)) {
+		return 0, fmt.Errorf("write of %d bytes at offset %d past the object size %d", len(p), off, len(m.data))
+	}
+	return copy(m.data[off:], p), nil
+}
+
+func (m *memoryWriterAt) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
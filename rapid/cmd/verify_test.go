@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/raj-prince/custom-go-client-benchmark/rapid"
+)
+
+func TestVerifier_Covered(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []rapid.Range
+		want   int64
+	}{
+		{name: "no range", want: 0},
+		{name: "single", ranges: []rapid.Range{{Offset: 10, Length: 5}}, want: 5},
+		{name: "adjacent", ranges: []rapid.Range{{Offset: 0, Length: 10}, {Offset: 10, Length: 10}}, want: 20},
+		{name: "gapped", ranges: []rapid.Range{{Offset: 0, Length: 10}, {Offset: 30, Length: 10}}, want: 20},
+		{name: "overlapping", ranges: []rapid.Range{{Offset: 0, Length: 10}, {Offset: 5, Length: 10}}, want: 15},
+		{name: "contained", ranges: []rapid.Range{{Offset: 0, Length: 20}, {Offset: 5, Length: 5}}, want: 20},
+		{name: "same offset", ranges: []rapid.Range{{Offset: 0, Length: 5}, {Offset: 0, Length: 10}}, want: 10},
+		{
+			name:   "mixed",
+			ranges: []rapid.Range{{Offset: 40, Length: 10}, {Offset: 0, Length: 10}, {Offset: 8, Length: 4}, {Offset: 12, Length: 3}, {Offset: 45, Length: 10}},
+			want:   30,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &verifier{crcs: make(map[rapid.Range]uint32)}
+			for _, r := range tt.ranges {
+				v.crcs[r] = 0
+			}
+			if got := v.coveredLocked(); got != tt.want {
+				t.Fatalf("coveredLocked() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryWriterAt(t *testing.T) {
+	m := &memoryWriterAt{data: make([]byte, 8)}
+
+	if n, err := m.WriteAt([]byte("abcd"), 2); n != 4 || err != nil {
+		t.Fatalf("WriteAt() = %d, %v, want 4, nil", n, err)
+	}
+	// A write ending at the object size fits.
+	if n, err := m.WriteAt([]byte("xy"), 6); n != 2 || err != nil {
+		t.Fatalf("WriteAt() at the end = %d, %v, want 2, nil", n, err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		p    []byte
+		off  int64
+	}{
+		{name: "negative offset", p: []byte("a"), off: -1},
+		{name: "past the end", p: []byte("a"), off: 8},
+		{name: "across the end", p: []byte("abc"), off: 6},
+	} {
+		if n, err := m.WriteAt(tt.p, tt.off); n != 0 || err == nil {
+			t.Errorf("WriteAt() %s = %d, %v, want an error", tt.name, n, err)
+		}
+	}
+
+	// The failed writes left the data untouched.
+	p := make([]byte, 8)
+	if n, err := m.ReadAt(p, 0); n != 8 || err != nil {
+		t.Fatalf("ReadAt() = %d, %v, want 8, nil", n, err)
+	}
+	if got, want := string(p), "\x00\x00abcdxy"; got != want {
+		t.Fatalf("data = %q, want %q", got, want)
+	}
+	if n, err := m.ReadAt(p, 4); n != 4 || err != io.EOF {
+		t.Fatalf("ReadAt() across the end = %d, %v, want 4, EOF", n, err)
+	}
+	if n, err := m.ReadAt(p, 8); n != 0 || err != io.EOF {
+		t.Fatalf("ReadAt() at the end = %d, %v, want 0, EOF", n, err)
+	}
+}
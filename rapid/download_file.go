@@ -0,0 +1,406 @@
+package rapid
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/raj-prince/custom-go-client-benchmark/rapid/workerpool"
+)
+
+const (
+	defaultDownloadRangeSize = 4 * 1024 * 1024
+	defaultManifestInterval  = time.Second
+
+	// partSuffix is the suffix of the file an object is downloaded to,
+	// renamed to the destination once complete.
+	partSuffix = ".part"
+	// manifestSuffix is the suffix of the sidecar manifest of a part file.
+	manifestSuffix = ".manifest"
+)
+
+// DownloadOptions configure DownloadToFile.
+type DownloadOptions struct {
+	// RangeSize is the size of the ranges the object is downloaded in, 4 MiB
+	// if 0. A resumed download keeps the range size it started with.
+	RangeSize int64
+
+	// Preallocate reserves the disk space of the whole object before the
+	// download, so that it doesn't fail midway on a full disk and the file
+	// is less fragmented. Otherwise the file is sparse until downloaded.
+	Preallocate bool
+
+	// Resume continues an interrupted download of the same generation of the
+	// object, skipping the ranges recorded in its manifest. Without a usable
+	// manifest, or a part file of the object size, the download starts over.
+	Resume bool
+
+	// ManifestInterval is how often the completed ranges are recorded in the
+	// manifest, 1s if 0. Ranges completed since the last record are
+	// downloaded again on resume.
+	ManifestInterval time.Duration
+}
+
+// DownloadStats are the results of DownloadToFile.
+type DownloadStats struct {
+	Size       int64
+	Generation int64
+	Ranges     int
+	// Resumed is the number of ranges downloaded before, by an interrupted
+	// download, and skipped.
+	Resumed  int
+	Duration time.Duration
+}
+
+// SplitRanges splits an object of size bytes into ranges of rangeSize bytes,
+// the last one being shorter if size isn't a multiple of rangeSize.
+func SplitRanges(size, rangeSize int64) []Range {
+	numRanges := (size + rangeSize - 1) / rangeSize
+	ranges := make([]Range, 0, numRanges)
+	for offset := int64(0); offset < size; offset += rangeSize {
+		ranges = append(ranges, Range{Offset: offset, Length: min(rangeSize, size-offset)})
+	}
+	return ranges
+}
+
+// DownloadToFile downloads the whole object of pool to the file at path, its
+// ranges in parallel on workerPool, which must be started. Every range is
+// written at its offset as it is downloaded.
+//
+// The object is downloaded to path.part, renamed to path once complete, so
+// path is either missing or complete. The ranges written to the part file
+// are recorded in the sidecar manifest path.part.manifest, after the part
+// file is synced, so that an interrupted download can be resumed, see
+// DownloadOptions.Resume. On failure both are left behind for a resume.
+//
+// The first range failing cancels the others: set MRDPoolConfig.MaxAttempts
+// to retry ranges instead.
+func DownloadToFile(ctx context.Context, pool *MRDPool, workerPool workerpool.WorkerPool, path string, opts DownloadOptions) (*DownloadStats, error) {
+	start := time.Now()
+	if opts.RangeSize <= 0 {
+		opts.RangeSize = defaultDownloadRangeSize
+	}
+	if opts.ManifestInterval <= 0 {
+		opts.ManifestInterval = defaultManifestInterval
+	}
+
+	attrs, err := pool.objectAttrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object attributes: %w", err)
+	}
+	header := manifestHeader{
+		Bucket:     pool.cfg.Bucket,
+		Object:     pool.cfg.Object,
+		Generation: attrs.Generation,
+		Size:       attrs.Size,
+		RangeSize:  opts.RangeSize,
+	}
+
+	partPath := path + partSuffix
+	manifestPath := partPath + manifestSuffix
+	var completed map[int]bool
+	if opts.Resume && partFileMatches(partPath, attrs.Size) {
+		completed, header.RangeSize = readManifest(manifestPath, header)
+	}
+
+	flags := os.O_RDWR | os.O_CREATE
+	if completed == nil {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(partPath, flags, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open part file: %w", err)
+	}
+	defer file.Close()
+	if opts.Preallocate {
+		if err := preallocate(file, attrs.Size); err != nil {
+			return nil, fmt.Errorf("failed to preallocate %d bytes: %w", attrs.Size, err)
+		}
+	}
+	if err := file.Truncate(attrs.Size); err != nil {
+		return nil, fmt.Errorf("failed to size part file: %w", err)
+	}
+
+	m, err := createManifest(manifestPath, header, completed, file)
+	if err != nil {
+		return nil, err
+	}
+	defer m.close()
+
+	ranges := SplitRanges(attrs.Size, header.RangeSize)
+	stats := &DownloadStats{
+		Size:       attrs.Size,
+		Generation: attrs.Generation,
+		Ranges:     len(ranges),
+		Resumed:    len(completed),
+	}
+	if err := downloadRanges(ctx, pool, workerPool, file, ranges, completed, m, opts.ManifestInterval); err != nil {
+		return stats, err
+	}
+
+	if err := file.Sync(); err != nil {
+		return stats, fmt.Errorf("failed to sync part file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return stats, fmt.Errorf("failed to close part file: %w", err)
+	}
+	if err := os.Rename(partPath, path); err != nil {
+		return stats, fmt.Errorf("failed to rename part file: %w", err)
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return stats, err
+	}
+	// The download is complete: a leftover manifest is harmless, it is only
+	// resumed with a part file of the object size, see partFileMatches.
+	m.close()
+	if err := os.Remove(manifestPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return stats, fmt.Errorf("failed to remove manifest: %w", err)
+	}
+
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
+
+// downloadRanges downloads the ranges not completed yet to file, on
+// workerPool, recording them in m every interval. It returns the first
+// error, once every scheduled range is reported.
+func downloadRanges(ctx context.Context, pool *MRDPool, workerPool workerpool.WorkerPool, file *os.File, ranges []Range, completed map[int]bool, m *manifest, interval time.Duration) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	stopFlush := make(chan struct{})
+	flushDone := make(chan struct{})
+	go func() {
+		defer close(flushDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.flush(); err != nil {
+					cancel(err)
+				}
+			case <-stopFlush:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		if completed[i] {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		callback := func(offset, n int64, err error) {
+			defer wg.Done()
+			if err == nil && n != r.Length {
+				err = fmt.Errorf("short read of range at offset %d: %d bytes, want %d", offset, n, r.Length)
+			}
+			if err != nil {
+				cancel(err)
+				return
+			}
+			m.complete(i)
+		}
+		if err := workerPool.ScheduleContext(ctx, false, NewDownloadTask(ctx, r, pool, io.NewOffsetWriter(file, r.Offset), callback)); err != nil {
+			wg.Done()
+			cancel(err)
+			break
+		}
+	}
+	wg.Wait()
+	close(stopFlush)
+	<-flushDone
+
+	// Record the ranges downloaded even if others failed, for a resume.
+	flushErr := m.flush()
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return flushErr
+}
+
+// objectAttrs returns the attributes of the generation of the object read by
+// the pool.
+func (p *MRDPool) objectAttrs(ctx context.Context) (*storage.ObjectAttrs, error) {
+	objectHandle := p.cfg.Client.Bucket(p.cfg.Bucket).Object(p.cfg.Object)
+	if generation := p.Generation(); generation > 0 {
+		objectHandle = objectHandle.Generation(generation)
+	}
+	return objectHandle.Attrs(ctx)
+}
+
+// manifestHeader is the first line of a manifest, identifying the download.
+// A manifest is only resumed if its header matches the object, see
+// readManifest.
+type manifestHeader struct {
+	Bucket     string `json:"bucket"`
+	Object     string `json:"object"`
+	Generation int64  `json:"generation"`
+	Size       int64  `json:"size"`
+	RangeSize  int64  `json:"rangeSize"`
+}
+
+// manifest records the ranges written to a part file: a JSON header line,
+// then the index of every range completed, one per line.
+type manifest struct {
+	file *os.File
+	// data is the part file, synced before ranges are recorded.
+	data *os.File
+
+	mu sync.Mutex
+	// pending are the ranges completed but not recorded yet.
+	pending []int
+	closed  bool
+}
+
+// readManifest returns the ranges recorded in the manifest at path, and the
+// range size they were downloaded with, or nil if it is missing or doesn't
+// match header. A partial last line, from a crash while recording, is
+// ignored.
+func readManifest(path string, header manifestHeader) (map[int]bool, int64) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, header.RangeSize
+	}
+	lines := strings.Split(string(content), "\n")
+	// The last line is empty if complete, or else partial.
+	lines = lines[:len(lines)-1]
+	if len(lines) == 0 {
+		return nil, header.RangeSize
+	}
+
+	var got manifestHeader
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil || got.RangeSize <= 0 {
+		return nil, header.RangeSize
+	}
+	// The range size may differ, the ranges are the ones of the manifest.
+	want := header
+	want.RangeSize = got.RangeSize
+	if got != want {
+		return nil, header.RangeSize
+	}
+
+	numRanges := int((got.Size + got.RangeSize - 1) / got.RangeSize)
+	completed := make(map[int]bool)
+	for _, line := range lines[1:] {
+		i, err := strconv.Atoi(line)
+		if err != nil || i < 0 || i >= numRanges {
+			continue
+		}
+		completed[i] = true
+	}
+	return completed, got.RangeSize
+}
+
+// partFileMatches reports whether the part file at path exists with size
+// bytes, so that its manifest can be resumed. Otherwise the manifest is
+// stale, e.g. left by a crash after the part file was renamed, and resuming
+// it would skip ranges never written to a new part file.
+func partFileMatches(path string, size int64) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Size() == size
+}
+
+// createManifest writes the manifest of a download at path, with the ranges
+// already completed, and returns it for recording the next ones.
+func createManifest(path string, header manifestHeader, completed map[int]bool, data *os.File) (*manifest, error) {
+	// Written from scratch, the manifest drops the partial lines and
+	// duplicates of the previous one.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest: %w", err)
+	}
+	m := &manifest{file: file, data: data}
+
+	line, err := json.Marshal(header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	w.Write(append(line, '\n'))
+	for i := range completed {
+		fmt.Fprintf(w, "%d\n", i)
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to sync manifest: %w", err)
+	}
+	return m, nil
+}
+
+// complete adds the range of index i to the ones to record.
+func (m *manifest) complete(i int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(m.pending, i)
+}
+
+// flush records the pending ranges, once the part file is synced so that
+// their data is on disk first.
+func (m *manifest) flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed || len(m.pending) == 0 {
+		return nil
+	}
+
+	if err := m.data.Sync(); err != nil {
+		return fmt.Errorf("failed to sync part file: %w", err)
+	}
+	w := bufio.NewWriter(m.file)
+	for _, i := range m.pending {
+		fmt.Fprintf(w, "%d\n", i)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := m.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync manifest: %w", err)
+	}
+	m.pending = m.pending[:0]
+	return nil
+}
+
+// close closes the manifest file, it can be called more than once.
+func (m *manifest) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.closed = true
+		m.file.Close()
+	}
+}
+
+// syncDir syncs the directory at path, so that a rename in it is durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
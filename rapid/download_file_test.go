@@ -0,0 +1,250 @@
+package rapid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/raj-prince/custom-go-client-benchmark/fakegcs"
+	"github.com/raj-prince/custom-go-client-benchmark/rapid/workerpool"
+)
+
+const downloadRangeSize = 1024
+
+// newDownloadPool returns a pool reading an object of 10 ranges and a half
+// stored in a fake GCS with opts, its data and generation, and a started
+// worker pool.
+func newDownloadPool(t *testing.T, opts fakegcs.Options) (*MRDPool, workerpool.WorkerPool, []byte, int64) {
+	t.Helper()
+	server, err := fakegcs.NewServer(opts)
+	if err != nil {
+		t.Fatalf("fakegcs.NewServer() error = %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	data := make([]byte, 10*downloadRangeSize+downloadRangeSize/2)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	generation := server.PutObject("test-bucket", "test-object", data)
+
+	pool, err := NewMRDPool(&MRDPoolConfig{
+		PoolSize: 2,
+		Endpoint: server.GRPCEndpoint(),
+		Bucket:   "test-bucket",
+		Object:   "test-object",
+	})
+	if err != nil {
+		t.Fatalf("NewMRDPool() error = %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	workerPool, err := workerpool.NewStaticWorkerPool(1, 4, 1000)
+	if err != nil {
+		t.Fatalf("NewStaticWorkerPool() error = %v", err)
+	}
+	workerPool.Start()
+	t.Cleanup(workerPool.Stop)
+	return pool, workerPool, data, generation
+}
+
+// writeManifest writes the manifest of an interrupted download of generation
+// with the ranges of completed done.
+func writeManifest(t *testing.T, path string, generation int64, size int64, completed ...int) {
+	t.Helper()
+	header, err := json.Marshal(manifestHeader{
+		Bucket:     "test-bucket",
+		Object:     "test-object",
+		Generation: generation,
+		Size:       size,
+		RangeSize:  downloadRangeSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	buf.Write(append(header, '\n'))
+	for _, i := range completed {
+		fmt.Fprintf(&buf, "%d\n", i)
+	}
+	// A partial line, as left by a crash while recording a range.
+	buf.WriteString("1")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitRanges(t *testing.T) {
+	ranges := SplitRanges(2500, 1000)
+	want := []Range{{0, 1000}, {1000, 1000}, {2000, 500}}
+	if len(ranges) != len(want) {
+		t.Fatalf("SplitRanges() = %v, want %v", ranges, want)
+	}
+	for i := range want {
+		if ranges[i] != want[i] {
+			t.Fatalf("SplitRanges() = %v, want %v", ranges, want)
+		}
+	}
+	if got := SplitRanges(0, 1000); len(got) != 0 {
+		t.Fatalf("SplitRanges() of an empty object = %v, want none", got)
+	}
+}
+
+func TestDownloadToFile(t *testing.T) {
+	pool, workerPool, data, generation := newDownloadPool(t, fakegcs.Options{})
+	path := filepath.Join(t.TempDir(), "object")
+
+	stats, err := DownloadToFile(context.Background(), pool, workerPool, path, DownloadOptions{
+		RangeSize:   downloadRangeSize,
+		Preallocate: true,
+	})
+	if err != nil {
+		t.Fatalf("DownloadToFile() error = %v", err)
+	}
+	if stats.Ranges != 11 || stats.Resumed != 0 || stats.Size != int64(len(data)) || stats.Generation != generation {
+		t.Fatalf("DownloadToFile() stats = %+v, want 11 ranges of generation %d", stats, generation)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded file differs from the object")
+	}
+	// The part file is renamed and the manifest removed.
+	for _, leftover := range []string{path + partSuffix, path + partSuffix + manifestSuffix} {
+		if _, err := os.Stat(leftover); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Stat(%s) error = %v, want it removed", leftover, err)
+		}
+	}
+}
+
+func TestDownloadToFile_Resume(t *testing.T) {
+	pool, workerPool, data, generation := newDownloadPool(t, fakegcs.Options{})
+	path := filepath.Join(t.TempDir(), "object")
+
+	// Ranges 0 and 3 were downloaded before the crash. They are marked in
+	// the part file to check they are not downloaded again.
+	part := make([]byte, len(data))
+	copy(part, data)
+	for _, i := range []int{0, 3} {
+		copy(part[i*downloadRangeSize:], bytes.Repeat([]byte{0xaa}, downloadRangeSize))
+	}
+	if err := os.WriteFile(path+partSuffix, part, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeManifest(t, path+partSuffix+manifestSuffix, generation, int64(len(data)), 0, 3)
+
+	stats, err := DownloadToFile(context.Background(), pool, workerPool, path, DownloadOptions{
+		RangeSize: downloadRangeSize,
+		Resume:    true,
+	})
+	if err != nil {
+		t.Fatalf("DownloadToFile() error = %v", err)
+	}
+	if stats.Resumed != 2 {
+		t.Fatalf("DownloadToFile() resumed %d ranges, want 2", stats.Resumed)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, part) {
+		t.Fatal("resumed download didn't skip the completed ranges")
+	}
+}
+
+func TestDownloadToFile_ResumeOtherGeneration(t *testing.T) {
+	pool, workerPool, data, generation := newDownloadPool(t, fakegcs.Options{})
+	path := filepath.Join(t.TempDir(), "object")
+
+	// The manifest is of an older generation: the download starts over.
+	if err := os.WriteFile(path+partSuffix, make([]byte, len(data)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeManifest(t, path+partSuffix+manifestSuffix, generation-1, int64(len(data)), 0, 1, 2)
+
+	stats, err := DownloadToFile(context.Background(), pool, workerPool, path, DownloadOptions{
+		RangeSize: downloadRangeSize,
+		Resume:    true,
+	})
+	if err != nil {
+		t.Fatalf("DownloadToFile() error = %v", err)
+	}
+	if stats.Resumed != 0 {
+		t.Fatalf("DownloadToFile() resumed %d ranges, want 0", stats.Resumed)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded file differs from the object")
+	}
+}
+
+func TestDownloadToFile_ResumeMissingPartFile(t *testing.T) {
+	pool, workerPool, data, generation := newDownloadPool(t, fakegcs.Options{})
+	path := filepath.Join(t.TempDir(), "object")
+
+	// A crash after the part file was renamed left its manifest, recording
+	// every range: it must not be resumed without the part file.
+	all := make([]int, 11)
+	for i := range all {
+		all[i] = i
+	}
+	writeManifest(t, path+partSuffix+manifestSuffix, generation, int64(len(data)), all...)
+
+	stats, err := DownloadToFile(context.Background(), pool, workerPool, path, DownloadOptions{
+		RangeSize: downloadRangeSize,
+		Resume:    true,
+	})
+	if err != nil {
+		t.Fatalf("DownloadToFile() error = %v", err)
+	}
+	if stats.Resumed != 0 {
+		t.Fatalf("DownloadToFile() resumed %d ranges, want 0", stats.Resumed)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded file differs from the object")
+	}
+}
+
+func TestDownloadToFile_Canceled(t *testing.T) {
+	// Ranges take 500ms to download, they are canceled midway.
+	pool, workerPool, data, generation := newDownloadPool(t, fakegcs.Options{BytesPerSecond: 2 * downloadRangeSize})
+	path := filepath.Join(t.TempDir(), "object")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := DownloadToFile(ctx, pool, workerPool, path, DownloadOptions{RangeSize: downloadRangeSize}); err == nil {
+		t.Fatal("DownloadToFile() of a canceled context should fail")
+	}
+
+	// The destination is only created once complete, the part file and its
+	// manifest are kept for a resume.
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat(%s) error = %v, want it missing", path, err)
+	}
+	completed, rangeSize := readManifest(path+partSuffix+manifestSuffix, manifestHeader{
+		Bucket:     "test-bucket",
+		Object:     "test-object",
+		Generation: generation,
+		Size:       int64(len(data)),
+		RangeSize:  downloadRangeSize,
+	})
+	if completed == nil || rangeSize != downloadRangeSize {
+		t.Fatal("manifest of the canceled download can't be resumed")
+	}
+}
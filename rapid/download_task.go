@@ -1,15 +1,12 @@
 package rapid
 
 import (
+	"context"
 	"io"
-	"log"
+	"sync/atomic"
+	"time"
 )
 
-type readResult struct {
-	bytesRead int
-	err       error
-}
-
 // Range represents a contiguous range of data to be downloaded.
 type Range struct {
 	Offset int64 // Starting byte offset in the object
@@ -17,17 +14,25 @@ type Range struct {
 }
 
 // DownloadTask represents a task that downloads a range using an MRD pool
-// and writes the result to an io.Writer. It implements the workerpool.Task interface.
+// and writes the result to an io.Writer. It implements the workerpool.Task
+// and workerpool.ErrorTask interfaces.
 type DownloadTask struct {
+	// ctx is the context of the download, the Task interface has none.
+	ctx           context.Context
+	timeout       time.Duration
 	downloadRange Range
 	pool          *MRDPool
 	writer        io.Writer
 	callback      func(int64, int64, error)
+	// reported is set once the callback is called, see report.
+	reported atomic.Bool
 }
 
 // NewDownloadTask creates a new download task that can be scheduled to a worker pool.
-func NewDownloadTask(downloadRange Range, pool *MRDPool, writer io.Writer, callback func(int64, int64, error)) *DownloadTask {
+// The download is canceled when ctx is done, see MRDPool.Add.
+func NewDownloadTask(ctx context.Context, downloadRange Range, pool *MRDPool, writer io.Writer, callback func(int64, int64, error)) *DownloadTask {
 	return &DownloadTask{
+		ctx:           ctx,
 		downloadRange: downloadRange,
 		pool:          pool,
 		writer:        writer,
@@ -35,21 +40,57 @@ func NewDownloadTask(downloadRange Range, pool *MRDPool, writer io.Writer, callb
 	}
 }
 
-// Execute implements the workerpool.Task interface.
-// It schedules the download of the range using the MRD pool.
+// WithTimeout sets a deadline of timeout on the download, from the start of
+// Execute, and returns dt. A timeout of 0 sets no deadline.
+func (dt *DownloadTask) WithTimeout(timeout time.Duration) *DownloadTask {
+	dt.timeout = timeout
+	return dt
+}
+
+// Execute implements the workerpool.Task interface, see Run. The error of
+// the download is only reported to the callback.
 func (dt *DownloadTask) Execute() {
-	done := make(chan readResult, 1)
-	defer close(done)
+	_ = dt.Run()
+}
+
+// Run implements the workerpool.ErrorTask interface.
+// It schedules the download of the range using the MRD pool, and waits for
+// it to complete or be canceled. The callback is called once either way, and
+// Run returns the error it was called with.
+func (dt *DownloadTask) Run() error {
+	ctx := dt.ctx
+	if dt.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dt.timeout)
+		defer cancel()
+	}
 
-	err := dt.pool.Add(dt.writer, dt.downloadRange.Offset, dt.downloadRange.Length,
+	done := make(chan struct{})
+	var downloadErr error
+	err := dt.pool.Add(ctx, dt.writer, dt.downloadRange.Offset, dt.downloadRange.Length,
 		func(off, len int64, err error) {
-			done <- readResult{int(len), err}
-			if dt.callback != nil {
-				dt.callback(off, int64(len), err)
-			}
+			downloadErr = err
+			dt.report(off, len, err)
+			close(done)
 		})
 	if err != nil {
-		log.Printf("Failed to add download task to MRD pool: %v\n", err)
+		dt.report(dt.downloadRange.Offset, 0, err)
+		return err
 	}
 	<-done // Ensure we wait for completion
+	return downloadErr
+}
+
+// Discard fails the task without downloading its range, calling the callback
+// with err, e.g. for a task a worker pool discarded instead of executing it,
+// or one that panicked. The callback isn't called again if it already was.
+func (dt *DownloadTask) Discard(err error) {
+	dt.report(dt.downloadRange.Offset, 0, err)
+}
+
+// report calls the callback, unless it was already called.
+func (dt *DownloadTask) report(off, n int64, err error) {
+	if dt.callback != nil && dt.reported.CompareAndSwap(false, true) {
+		dt.callback(off, n, err)
+	}
 }
@@ -2,7 +2,10 @@ package rapid
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -14,7 +17,7 @@ func TestNewDownloadTask(t *testing.T) {
 	writer := &bytes.Buffer{}
 	callback := func(offset, length int64, err error) {}
 
-	task := NewDownloadTask(downloadRange, pool, writer, callback)
+	task := NewDownloadTask(context.Background(), downloadRange, pool, writer, callback)
 
 	require.NotNil(t, task)
 	assert.Equal(t, downloadRange, task.downloadRange)
@@ -34,7 +37,7 @@ func TestDownloadTask_Execute_Success(t *testing.T) {
 		// Callback for structure test
 	}
 
-	task := NewDownloadTask(downloadRange, pool, writer, callback)
+	task := NewDownloadTask(context.Background(), downloadRange, pool, writer, callback)
 
 	// Verify task is created properly
 	require.NotNil(t, task)
@@ -49,7 +52,7 @@ func TestDownloadTask_Execute_NilCallback(t *testing.T) {
 	writer := &bytes.Buffer{}
 
 	// Create task without callback - should not panic
-	task := NewDownloadTask(downloadRange, pool, writer, nil)
+	task := NewDownloadTask(context.Background(), downloadRange, pool, writer, nil)
 
 	require.NotNil(t, task)
 	assert.Nil(t, task.callback)
@@ -64,3 +67,76 @@ func TestRange_Fields(t *testing.T) {
 	assert.Equal(t, int64(12345), downloadRange.Offset)
 	assert.Equal(t, int64(67890), downloadRange.Length)
 }
+
+func TestDownloadTask_Execute_Timeout(t *testing.T) {
+	// The mock downloader never completes the range, as Wait is not called.
+	pool, _ := newMockPool(1, SelectRoundRobin)
+
+	var gotErr error
+	task := NewDownloadTask(context.Background(), Range{Offset: 0, Length: 1024}, pool, &bytes.Buffer{}, func(_, _ int64, err error) {
+		gotErr = err
+	}).WithTimeout(20 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		task.Execute()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Execute() did not return after the timeout")
+	}
+	assert.ErrorIs(t, gotErr, ErrRangeCanceled)
+	assert.ErrorIs(t, gotErr, context.DeadlineExceeded)
+}
+
+func TestDownloadTask_Execute_AddError(t *testing.T) {
+	pool, _ := newMockPool(1, SelectRoundRobin)
+	require.NoError(t, pool.Close())
+
+	var gotErr error
+	task := NewDownloadTask(context.Background(), Range{Offset: 0, Length: 1024}, pool, &bytes.Buffer{}, func(_, _ int64, err error) {
+		gotErr = err
+	})
+
+	// Execute returns instead of waiting for a range that was never added.
+	task.Execute()
+	assert.ErrorContains(t, gotErr, "pool is closed")
+}
+
+func TestDownloadTask_Run_AddError(t *testing.T) {
+	pool, _ := newMockPool(1, SelectRoundRobin)
+	require.NoError(t, pool.Close())
+
+	calls := 0
+	var gotErr error
+	task := NewDownloadTask(context.Background(), Range{Offset: 0, Length: 1024}, pool, &bytes.Buffer{}, func(_, _ int64, err error) {
+		calls++
+		gotErr = err
+	})
+
+	// Run returns the error the callback is called with, once.
+	err := task.Run()
+	assert.ErrorContains(t, err, "pool is closed")
+	assert.Equal(t, err, gotErr)
+	task.Discard(errors.New("discarded"))
+	assert.Equal(t, 1, calls, "Callback should only be called once.")
+}
+
+func TestDownloadTask_Discard(t *testing.T) {
+	var gotOffset, gotLength int64 = -1, -1
+	var gotErr error
+	task := NewDownloadTask(context.Background(), Range{Offset: 1024, Length: 2048}, &MRDPool{}, &bytes.Buffer{}, func(off, length int64, err error) {
+		gotOffset, gotLength, gotErr = off, length, err
+	})
+
+	discardErr := errors.New("discarded")
+	task.Discard(discardErr)
+	assert.Equal(t, int64(1024), gotOffset)
+	assert.Equal(t, int64(0), gotLength)
+	assert.ErrorIs(t, gotErr, discardErr)
+
+	// Without a callback, nothing is reported.
+	NewDownloadTask(context.Background(), Range{Offset: 0, Length: 1}, &MRDPool{}, &bytes.Buffer{}, nil).Discard(discardErr)
+}
@@ -0,0 +1,185 @@
+package rapid
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// defaultScaleUpInFlight is the default MRDPoolConfig.ScaleUpInFlight.
+	defaultScaleUpInFlight = 4
+	// maxScaleEvents is the number of latest scale events kept by the pool.
+	maxScaleEvents = 100
+)
+
+// ScaleEvent is a downloader opened or closed by an elastic pool.
+type ScaleEvent struct {
+	Time time.Time
+	// Index is the slot of the downloader, -1 if it failed to open.
+	Index int
+	// Opened is true when the downloader was opened, false when closed.
+	Opened bool
+	// PoolSize is the number of open downloaders after the event.
+	PoolSize int
+	Reason   string
+}
+
+// validateElastic checks the elastic settings of config.
+func validateElastic(config *MRDPoolConfig) error {
+	if config.MaxPoolSize < 0 || (config.MaxPoolSize > 0 && config.MaxPoolSize < config.PoolSize) {
+		return fmt.Errorf("max pool size must be 0 or at least the pool size")
+	}
+	if config.MinPoolSize < 0 || config.MinPoolSize > config.PoolSize {
+		return fmt.Errorf("min pool size must be between 0 and the pool size")
+	}
+	if config.ScaleUpInFlight < 0 {
+		return fmt.Errorf("scale up in-flight threshold cannot be negative")
+	}
+	if config.IdleTimeout < 0 {
+		return fmt.Errorf("idle timeout cannot be negative")
+	}
+	return nil
+}
+
+// elastic returns whether the pool opens and closes downloaders with demand.
+func (p *MRDPool) elastic() bool {
+	return p.cfg != nil && p.cfg.MaxPoolSize > 0
+}
+
+func (p *MRDPool) minPoolSize() int {
+	if p.cfg.MinPoolSize > 0 {
+		return p.cfg.MinPoolSize
+	}
+	return 1
+}
+
+func (p *MRDPool) scaleUpInFlight() int {
+	if p.cfg.ScaleUpInFlight > 0 {
+		return p.cfg.ScaleUpInFlight
+	}
+	return defaultScaleUpInFlight
+}
+
+// startScaling starts closing the idle downloaders of an elastic pool, until
+// Close.
+func (p *MRDPool) startScaling() {
+	if !p.elastic() || p.cfg.IdleTimeout <= 0 {
+		return
+	}
+
+	p.stopScaling = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(max(p.cfg.IdleTimeout/4, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stopScaling:
+				return
+			case now := <-ticker.C:
+				p.closeIdle(now)
+			}
+		}
+	}()
+}
+
+// maybeScaleUp opens a new downloader in the background when the open ones
+// have more than ScaleUpInFlight ranges in flight on average. It must be
+// called with p.mu held.
+func (p *MRDPool) maybeScaleUp() {
+	if !p.elastic() || len(p.active) >= p.cfg.MaxPoolSize {
+		return
+	}
+
+	var inFlight int64
+	for _, index := range p.active {
+		inFlight += p.loads[index].inFlight.Load()
+	}
+	if inFlight <= int64(p.scaleUpInFlight()*len(p.active)) {
+		return
+	}
+	// One downloader is opened at a time.
+	if !p.scaling.CompareAndSwap(false, true) {
+		return
+	}
+	reason := fmt.Sprintf("%d ranges in flight on %d downloaders", inFlight, len(p.active))
+	go p.scaleUp(reason)
+}
+
+// scaleUp opens a new downloader in a free slot.
+func (p *MRDPool) scaleUp(reason string) {
+	defer p.scaling.Store(false)
+
+	downloader, err := p.openDownloader(context.Background())
+
+	p.mu.Lock()
+	if err != nil {
+		p.recordEvent(ScaleEvent{Time: time.Now(), Index: -1, Opened: false, PoolSize: p.poolSize, Reason: fmt.Sprintf("failed to open a downloader: %v", err)})
+		p.mu.Unlock()
+		return
+	}
+	if p.closed || len(p.active) >= p.cfg.MaxPoolSize {
+		p.mu.Unlock()
+		_ = downloader.Close()
+		return
+	}
+
+	index := 0
+	for p.downloaders[index] != nil {
+		index++
+	}
+	p.downloaders[index] = downloader
+	p.loads[index] = newDownloaderLoad(time.Now())
+	p.active = append(p.active, index)
+	sort.Ints(p.active)
+	p.poolSize++
+	p.scaleUps.Add(1)
+	p.recordEvent(ScaleEvent{Time: time.Now(), Index: index, Opened: true, PoolSize: p.poolSize, Reason: reason})
+	p.mu.Unlock()
+}
+
+// closeIdle closes the downloaders with no range in flight and none added
+// for the idle timeout, down to the minimum pool size, and returns how many
+// were closed.
+func (p *MRDPool) closeIdle(now time.Time) int {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return 0
+	}
+
+	var closing []MultiRangeDownloader
+	// The highest slots are closed first.
+	for i := len(p.active) - 1; i >= 0 && len(p.active) > p.minPoolSize(); i-- {
+		index := p.active[i]
+		load := p.loads[index]
+		idle := now.Sub(time.Unix(0, load.lastUsed.Load()))
+		if load.inFlight.Load() > 0 || idle < p.cfg.IdleTimeout {
+			continue
+		}
+
+		closing = append(closing, p.downloaders[index])
+		p.downloaders[index] = nil
+		p.active = append(p.active[:i], p.active[i+1:]...)
+		p.poolSize--
+		p.scaleDowns.Add(1)
+		p.recordEvent(ScaleEvent{Time: now, Index: index, Opened: false, PoolSize: p.poolSize, Reason: fmt.Sprintf("idle for %v", idle.Round(time.Millisecond))})
+	}
+	p.mu.Unlock()
+
+	for _, downloader := range closing {
+		// Best effort, as when recreating a downloader.
+		_ = downloader.Close()
+	}
+	return len(closing)
+}
+
+// recordEvent keeps e in the latest scale events. It must be called with
+// p.mu held for writing.
+func (p *MRDPool) recordEvent(e ScaleEvent) {
+	if len(p.scaleEvents) == maxScaleEvents {
+		p.scaleEvents = append(p.scaleEvents[:0], p.scaleEvents[1:]...)
+	}
+	p.scaleEvents = append(p.scaleEvents, e)
+}
@@ -0,0 +1,199 @@
+package rapid
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// mockFactory opens mock downloaders and remembers them.
+type mockFactory struct {
+	mu    sync.Mutex
+	mocks []*mockMultiRangeDownloader
+}
+
+func (f *mockFactory) open(context.Context, int64, []byte) (MultiRangeDownloader, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := &mockMultiRangeDownloader{id: len(f.mocks)}
+	f.mocks = append(f.mocks, m)
+	return m, 0, nil
+}
+
+// newElasticMockPool returns a pool as NewMRDPool would, with mock
+// downloaders.
+func newElasticMockPool(t *testing.T, cfg MRDPoolConfig) (*MRDPool, *mockFactory) {
+	t.Helper()
+	if err := validateElastic(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	f := &mockFactory{}
+	slots := max(cfg.PoolSize, cfg.MaxPoolSize)
+	pool := &MRDPool{
+		downloaders:       make([]MultiRangeDownloader, slots),
+		poolSize:          cfg.PoolSize,
+		cfg:               &cfg,
+		downloaderMutexes: make([]sync.Mutex, slots),
+		newDownloader:     f.open,
+	}
+	for i := 0; i < cfg.PoolSize; i++ {
+		pool.downloaders[i], _, _ = f.open(context.Background(), 0, nil)
+	}
+	pool.initSlots()
+	pool.startScaling()
+	t.Cleanup(func() { pool.Close() })
+	return pool, f
+}
+
+// waitForPoolSize waits until the pool has want open downloaders.
+func waitForPoolSize(t *testing.T, pool *MRDPool, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.PoolSize() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool size: got %d, want %d", pool.PoolSize(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestValidateElastic(t *testing.T) {
+	tests := []struct {
+		name   string
+		config MRDPoolConfig
+	}{
+		{name: "max below pool size", config: MRDPoolConfig{PoolSize: 4, MaxPoolSize: 2}},
+		{name: "min above pool size", config: MRDPoolConfig{PoolSize: 2, MaxPoolSize: 4, MinPoolSize: 3}},
+		{name: "negative threshold", config: MRDPoolConfig{PoolSize: 2, MaxPoolSize: 4, ScaleUpInFlight: -1}},
+		{name: "negative idle timeout", config: MRDPoolConfig{PoolSize: 2, MaxPoolSize: 4, IdleTimeout: -time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.config
+			cfg.Client = &storage.Client{}
+			cfg.Bucket, cfg.Object = "b", "o"
+			if _, err := NewMRDPool(&cfg); err == nil {
+				t.Fatal("NewMRDPool() should fail")
+			}
+		})
+	}
+}
+
+func TestMRDPool_ScaleUp(t *testing.T) {
+	pool, f := newElasticMockPool(t, MRDPoolConfig{PoolSize: 1, MaxPoolSize: 3, ScaleUpInFlight: 2})
+
+	var buf bytes.Buffer
+	// 3 ranges in flight on 1 downloader pass the threshold of 2.
+	for i := 0; i < 3; i++ {
+		if err := pool.Add(context.Background(), &buf, 0, 1024, nil); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	waitForPoolSize(t, pool, 2)
+
+	// The pool doesn't grow past MaxPoolSize.
+	for i := 0; i < 100; i++ {
+		pool.Add(context.Background(), &buf, 0, 1024, nil)
+		time.Sleep(100 * time.Microsecond)
+	}
+	waitForPoolSize(t, pool, 3)
+	time.Sleep(10 * time.Millisecond)
+	if got := pool.PoolSize(); got != 3 {
+		t.Fatalf("pool size: got %d, want it capped at 3", got)
+	}
+
+	stats := pool.GetStats()
+	if stats.ScaleUps != 2 || stats.ScaleDowns != 0 || len(stats.ScaleEvents) != 2 {
+		t.Fatalf("unexpected scaling stats: %+v", stats)
+	}
+	for i, e := range stats.ScaleEvents {
+		if !e.Opened || e.Index != i+1 || e.PoolSize != i+2 || e.Reason == "" {
+			t.Errorf("scale event %d: %+v", i, e)
+		}
+	}
+	if len(stats.Downloaders) != 3 {
+		t.Fatalf("got %d downloader stats, want 3", len(stats.Downloaders))
+	}
+
+	// Every range completes on Wait, wherever it was added.
+	f.mu.Lock()
+	opened := len(f.mocks)
+	f.mu.Unlock()
+	if opened != 3 {
+		t.Fatalf("opened %d downloaders, want 3", opened)
+	}
+	pool.Wait()
+	for _, d := range pool.GetStats().Downloaders {
+		if d.InFlight != 0 {
+			t.Errorf("downloader %d has %d ranges in flight after Wait", d.Index, d.InFlight)
+		}
+	}
+}
+
+func TestMRDPool_CloseIdle(t *testing.T) {
+	pool, f := newElasticMockPool(t, MRDPoolConfig{PoolSize: 3, MaxPoolSize: 3, MinPoolSize: 1, IdleTimeout: time.Hour})
+
+	// Downloader 1 has a range in flight, so it is not idle.
+	pool.loads[1].start(1024)
+
+	if n := pool.closeIdle(time.Now()); n != 0 {
+		t.Fatalf("closeIdle() before the idle timeout closed %d downloaders", n)
+	}
+	if n := pool.closeIdle(time.Now().Add(2 * time.Hour)); n != 2 {
+		t.Fatalf("closeIdle() closed %d downloaders, want 2", n)
+	}
+	if pool.PoolSize() != 1 || !f.mocks[0].closed || f.mocks[1].closed || !f.mocks[2].closed {
+		t.Fatalf("pool size %d, closed: %v %v %v, want only downloader 1 open",
+			pool.PoolSize(), f.mocks[0].closed, f.mocks[1].closed, f.mocks[2].closed)
+	}
+
+	// The pool doesn't shrink below MinPoolSize.
+	pool.loads[1].cancel(1024)
+	if n := pool.closeIdle(time.Now().Add(2 * time.Hour)); n != 0 {
+		t.Fatalf("closeIdle() closed %d downloaders below MinPoolSize", n)
+	}
+
+	// New ranges go to the remaining downloader.
+	var buf bytes.Buffer
+	for i := 0; i < 3; i++ {
+		if err := pool.Add(context.Background(), &buf, 0, 1024, nil); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if got := f.mocks[1].pending(); got != 3 {
+		t.Fatalf("downloader 1 got %d ranges, want 3", got)
+	}
+
+	stats := pool.GetStats()
+	if stats.ScaleDowns != 2 || len(stats.ScaleEvents) != 2 || stats.ScaleEvents[0].Opened || stats.ScaleEvents[1].PoolSize != 1 {
+		t.Fatalf("unexpected scaling stats: %+v", stats)
+	}
+	if len(stats.Downloaders) != 1 || stats.Downloaders[0].Index != 1 {
+		t.Fatalf("downloader stats: got %+v, want only downloader 1", stats.Downloaders)
+	}
+	if string(pool.GetHandle()) != "handle-1" {
+		t.Fatalf("GetHandle() = %q, want handle-1", pool.GetHandle())
+	}
+}
+
+func TestMRDPool_IdleTimeout(t *testing.T) {
+	pool, _ := newElasticMockPool(t, MRDPoolConfig{PoolSize: 3, MaxPoolSize: 4, MinPoolSize: 2, IdleTimeout: 20 * time.Millisecond})
+
+	// The background scaler closes the idle downloaders down to MinPoolSize.
+	waitForPoolSize(t, pool, 2)
+	time.Sleep(50 * time.Millisecond)
+	if got := pool.PoolSize(); got != 2 {
+		t.Fatalf("pool size: got %d, want it to stay at MinPoolSize 2", got)
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if n := pool.closeIdle(time.Now().Add(time.Hour)); n != 0 {
+		t.Fatalf("closeIdle() after Close closed %d downloaders", n)
+	}
+}
@@ -0,0 +1,88 @@
+package rapid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrGenerationNotFound is returned when a downloader can't be opened because
+// the generation the pool is pinned to no longer exists, e.g. the object was
+// overwritten or deleted during the benchmark.
+var ErrGenerationNotFound = errors.New("pinned object generation not found")
+
+// openDownloader opens a new downloader on the object of the pool. All the
+// downloaders of a pool read the same generation: the one of the config, or
+// else the one read by the first downloader. The read handle of the latest
+// downloader is reused, to skip the object metadata lookup.
+func (p *MRDPool) openDownloader(ctx context.Context) (MultiRangeDownloader, error) {
+	p.mu.RLock()
+	generation, handle := p.generation, p.readHandle
+	p.mu.RUnlock()
+
+	newDownloader := p.newDownloader
+	if newDownloader == nil {
+		newDownloader = p.newObjectDownloader
+	}
+	downloader, read, err := newDownloader(ctx, generation, handle)
+	if err != nil && handle != nil && !isNotFound(err) {
+		// The handle may have expired, open the object again without it.
+		downloader, read, err = newDownloader(ctx, generation, nil)
+	}
+	if isNotFound(err) && generation > 0 {
+		return nil, fmt.Errorf("%w: generation %d of gs://%s/%s: %w", ErrGenerationNotFound, generation, p.cfg.Bucket, p.cfg.Object, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.generation == 0 {
+		p.generation = read
+	} else if read != 0 && read != p.generation {
+		// The server ignored the pinned generation: don't let the pool read
+		// different versions of the object.
+		_ = downloader.Close()
+		return nil, fmt.Errorf("downloader reads generation %d of gs://%s/%s, want %d", read, p.cfg.Bucket, p.cfg.Object, p.generation)
+	}
+	if h := downloader.GetHandle(); len(h) > 0 {
+		p.readHandle = h
+	}
+	return downloader, nil
+}
+
+// isNotFound reports whether err is a missing object error, as returned by
+// the object metadata lookup or by the bidi read stream.
+func isNotFound(err error) bool {
+	return errors.Is(err, storage.ErrObjectNotExist) || status.Code(err) == codes.NotFound
+}
+
+// newObjectDownloader opens a MultiRangeDownloader of generation of the
+// object, the latest one if 0, with the read handle if set.
+func (p *MRDPool) newObjectDownloader(ctx context.Context, generation int64, handle []byte) (MultiRangeDownloader, int64, error) {
+	objectHandle := p.cfg.Client.Bucket(p.cfg.Bucket).Object(p.cfg.Object)
+	if generation > 0 {
+		objectHandle = objectHandle.Generation(generation)
+	}
+	if len(handle) > 0 {
+		objectHandle = objectHandle.ReadHandle(handle)
+	}
+	mrd, err := objectHandle.NewMultiRangeDownloader(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return mrd, mrd.Attrs.Generation, nil
+}
+
+// Generation returns the generation of the object read by the pool, 0 until
+// a downloader is opened.
+func (p *MRDPool) Generation() int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.generation
+}
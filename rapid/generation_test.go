@@ -0,0 +1,176 @@
+package rapid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/raj-prince/custom-go-client-benchmark/fakegcs"
+)
+
+// versionedFactory opens mock downloaders of a versioned object, and
+// remembers the generation and handle each was opened with.
+type versionedFactory struct {
+	mu sync.Mutex
+	// latest is the generation of the object, older ones don't exist.
+	latest int64
+	// rejectHandles fails the opens reusing a read handle.
+	rejectHandles bool
+	// ignoreGeneration reads the latest generation even if another one is
+	// requested.
+	ignoreGeneration bool
+	generations      []int64
+	handles          []string
+}
+
+func (f *versionedFactory) open(_ context.Context, generation int64, handle []byte) (MultiRangeDownloader, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.generations = append(f.generations, generation)
+	f.handles = append(f.handles, string(handle))
+
+	if f.rejectHandles && handle != nil {
+		return nil, 0, errors.New("invalid read handle")
+	}
+	if generation > 0 && generation != f.latest && !f.ignoreGeneration {
+		return nil, 0, storage.ErrObjectNotExist
+	}
+	return &mockMultiRangeDownloader{id: len(f.handles) - 1}, f.latest, nil
+}
+
+func newVersionedPool(f *versionedFactory, generation int64) *MRDPool {
+	return &MRDPool{
+		cfg:           &MRDPoolConfig{Bucket: "b", Object: "o", Generation: generation},
+		generation:    generation,
+		newDownloader: f.open,
+	}
+}
+
+func TestMRDPool_PinGeneration(t *testing.T) {
+	f := &versionedFactory{latest: 5}
+	pool := newVersionedPool(f, 0)
+
+	for i := 0; i < 3; i++ {
+		if _, err := pool.openDownloader(context.Background()); err != nil {
+			t.Fatalf("openDownloader() error = %v", err)
+		}
+	}
+	if got := pool.Generation(); got != 5 {
+		t.Fatalf("Generation() = %d, want 5", got)
+	}
+
+	// The first downloader reads the latest generation, the next ones the
+	// same generation, reusing the read handle of the previous one.
+	wantGenerations := []int64{0, 5, 5}
+	wantHandles := []string{"", "handle-0", "handle-1"}
+	for i := range wantGenerations {
+		if f.generations[i] != wantGenerations[i] || f.handles[i] != wantHandles[i] {
+			t.Errorf("open %d: generation %d, handle %q, want %d, %q", i, f.generations[i], f.handles[i], wantGenerations[i], wantHandles[i])
+		}
+	}
+}
+
+func TestMRDPool_GenerationNotFound(t *testing.T) {
+	f := &versionedFactory{latest: 5}
+	pool := newVersionedPool(f, 0)
+	if _, err := pool.openDownloader(context.Background()); err != nil {
+		t.Fatalf("openDownloader() error = %v", err)
+	}
+
+	// The object is overwritten.
+	f.latest = 6
+	_, err := pool.openDownloader(context.Background())
+	if !errors.Is(err, ErrGenerationNotFound) || !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Fatalf("openDownloader() error = %v, want ErrGenerationNotFound", err)
+	}
+	if got := pool.Generation(); got != 5 {
+		t.Fatalf("Generation() = %d, want it to stay 5", got)
+	}
+}
+
+func TestMRDPool_RejectedReadHandle(t *testing.T) {
+	f := &versionedFactory{latest: 5}
+	pool := newVersionedPool(f, 5)
+	pool.readHandle = []byte("expired")
+	f.rejectHandles = true
+
+	// The downloader is opened again without the handle.
+	if _, err := pool.openDownloader(context.Background()); err != nil {
+		t.Fatalf("openDownloader() error = %v", err)
+	}
+	if len(f.handles) != 2 || f.handles[0] != "expired" || f.handles[1] != "" {
+		t.Fatalf("opened with handles %q, want the expired one then none", f.handles)
+	}
+	if got := string(pool.readHandle); got != "handle-1" {
+		t.Fatalf("read handle = %q, want the one of the new downloader", got)
+	}
+}
+
+func TestMRDPool_MixedGenerations(t *testing.T) {
+	f := &versionedFactory{latest: 6, ignoreGeneration: true}
+	pool := newVersionedPool(f, 5)
+
+	if _, err := pool.openDownloader(context.Background()); err == nil {
+		t.Fatal("openDownloader() of another generation should fail")
+	}
+}
+
+func TestMRDPool_RecreateGenerationNotFound(t *testing.T) {
+	f := &versionedFactory{latest: 5}
+	pool := newVersionedPool(f, 0)
+	pool.downloaders = make([]MultiRangeDownloader, 2)
+	pool.downloaderMutexes = make([]sync.Mutex, 2)
+	for i := range pool.downloaders {
+		d, err := pool.openDownloader(context.Background())
+		if err != nil {
+			t.Fatalf("openDownloader() error = %v", err)
+		}
+		d.(*mockMultiRangeDownloader).err = fmt.Errorf("stream broken")
+		pool.downloaders[i] = d
+	}
+	pool.poolSize = 2
+
+	// Every downloader is broken and the object is overwritten: Add fails
+	// without trying the other downloaders.
+	f.latest = 6
+	opens := len(f.generations)
+	err := pool.Add(context.Background(), nil, 0, 1024, nil)
+	if !errors.Is(err, ErrGenerationNotFound) {
+		t.Fatalf("Add() error = %v, want ErrGenerationNotFound", err)
+	}
+	if got := len(f.generations) - opens; got != 1 {
+		t.Fatalf("Add() opened %d downloaders, want 1", got)
+	}
+}
+
+func TestNewMRDPool_PinGeneration(t *testing.T) {
+	server, err := fakegcs.NewServer(fakegcs.Options{})
+	if err != nil {
+		t.Fatalf("fakegcs.NewServer() error = %v", err)
+	}
+	defer server.Close()
+	generation := server.PutObject("test-bucket", "test-object", make([]byte, 1024))
+
+	pool, err := NewMRDPool(&MRDPoolConfig{
+		PoolSize: 2,
+		Endpoint: server.GRPCEndpoint(),
+		Bucket:   "test-bucket",
+		Object:   "test-object",
+	})
+	if err != nil {
+		t.Fatalf("NewMRDPool() error = %v", err)
+	}
+	defer pool.Close()
+	if got := pool.Generation(); got != generation {
+		t.Fatalf("Generation() = %d, want %d", got, generation)
+	}
+
+	// Once the object is overwritten, new downloaders can't be opened.
+	server.PutObject("test-bucket", "test-object", make([]byte, 1024))
+	if _, err := pool.openDownloader(context.Background()); !errors.Is(err, ErrGenerationNotFound) {
+		t.Fatalf("openDownloader() error = %v, want ErrGenerationNotFound", err)
+	}
+}
@@ -0,0 +1,66 @@
+package rapid
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/raj-prince/custom-go-client-benchmark/util"
+)
+
+// hedgedAdd downloads the range through h: if the download hasn't completed
+// after the hedge threshold, the range is added again, to the next downloader
+// in round-robin order. The first download to complete is written to output
+// and reported to callback.
+//
+// Each download goes to its own buffer, so a hedged range takes up to twice its
+// length in memory. A MultiRangeDownloader can't cancel a single range, so the
+// losing download completes in the background and its data is dropped.
+//
+// If ctx is done first, both downloads are dropped and the callback gets an
+// ErrRangeCanceled error.
+//
+// The range is tracked until it is reported, see Wait.
+func (p *MRDPool) hedgedAdd(ctx context.Context, h *util.Hedger, output io.Writer, offset, length int64, callback func(int64, int64, error)) error {
+	p.pending.Add(1)
+	go func() {
+		defer p.pending.Done()
+		res, err := util.Hedge(ctx, h, func(ctx context.Context) (*bytes.Buffer, error) {
+			buf := &bytes.Buffer{}
+			done := make(chan error, 1)
+			if err := p.add(ctx, buf, offset, length, func(_, _ int64, err error) {
+				done <- err
+			}); err != nil {
+				return nil, err
+			}
+
+			select {
+			case err := <-done:
+				if err != nil {
+					return nil, err
+				}
+				return buf, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}, nil)
+		if err == nil {
+			res.Cancel()
+		}
+		if ctx.Err() != nil {
+			err = rangeCanceled(ctx)
+		}
+		if err != nil {
+			if callback != nil {
+				callback(offset, 0, err)
+			}
+			return
+		}
+
+		n, err := output.Write(res.Value.Bytes())
+		if callback != nil {
+			callback(offset, int64(n), err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,110 @@
+package rapid
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/raj-prince/custom-go-client-benchmark/util"
+)
+
+// delayedDownloader completes every range after delay, filling it with fill.
+type delayedDownloader struct {
+	mockMultiRangeDownloader
+	delay time.Duration
+	fill  byte
+}
+
+func (d *delayedDownloader) Add(output io.Writer, offset, length int64, callback func(int64, int64, error)) {
+	go func() {
+		time.Sleep(d.delay)
+		n, err := output.Write(bytes.Repeat([]byte{d.fill}, int(length)))
+		callback(offset, int64(n), err)
+	}()
+}
+
+func newTestHedger(t *testing.T, threshold time.Duration) *util.Hedger {
+	t.Helper()
+	d, err := util.NewConcurrentDelay(0.99, 15, threshold, time.Millisecond, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return util.NewHedger(d)
+}
+
+func TestMRDPool_HedgedAdd(t *testing.T) {
+	tests := []struct {
+		name       string
+		delays     [2]time.Duration
+		wantFill   byte
+		wantHedged uint64
+	}{
+		// Round-robin selection starts at downloader 1.
+		{name: "fast primary", delays: [2]time.Duration{time.Second, 0}, wantFill: 1, wantHedged: 0},
+		{name: "slow primary", delays: [2]time.Duration{0, time.Second}, wantFill: 0, wantHedged: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hedger := newTestHedger(t, 20*time.Millisecond)
+			pool := &MRDPool{
+				downloaders: []MultiRangeDownloader{
+					&delayedDownloader{delay: tt.delays[0], fill: 0},
+					&delayedDownloader{delay: tt.delays[1], fill: 1},
+				},
+				poolSize: 2,
+				cfg:      &MRDPoolConfig{Hedger: hedger},
+			}
+
+			var buf bytes.Buffer
+			done := make(chan error, 1)
+			start := time.Now()
+			if err := pool.Add(context.Background(), &buf, 0, 16, func(offset, length int64, err error) {
+				if offset != 0 || length != 16 {
+					t.Errorf("callback got offset=%d, length=%d, want 0, 16", offset, length)
+				}
+				done <- err
+			}); err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+
+			if err := <-done; err != nil {
+				t.Fatalf("callback error = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Fatalf("hedged Add took %v", elapsed)
+			}
+			if want := bytes.Repeat([]byte{tt.wantFill}, 16); !bytes.Equal(buf.Bytes(), want) {
+				t.Fatalf("output = %v, want %v", buf.Bytes(), want)
+			}
+			if s := hedger.Stats(); s.Calls != 1 || s.Hedged != tt.wantHedged || s.BackupWins != tt.wantHedged {
+				t.Fatalf("unexpected hedge stats: %+v", s)
+			}
+		})
+	}
+}
+
+func TestMRDPool_HedgedAddWait(t *testing.T) {
+	pool := &MRDPool{
+		downloaders: []MultiRangeDownloader{
+			&delayedDownloader{delay: 50 * time.Millisecond, fill: 1},
+			&delayedDownloader{delay: 50 * time.Millisecond, fill: 1},
+		},
+		poolSize: 2,
+		cfg:      &MRDPoolConfig{Hedger: newTestHedger(t, 20*time.Millisecond)},
+	}
+
+	// Without a callback, the range is only seen in the output, written by
+	// the time Wait returns.
+	var buf lockedWriter
+	if err := pool.Add(context.Background(), &buf, 0, 16, nil); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	pool.Wait()
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	if want := bytes.Repeat([]byte{1}, 16); !bytes.Equal(buf.buf.Bytes(), want) {
+		t.Fatalf("output = %v after Wait, want %v", buf.buf.Bytes(), want)
+	}
+}
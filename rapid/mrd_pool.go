@@ -2,12 +2,15 @@ package rapid
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/raj-prince/custom-go-client-benchmark/util"
 )
 
 // An interface to generalize the MultiRangeDownloader
@@ -28,21 +31,90 @@ type MRDPoolConfig struct {
 	// Client is the GCS storage client used to create MRD instances
 	Client *storage.Client
 
+	// Endpoint overrides the gRPC endpoint (host:port) when Client is nil,
+	// e.g. to target an emulator or fakegcs. The pool then creates its own
+	// client, see NewEndpointGrpcClient, and closes it on Close.
+	Endpoint string
+
 	Bucket string
 	Object string
+	// Generation, if set, is the generation of the object read. If 0, the
+	// pool reads the latest generation when it is created and sticks to it,
+	// see openDownloader.
+	Generation int64
+
+	// Hedger, if set, hedges every Add, see hedgedAdd.
+	Hedger *util.Hedger
+
+	// Selection selects the downloader of every range, SelectRoundRobin if
+	// empty.
+	Selection SelectionPolicy
+
+	// MaxPoolSize, if set, makes the pool elastic, see elastic.go: the pool
+	// starts with PoolSize downloaders, opens more up to MaxPoolSize when they
+	// have more than ScaleUpInFlight (default 4) ranges in flight on average,
+	// and closes the ones idle for IdleTimeout down to MinPoolSize (default
+	// 1). An IdleTimeout of 0 never closes downloaders.
+	MaxPoolSize     int
+	MinPoolSize     int
+	ScaleUpInFlight int
+	IdleTimeout     time.Duration
+
+	// MaxAttempts, if above 1, retries a range failing mid-flight up to
+	// MaxAttempts downloads in total, see retry.go. Retries wait for an
+	// exponential backoff, from InitialBackoff (default 100ms) multiplied by
+	// BackoffMultiplier (default 2) after every attempt, up to MaxBackoff
+	// (default 10s).
+	MaxAttempts       int
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
 }
 
 // MRDPool manages a pool of MultiRangeDownloader instances and distributes
-// requests across them using round-robin scheduling.
+// requests across them, in round-robin order or by load, see SelectionPolicy.
+//
+// Downloaders live in fixed slots, so their index is stable. An elastic pool
+// has MaxPoolSize slots, the ones of closed downloaders are nil.
 type MRDPool struct {
 	downloaders []MultiRangeDownloader
 	counter     uint64 // atomic counter for round-robin
-	poolSize    int
-	mu          sync.RWMutex
-	closed      bool
-	cfg         *MRDPoolConfig
+	// loads track the ranges in flight on each downloader, see initSlots.
+	loads []*downloaderLoad
+	// active are the indices of the open downloaders, in increasing order.
+	active    []int
+	slotsOnce sync.Once
+	poolSize  int
+	mu        sync.RWMutex
+	closed    bool
+	cfg       *MRDPoolConfig
+	// ownedClient is the client created for cfg.Endpoint, if any.
+	ownedClient *storage.Client
 	// Per-downloader mutexes for safe recreation
 	downloaderMutexes []sync.Mutex
+	// newDownloader opens a downloader of generation, reusing handle if set,
+	// and returns the generation it reads, see openDownloader.
+	newDownloader func(ctx context.Context, generation int64, handle []byte) (MultiRangeDownloader, int64, error)
+
+	// The generation the pool is pinned to and the latest read handle, see
+	// generation.go. Guarded by mu.
+	generation int64
+	readHandle []byte
+
+	// Elastic pool state, see elastic.go.
+	scaling     atomic.Bool
+	scaleUps    atomic.Uint64
+	scaleDowns  atomic.Uint64
+	scaleEvents []ScaleEvent // guarded by mu
+	stopScaling chan struct{}
+
+	// attempts count the ranges completed by number of attempts, see
+	// retry.go.
+	attempts []atomic.Uint64
+
+	// pending tracks the ranges not reported yet that no downloader tracks,
+	// e.g. while a retry backs off, see Wait.
+	pending sync.WaitGroup
 }
 
 // NewMRDPool creates a new pool of MultiRangeDownloader instances.
@@ -55,7 +127,7 @@ func NewMRDPool(config *MRDPoolConfig) (*MRDPool, error) {
 		return nil, fmt.Errorf("pool size must be greater than 0")
 	}
 
-	if config.Client == nil {
+	if config.Client == nil && config.Endpoint == "" {
 		return nil, fmt.Errorf("storage client cannot be nil")
 	}
 
@@ -63,18 +135,46 @@ func NewMRDPool(config *MRDPoolConfig) (*MRDPool, error) {
 		return nil, fmt.Errorf("bucket name and object name cannot be empty")
 	}
 
-	objectHandle := config.Client.Bucket(config.Bucket).Object(config.Object)
+	if config.Selection != "" {
+		if _, err := ParseSelectionPolicy(string(config.Selection)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateElastic(config); err != nil {
+		return nil, err
+	}
+
+	if err := validateRetry(config); err != nil {
+		return nil, err
+	}
 
+	var ownedClient *storage.Client
+	if config.Client == nil {
+		client, err := NewEndpointGrpcClient(context.Background(), config.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client for endpoint %q: %w", config.Endpoint, err)
+		}
+		// Work on a copy so the caller's config is left untouched.
+		cfgCopy := *config
+		cfgCopy.Client = client
+		config = &cfgCopy
+		ownedClient = client
+	}
+
+	slots := max(config.PoolSize, config.MaxPoolSize)
 	pool := &MRDPool{
-		downloaders:       make([]MultiRangeDownloader, config.PoolSize),
+		downloaders:       make([]MultiRangeDownloader, slots),
 		poolSize:          config.PoolSize,
 		cfg:               config,
-		downloaderMutexes: make([]sync.Mutex, config.PoolSize),
+		ownedClient:       ownedClient,
+		downloaderMutexes: make([]sync.Mutex, slots),
+		generation:        config.Generation,
 	}
 
 	// Initialize all MRD instances in the pool
 	for i := 0; i < config.PoolSize; i++ {
-		mrd, err := objectHandle.NewMultiRangeDownloader(context.Background())
+		mrd, err := pool.openDownloader(context.Background())
 		if err != nil {
 			// Clean up any created downloaders before returning error
 			pool.Close()
@@ -82,28 +182,71 @@ func NewMRDPool(config *MRDPoolConfig) (*MRDPool, error) {
 		}
 		pool.downloaders[i] = mrd
 	}
+	pool.initSlots()
+	pool.startScaling()
 
 	return pool, nil
 }
 
-// getNextDownloader returns the next downloader using round-robin selection.
-func (p *MRDPool) getNextDownloader() (MultiRangeDownloader, int, error) {
+// initSlots creates the load of every slot and the list of the open
+// downloaders, once.
+func (p *MRDPool) initSlots() {
+	p.slotsOnce.Do(func() {
+		now := time.Now()
+		p.loads = make([]*downloaderLoad, len(p.downloaders))
+		for i := range p.loads {
+			p.loads[i] = newDownloaderLoad(now)
+			if p.downloaders[i] != nil {
+				p.active = append(p.active, i)
+			}
+		}
+		p.attempts = make([]atomic.Uint64, p.maxAttempts())
+	})
+}
+
+// getNextDownloader returns the next downloader, selected by the pool
+// SelectionPolicy, and starts a range of length bytes in its load. The caller
+// must complete or cancel the range in the returned load.
+func (p *MRDPool) getNextDownloader(length int64) (MultiRangeDownloader, int, *downloaderLoad, error) {
+	p.initSlots()
+
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
 	if p.closed {
-		return nil, -1, fmt.Errorf("pool is closed")
+		return nil, -1, nil, fmt.Errorf("pool is closed")
 	}
 
 	// Use atomic operations for thread-safe round-robin
-	index := int(atomic.AddUint64(&p.counter, 1) % uint64(p.poolSize))
+	index := p.selectIndex(atomic.AddUint64(&p.counter, 1))
+	// Under the lock, so an idle downloader can't be closed in between.
+	load := p.loads[index]
+	load.start(length)
+	p.maybeScaleUp()
 
-	return p.downloaders[index], index, nil
+	return p.downloaders[index], index, load, nil
 }
 
-// recreateDownloader safely recreates a downloader at the given index.
+// recreateDownloader safely recreates a downloader at the given index, or
+// returns ErrRangeCanceled when ctx is done first. The downloader outlives the
+// range, so it is still recreated in the background for the next ranges.
+func (p *MRDPool) recreateDownloader(ctx context.Context, index int) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- p.recreate(context.WithoutCancel(ctx), index)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return rangeCanceled(ctx)
+	}
+}
+
+// recreate implements recreateDownloader.
 // It uses a per-downloader mutex to ensure only one goroutine recreates it at a time.
-func (p *MRDPool) recreateDownloader(index int) error {
+func (p *MRDPool) recreate(ctx context.Context, index int) error {
 	// Use per-downloader mutex to prevent concurrent recreation
 	p.downloaderMutexes[index].Lock()
 	defer p.downloaderMutexes[index].Unlock()
@@ -125,8 +268,7 @@ func (p *MRDPool) recreateDownloader(index int) error {
 	}
 
 	// Create new downloader
-	objectHandle := p.cfg.Client.Bucket(p.cfg.Bucket).Object(p.cfg.Object)
-	newDownloader, err := objectHandle.NewMultiRangeDownloader(context.Background())
+	newDownloader, err := p.openDownloader(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to recreate downloader %d: %w", index, err)
 	}
@@ -149,11 +291,27 @@ func (p *MRDPool) recreateDownloader(index int) error {
 // when the download completes with the offset, length, and any error.
 // If the selected downloader is in error state, it attempts to recreate it and retry
 // up to maxRetries times across different downloaders.
-func (p *MRDPool) Add(output io.Writer, offset, length int64, callback func(int64, int64, error)) error {
+// If the pool has a Hedger, the download is hedged, see hedgedAdd.
+// If the pool has MaxAttempts, a range failing mid-flight is retried, see add.
+//
+// If ctx is done before the download completes, the callback gets an
+// ErrRangeCanceled error, see cancelableRange.
+func (p *MRDPool) Add(ctx context.Context, output io.Writer, offset, length int64, callback func(int64, int64, error)) error {
+	if ctx.Err() != nil {
+		return rangeCanceled(ctx)
+	}
+	if p.cfg != nil && p.cfg.Hedger != nil {
+		return p.hedgedAdd(ctx, p.cfg.Hedger, output, offset, length, callback)
+	}
+	return p.add(ctx, output, offset, length, callback)
+}
+
+// addAttempt implements Add without hedging nor retries.
+func (p *MRDPool) addAttempt(ctx context.Context, output io.Writer, offset, length int64, callback func(int64, int64, error)) error {
 	const maxRetries = 3
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		downloader, index, err := p.getNextDownloader()
+		downloader, index, load, err := p.getNextDownloader(length)
 		if err != nil {
 			return err
 		}
@@ -161,7 +319,11 @@ func (p *MRDPool) Add(output io.Writer, offset, length int64, callback func(int6
 		// Check if this downloader has an error
 		if downloader.Error() != nil {
 			// Attempt to recreate the downloader
-			if recreateErr := p.recreateDownloader(index); recreateErr != nil {
+			if recreateErr := p.recreateDownloader(ctx, index); recreateErr != nil {
+				load.cancel(length)
+				if errors.Is(recreateErr, ErrRangeCanceled) || errors.Is(recreateErr, ErrGenerationNotFound) {
+					return recreateErr
+				}
 				// If we can't recreate, try next downloader
 				if attempt < maxRetries-1 {
 					continue
@@ -175,16 +337,30 @@ func (p *MRDPool) Add(output io.Writer, offset, length int64, callback func(int6
 			p.mu.RUnlock()
 		}
 
-		// Add the download task
-		downloader.Add(output, offset, length, callback)
+		// Add the download task, tracking it in the load of the downloader.
+		start := time.Now()
+		output, callback := cancelable(ctx, offset, output, callback)
+		downloader.Add(output, offset, length, func(offset, n int64, err error) {
+			load.done(length, time.Since(start))
+			if callback != nil {
+				callback(offset, n, err)
+			}
+		})
 		return nil
 	}
 
 	return fmt.Errorf("failed to add download task after %d retries", maxRetries)
 }
 
-// Wait waits for all downloads on all MRD instances to complete.
+// Wait waits for all downloads on all MRD instances to complete, and for the
+// ranges retried or hedged to be reported.
 func (p *MRDPool) Wait() {
+	p.waitDownloaders()
+	p.pending.Wait()
+}
+
+// waitDownloaders waits for the downloads on all MRD instances to complete.
+func (p *MRDPool) waitDownloaders() {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
@@ -220,17 +396,22 @@ func (p *MRDPool) Error() error {
 	return nil
 }
 
-// GetHandle returns the handle from the first MRD instance in the pool.
+// GetHandle returns the handle from the first open MRD instance in the pool.
 // This is primarily for compatibility with the MultiRangeDownloader interface.
 func (p *MRDPool) GetHandle() []byte {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	if p.closed || len(p.downloaders) == 0 || p.downloaders[0] == nil {
+	if p.closed {
 		return nil
 	}
 
-	return p.downloaders[0].GetHandle()
+	for _, downloader := range p.downloaders {
+		if downloader != nil {
+			return downloader.GetHandle()
+		}
+	}
+	return nil
 }
 
 // Close closes all MultiRangeDownloader instances in the pool.
@@ -252,6 +433,15 @@ func (p *MRDPool) Close() error {
 	}
 
 	p.closed = true
+	if p.stopScaling != nil {
+		close(p.stopScaling)
+	}
+
+	if p.ownedClient != nil {
+		if err := p.ownedClient.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close client: %w", err))
+		}
+	}
 
 	if len(errs) > 0 {
 		return fmt.Errorf("errors closing downloaders: %v", errs)
@@ -260,8 +450,10 @@ func (p *MRDPool) Close() error {
 	return nil
 }
 
-// PoolSize returns the size of the pool.
+// PoolSize returns the number of open downloaders of the pool.
 func (p *MRDPool) PoolSize() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return p.poolSize
 }
 
@@ -274,19 +466,57 @@ func (p *MRDPool) IsClosed() bool {
 
 // Stats returns statistics about the pool usage.
 type PoolStats struct {
-	PoolSize     int
+	// PoolSize is the number of open downloaders.
+	PoolSize int
+	// Generation is the generation of the object read by the pool.
+	Generation   int64
 	RequestCount uint64
 	Closed       bool
+	// Downloaders are the statistics of each open downloader.
+	Downloaders []DownloaderStats
+
+	// ScaleUps and ScaleDowns count the downloaders opened and closed by an
+	// elastic pool, ScaleEvents are the latest ones.
+	ScaleUps    uint64
+	ScaleDowns  uint64
+	ScaleEvents []ScaleEvent
+
+	// Attempts count the ranges completed, successfully or not, by number of
+	// attempts: Attempts[i] ranges took i+1 attempts. Retries is the number
+	// of attempts after the first. They are counted only if the pool retries
+	// ranges, see MRDPoolConfig.MaxAttempts.
+	Attempts []uint64
+	Retries  uint64
 }
 
 // GetStats returns current pool statistics.
 func (p *MRDPool) GetStats() PoolStats {
+	p.initSlots()
+
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	return PoolStats{
+	stats := PoolStats{
 		PoolSize:     p.poolSize,
+		Generation:   p.generation,
 		RequestCount: atomic.LoadUint64(&p.counter),
 		Closed:       p.closed,
+		Downloaders:  make([]DownloaderStats, 0, len(p.active)),
+		ScaleUps:     p.scaleUps.Load(),
+		ScaleDowns:   p.scaleDowns.Load(),
+		ScaleEvents:  append([]ScaleEvent(nil), p.scaleEvents...),
+	}
+	if p.maxAttempts() > 1 {
+		stats.Attempts = make([]uint64, len(p.attempts))
+		for i := range p.attempts {
+			stats.Attempts[i] = p.attempts[i].Load()
+			stats.Retries += uint64(i) * stats.Attempts[i]
+		}
+	}
+	for _, index := range p.active {
+		d := p.loads[index].stats()
+		d.Index = index
+		stats.Downloaders = append(stats.Downloaders, d)
 	}
+	return stats
 }
@@ -0,0 +1,383 @@
+package rapid
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"cloud.google.com/go/storage"
+)
+
+// PoolKey identifies the pool of an object in an MRDPoolManager.
+type PoolKey struct {
+	Bucket string
+	Object string
+	// Generation is the generation of the object, the latest if 0.
+	Generation int64
+}
+
+func (k PoolKey) String() string {
+	if k.Generation > 0 {
+		return fmt.Sprintf("gs://%s/%s#%d", k.Bucket, k.Object, k.Generation)
+	}
+	return fmt.Sprintf("gs://%s/%s", k.Bucket, k.Object)
+}
+
+// MRDPoolManagerConfig contains configuration for the MRD pool manager.
+type MRDPoolManagerConfig struct {
+	// Pool is the configuration of every pool, its Bucket, Object and
+	// Generation are set from the PoolKey. If Pool.Client is nil, the manager
+	// creates one client for Pool.Endpoint and closes it on Close.
+	Pool MRDPoolConfig
+
+	// MaxDownloaders caps the downloaders open across all pools, no cap if 0.
+	// It must allow at least one pool. The cap is enforced when a pool is
+	// created: the least recently used pools with no range in flight are
+	// closed to make room for it. An elastic pool, see Pool.MaxPoolSize, counts
+	// as MaxPoolSize downloaders, as it may grow to that many.
+	MaxDownloaders int
+}
+
+// managedPool is a pool of an MRDPoolManager.
+type managedPool struct {
+	key PoolKey
+	// elem is the element of the pool in the LRU list.
+	elem *list.Element
+	// ready is closed once pool or err is set. They are guarded by the
+	// manager mu, as is users, the number of Adds using the pool, which is
+	// not evicted while they run.
+	ready chan struct{}
+	pool  *MRDPool
+	err   error
+	users int
+}
+
+// downloaders returns the number of downloaders counted for the pool against
+// MaxDownloaders: reserved, the most it may open, unless it failed to be
+// created. It must be called with the manager mu held.
+func (e *managedPool) downloaders(reserved int) int {
+	select {
+	case <-e.ready:
+		if e.pool == nil {
+			return 0
+		}
+		return max(e.pool.PoolSize(), reserved)
+	default:
+		return reserved
+	}
+}
+
+// MRDPoolManager routes ranges of many objects, e.g. the shards of a dataset,
+// to one MRDPool per object. Pools are created on the first range of their
+// object and cached, in least recently used order.
+//
+// MRDPoolManager is goroutine-safe.
+type MRDPoolManager struct {
+	cfg MRDPoolManagerConfig
+	// ownedClient is the client created for cfg.Pool.Endpoint, if any.
+	ownedClient *storage.Client
+	// newPool creates a pool, NewMRDPool if nil.
+	newPool func(config *MRDPoolConfig) (*MRDPool, error)
+
+	mu     sync.Mutex
+	pools  map[PoolKey]*managedPool
+	lru    *list.List // of *managedPool, most recently used first.
+	closed bool
+
+	created   atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// NewMRDPoolManager creates a new manager with no pool.
+func NewMRDPoolManager(config *MRDPoolManagerConfig) (*MRDPoolManager, error) {
+	if config == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+
+	if config.Pool.PoolSize <= 0 {
+		return nil, fmt.Errorf("pool size must be greater than 0")
+	}
+
+	if config.Pool.Client == nil && config.Pool.Endpoint == "" {
+		return nil, fmt.Errorf("storage client cannot be nil")
+	}
+
+	if config.MaxDownloaders < 0 || (config.MaxDownloaders > 0 && config.MaxDownloaders < reservedDownloaders(&config.Pool)) {
+		return nil, fmt.Errorf("max downloaders must be 0 or at least the max pool size")
+	}
+
+	// Work on a copy so the caller's config is left untouched.
+	cfg := *config
+	m := &MRDPoolManager{
+		cfg:   cfg,
+		pools: make(map[PoolKey]*managedPool),
+		lru:   list.New(),
+	}
+
+	if cfg.Pool.Client == nil {
+		client, err := NewEndpointGrpcClient(context.Background(), cfg.Pool.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client for endpoint %q: %w", cfg.Pool.Endpoint, err)
+		}
+		m.cfg.Pool.Client = client
+		m.ownedClient = client
+	}
+
+	return m, nil
+}
+
+// Add adds a download task of the latest generation of bucket/object to its
+// pool, see MRDPool.Add.
+func (m *MRDPoolManager) Add(ctx context.Context, bucket, object string, output io.Writer, offset, length int64, callback func(int64, int64, error)) error {
+	return m.AddKey(ctx, PoolKey{Bucket: bucket, Object: object}, output, offset, length, callback)
+}
+
+// AddKey adds a download task to the pool of key, creating the pool if needed.
+func (m *MRDPoolManager) AddKey(ctx context.Context, key PoolKey, output io.Writer, offset, length int64, callback func(int64, int64, error)) error {
+	e, err := m.acquire(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer m.release(e)
+
+	return e.pool.Add(ctx, output, offset, length, callback)
+}
+
+// acquire returns the pool of key, creating it if needed, and marks it used
+// until release. The pool outlives the range, so it is created even if ctx is
+// done, but acquire then no longer waits for it.
+func (m *MRDPoolManager) acquire(ctx context.Context, key PoolKey) (*managedPool, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("pool manager is closed")
+	}
+
+	var evicted []*MRDPool
+	e, ok := m.pools[key]
+	if ok {
+		m.lru.MoveToFront(e.elem)
+	} else {
+		// Make room for the new pool before opening its downloaders.
+		var err error
+		evicted, err = m.evictLocked(reservedDownloaders(&m.cfg.Pool))
+		if err != nil {
+			m.mu.Unlock()
+			closePools(evicted)
+			return nil, err
+		}
+		e = &managedPool{key: key, ready: make(chan struct{})}
+		e.elem = m.lru.PushFront(e)
+		m.pools[key] = e
+		go m.create(e)
+	}
+	e.users++
+	m.mu.Unlock()
+	closePools(evicted)
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		m.release(e)
+		return nil, rangeCanceled(ctx)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e.err != nil {
+		e.users--
+		return nil, e.err
+	}
+	return e, nil
+}
+
+// create creates the pool of e. Creating a pool opens its downloaders, so it
+// is done without the lock; the Adds of its key wait for it on ready.
+func (m *MRDPoolManager) create(e *managedPool) {
+	cfg := m.cfg.Pool
+	cfg.Bucket, cfg.Object, cfg.Generation = e.key.Bucket, e.key.Object, e.key.Generation
+	newPool := m.newPool
+	if newPool == nil {
+		newPool = NewMRDPool
+	}
+	pool, err := newPool(&cfg)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil && m.closed {
+		// Close missed the pool, as it was not created yet.
+		_ = pool.Close()
+		pool, err = nil, fmt.Errorf("pool manager is closed")
+	}
+	if err != nil {
+		e.err = fmt.Errorf("failed to create pool for %v: %w", e.key, err)
+		m.removeLocked(e)
+	} else {
+		e.pool = pool
+		m.created.Add(1)
+	}
+	close(e.ready)
+}
+
+// release marks e unused by an acquire.
+func (m *MRDPoolManager) release(e *managedPool) {
+	m.mu.Lock()
+	e.users--
+	m.mu.Unlock()
+}
+
+// evictLocked removes the least recently used pools until want more
+// downloaders fit in MaxDownloaders, and returns them for closePools. Pools
+// in use or with ranges in flight are kept. It must be called with m.mu held.
+func (m *MRDPoolManager) evictLocked(want int) ([]*MRDPool, error) {
+	if m.cfg.MaxDownloaders == 0 {
+		return nil, nil
+	}
+
+	reserved := reservedDownloaders(&m.cfg.Pool)
+	open := 0
+	for _, e := range m.pools {
+		open += e.downloaders(reserved)
+	}
+
+	var evicted []*MRDPool
+	for elem := m.lru.Back(); elem != nil && open+want > m.cfg.MaxDownloaders; {
+		e := elem.Value.(*managedPool)
+		elem = elem.Prev()
+		if e.users > 0 || e.pool == nil || e.pool.inFlight() > 0 {
+			continue
+		}
+
+		open -= e.downloaders(reserved)
+		m.removeLocked(e)
+		m.evictions.Add(1)
+		evicted = append(evicted, e.pool)
+	}
+
+	if open+want > m.cfg.MaxDownloaders {
+		// The pools evicted so far are idle, so they are closed anyway.
+		return evicted, fmt.Errorf("cannot open %d downloaders: %d of %d are busy", want, open, m.cfg.MaxDownloaders)
+	}
+	return evicted, nil
+}
+
+// reservedDownloaders returns the most downloaders a pool of config may
+// open, MaxPoolSize for an elastic pool.
+func reservedDownloaders(config *MRDPoolConfig) int {
+	return max(config.PoolSize, config.MaxPoolSize)
+}
+
+// removeLocked removes e from the cache. It must be called with m.mu held.
+func (m *MRDPoolManager) removeLocked(e *managedPool) {
+	if m.pools[e.key] == e {
+		delete(m.pools, e.key)
+		m.lru.Remove(e.elem)
+	}
+}
+
+// closePools closes pools, best effort, as when recreating a downloader.
+func closePools(pools []*MRDPool) {
+	for _, pool := range pools {
+		_ = pool.Close()
+	}
+}
+
+// Wait waits for all downloads of all pools to complete.
+func (m *MRDPoolManager) Wait() {
+	for _, pool := range m.readyPools() {
+		pool.Wait()
+	}
+}
+
+// readyPools returns the created pools, most recently used first.
+func (m *MRDPoolManager) readyPools() []*MRDPool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pools []*MRDPool
+	for elem := m.lru.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(*managedPool)
+		if e.pool != nil {
+			pools = append(pools, e.pool)
+		}
+	}
+	return pools
+}
+
+// Close closes all pools of the manager.
+func (m *MRDPoolManager) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	m.mu.Unlock()
+
+	var errs []error
+	for _, pool := range m.readyPools() {
+		if err := pool.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if m.ownedClient != nil {
+		if err := m.ownedClient.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close client: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ManagerStats are statistics about the manager usage.
+type ManagerStats struct {
+	// Pools and Downloaders are the number of open pools and downloaders.
+	Pools       int
+	Downloaders int
+	// Created and Evictions count the pools created and evicted.
+	Created   uint64
+	Evictions uint64
+	// PoolStats are the statistics of each open pool.
+	PoolStats map[PoolKey]PoolStats
+}
+
+// GetStats returns current manager statistics.
+func (m *MRDPoolManager) GetStats() ManagerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := ManagerStats{
+		Created:   m.created.Load(),
+		Evictions: m.evictions.Load(),
+		PoolStats: make(map[PoolKey]PoolStats, len(m.pools)),
+	}
+	for key, e := range m.pools {
+		if e.pool == nil {
+			continue
+		}
+		poolStats := e.pool.GetStats()
+		stats.Pools++
+		stats.Downloaders += poolStats.PoolSize
+		stats.PoolStats[key] = poolStats
+	}
+	return stats
+}
+
+// inFlight returns the number of ranges in flight on the pool.
+func (p *MRDPool) inFlight() int64 {
+	p.initSlots()
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var n int64
+	for _, index := range p.active {
+		n += p.loads[index].inFlight.Load()
+	}
+	return n
+}
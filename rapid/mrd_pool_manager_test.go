@@ -0,0 +1,236 @@
+package rapid
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+// newMockManager returns a manager creating pools of mock downloaders, and
+// the mock downloaders of each pool.
+func newMockManager(t *testing.T, cfg MRDPoolManagerConfig) (*MRDPoolManager, map[PoolKey]*mockFactory) {
+	t.Helper()
+	cfg.Pool.Client = &storage.Client{}
+	m, err := NewMRDPoolManager(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	factories := make(map[PoolKey]*mockFactory)
+	m.newPool = func(config *MRDPoolConfig) (*MRDPool, error) {
+		if config.Object == "missing" {
+			return nil, fmt.Errorf("object not found")
+		}
+		f := &mockFactory{}
+		mu.Lock()
+		factories[PoolKey{Bucket: config.Bucket, Object: config.Object, Generation: config.Generation}] = f
+		mu.Unlock()
+
+		pool := &MRDPool{
+			downloaders:       make([]MultiRangeDownloader, config.PoolSize),
+			poolSize:          config.PoolSize,
+			cfg:               config,
+			downloaderMutexes: make([]sync.Mutex, config.PoolSize),
+			newDownloader:     f.open,
+		}
+		for i := range pool.downloaders {
+			pool.downloaders[i], _, _ = f.open(context.Background(), 0, nil)
+		}
+		pool.initSlots()
+		return pool, nil
+	}
+	t.Cleanup(func() { m.Close() })
+	return m, factories
+}
+
+func TestNewMRDPoolManager(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *MRDPoolManagerConfig
+		errContains string
+	}{
+		{name: "nil config", config: nil, errContains: "config cannot be nil"},
+		{name: "zero pool size", config: &MRDPoolManagerConfig{Pool: MRDPoolConfig{Client: &storage.Client{}}}, errContains: "pool size must be greater than 0"},
+		{name: "nil client", config: &MRDPoolManagerConfig{Pool: MRDPoolConfig{PoolSize: 2}}, errContains: "storage client cannot be nil"},
+		{name: "cap below pool size", config: &MRDPoolManagerConfig{Pool: MRDPoolConfig{PoolSize: 2, Client: &storage.Client{}}, MaxDownloaders: 1}, errContains: "max downloaders"},
+		{name: "cap below max pool size", config: &MRDPoolManagerConfig{Pool: MRDPoolConfig{PoolSize: 2, MaxPoolSize: 4, Client: &storage.Client{}}, MaxDownloaders: 3}, errContains: "max downloaders"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMRDPoolManager(tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Fatalf("NewMRDPoolManager() error = %v, want it to contain %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestMRDPoolManager_Routing(t *testing.T) {
+	m, factories := newMockManager(t, MRDPoolManagerConfig{Pool: MRDPoolConfig{PoolSize: 2}})
+
+	var buf bytes.Buffer
+	for i := 0; i < 4; i++ {
+		for _, object := range []string{"shard-0", "shard-1"} {
+			if err := m.Add(context.Background(), "bucket", object, &buf, 0, 1024, nil); err != nil {
+				t.Fatalf("Add(%s) error = %v", object, err)
+			}
+		}
+	}
+	if err := m.AddKey(context.Background(), PoolKey{Bucket: "bucket", Object: "shard-0", Generation: 7}, &buf, 0, 1024, nil); err != nil {
+		t.Fatalf("AddKey() error = %v", err)
+	}
+
+	stats := m.GetStats()
+	if stats.Pools != 3 || stats.Downloaders != 6 || stats.Created != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	// Each object has its own pool, spreading its ranges over its downloaders.
+	for _, object := range []string{"shard-0", "shard-1"} {
+		f := factories[PoolKey{Bucket: "bucket", Object: object}]
+		for _, mock := range f.mocks {
+			if got := mock.pending(); got != 2 {
+				t.Errorf("%s downloader %d got %d ranges, want 2", object, mock.id, got)
+			}
+		}
+	}
+	pending := 0
+	for _, mock := range factories[PoolKey{Bucket: "bucket", Object: "shard-0", Generation: 7}].mocks {
+		pending += mock.pending()
+	}
+	if pending != 1 {
+		t.Errorf("generation 7 pool got %d ranges, want 1", pending)
+	}
+
+	m.Wait()
+	for key, poolStats := range m.GetStats().PoolStats {
+		for _, d := range poolStats.Downloaders {
+			if d.InFlight != 0 {
+				t.Errorf("%v downloader %d has %d ranges in flight after Wait", key, d.Index, d.InFlight)
+			}
+		}
+	}
+}
+
+func TestMRDPoolManager_EvictLRU(t *testing.T) {
+	m, factories := newMockManager(t, MRDPoolManagerConfig{Pool: MRDPoolConfig{PoolSize: 2}, MaxDownloaders: 4})
+
+	var buf bytes.Buffer
+	add := func(object string) {
+		t.Helper()
+		if err := m.Add(context.Background(), "bucket", object, &buf, 0, 1024, nil); err != nil {
+			t.Fatalf("Add(%s) error = %v", object, err)
+		}
+		m.Wait()
+	}
+	add("a")
+	add("b")
+	add("a")
+	// c doesn't fit, b is the least recently used pool.
+	add("c")
+
+	stats := m.GetStats()
+	if stats.Pools != 2 || stats.Downloaders != 4 || stats.Evictions != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if _, ok := stats.PoolStats[PoolKey{Bucket: "bucket", Object: "b"}]; ok {
+		t.Fatal("pool b should be evicted")
+	}
+	for _, mock := range factories[PoolKey{Bucket: "bucket", Object: "b"}].mocks {
+		if !mock.closed {
+			t.Errorf("downloader %d of the evicted pool is not closed", mock.id)
+		}
+	}
+
+	// b is created again on its next range.
+	add("b")
+	if stats := m.GetStats(); stats.Created != 4 || stats.Evictions != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestMRDPoolManager_EvictElastic(t *testing.T) {
+	// Elastic pools count as MaxPoolSize downloaders, so a and b don't fit
+	// together even though each opens a single one.
+	m, _ := newMockManager(t, MRDPoolManagerConfig{Pool: MRDPoolConfig{PoolSize: 1, MaxPoolSize: 2}, MaxDownloaders: 3})
+
+	var buf bytes.Buffer
+	for _, object := range []string{"a", "b"} {
+		if err := m.Add(context.Background(), "bucket", object, &buf, 0, 1024, nil); err != nil {
+			t.Fatalf("Add(%s) error = %v", object, err)
+		}
+		m.Wait()
+	}
+
+	stats := m.GetStats()
+	if stats.Pools != 1 || stats.Evictions != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if _, ok := stats.PoolStats[PoolKey{Bucket: "bucket", Object: "a"}]; ok {
+		t.Fatal("pool a should be evicted")
+	}
+}
+
+func TestMRDPoolManager_BusyPoolsKept(t *testing.T) {
+	m, _ := newMockManager(t, MRDPoolManagerConfig{Pool: MRDPoolConfig{PoolSize: 2}, MaxDownloaders: 4})
+
+	var buf bytes.Buffer
+	// The ranges of a and b stay in flight until Wait.
+	for _, object := range []string{"a", "b"} {
+		if err := m.Add(context.Background(), "bucket", object, &buf, 0, 1024, nil); err != nil {
+			t.Fatalf("Add(%s) error = %v", object, err)
+		}
+	}
+	if err := m.Add(context.Background(), "bucket", "c", &buf, 0, 1024, nil); err == nil || !strings.Contains(err.Error(), "busy") {
+		t.Fatalf("Add(c) error = %v, want busy downloaders", err)
+	}
+
+	m.Wait()
+	if err := m.Add(context.Background(), "bucket", "c", &buf, 0, 1024, nil); err != nil {
+		t.Fatalf("Add(c) after Wait error = %v", err)
+	}
+}
+
+func TestMRDPoolManager_CreateError(t *testing.T) {
+	m, _ := newMockManager(t, MRDPoolManagerConfig{Pool: MRDPoolConfig{PoolSize: 2}})
+
+	var buf bytes.Buffer
+	for i := 0; i < 2; i++ {
+		err := m.Add(context.Background(), "bucket", "missing", &buf, 0, 1024, nil)
+		if err == nil || !strings.Contains(err.Error(), "object not found") {
+			t.Fatalf("Add() error = %v, want object not found", err)
+		}
+	}
+	if stats := m.GetStats(); stats.Pools != 0 || stats.Created != 0 {
+		t.Fatalf("failed pools should not be cached: %+v", stats)
+	}
+}
+
+func TestMRDPoolManager_Close(t *testing.T) {
+	m, factories := newMockManager(t, MRDPoolManagerConfig{Pool: MRDPoolConfig{PoolSize: 2}})
+
+	var buf bytes.Buffer
+	if err := m.Add(context.Background(), "bucket", "a", &buf, 0, 1024, nil); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	for _, mock := range factories[PoolKey{Bucket: "bucket", Object: "a"}].mocks {
+		if !mock.closed {
+			t.Errorf("downloader %d is not closed", mock.id)
+		}
+	}
+	if err := m.Add(context.Background(), "bucket", "a", &buf, 0, 1024, nil); err == nil {
+		t.Fatal("Add() after Close should fail")
+	}
+	// Closing twice is safe.
+	if err := m.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+}
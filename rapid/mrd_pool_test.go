@@ -2,16 +2,21 @@ package rapid
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"sync"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/raj-prince/custom-go-client-benchmark/fakegcs"
 )
 
-// mockMultiRangeDownloader is a mock implementation for testing.
+// mockMultiRangeDownloader is a mock implementation for testing. Ranges
+// complete on Wait.
 type mockMultiRangeDownloader struct {
+	mu     sync.Mutex
 	id     int
 	closed bool
 	err    error
@@ -25,6 +30,8 @@ type mockTask struct {
 }
 
 func (m *mockMultiRangeDownloader) Add(output io.Writer, offset, length int64, callback func(int64, int64, error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.closed {
 		if callback != nil {
 			callback(offset, length, fmt.Errorf("downloader is closed"))
@@ -45,24 +52,39 @@ func (m *mockMultiRangeDownloader) Add(output io.Writer, offset, length int64, c
 }
 
 func (m *mockMultiRangeDownloader) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.closed = true
 	return nil
 }
 
 func (m *mockMultiRangeDownloader) Wait() {
+	m.mu.Lock()
+	tasks := m.tasks
+	m.tasks = nil
+	m.mu.Unlock()
+
 	// Execute all pending tasks
-	for _, task := range m.tasks {
+	for _, task := range tasks {
 		if task.callback != nil {
-			task.callback(task.offset, task.length, m.err)
+			task.callback(task.offset, task.length, m.Error())
 		}
 	}
-	m.tasks = nil
 }
 
 func (m *mockMultiRangeDownloader) Error() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	return m.err
 }
 
+// pending returns the number of ranges waiting for Wait.
+func (m *mockMultiRangeDownloader) pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.tasks)
+}
+
 func (m *mockMultiRangeDownloader) GetHandle() []byte {
 	return []byte(fmt.Sprintf("handle-%d", m.id))
 }
@@ -125,6 +147,48 @@ func TestNewMRDPool(t *testing.T) {
 	}
 }
 
+func TestNewMRDPool_Endpoint(t *testing.T) {
+	server, err := fakegcs.NewServer(fakegcs.Options{})
+	if err != nil {
+		t.Fatalf("fakegcs.NewServer() error = %v", err)
+	}
+	defer server.Close()
+	data := server.PutRandomObject("test-bucket", "test-object", 64*1024)
+
+	pool, err := NewMRDPool(&MRDPoolConfig{
+		PoolSize: 2,
+		Endpoint: server.GRPCEndpoint(),
+		Bucket:   "test-bucket",
+		Object:   "test-object",
+	})
+	if err != nil {
+		t.Fatalf("NewMRDPool() error = %v", err)
+	}
+
+	bufs := make([]bytes.Buffer, 4)
+	errs := make([]error, len(bufs))
+	for i := range bufs {
+		i := i
+		if err := pool.Add(context.Background(), &bufs[i], int64(i*1024), 1024, func(_, _ int64, err error) { errs[i] = err }); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	pool.Wait()
+
+	for i := range bufs {
+		if errs[i] != nil {
+			t.Errorf("range %d failed: %v", i, errs[i])
+		}
+		if !bytes.Equal(bufs[i].Bytes(), data[i*1024:(i+1)*1024]) {
+			t.Errorf("range %d: unexpected content", i)
+		}
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
 func TestMRDPool_RoundRobin(t *testing.T) {
 	// Create a pool with mock downloaders
 	poolSize := 5
@@ -143,7 +207,7 @@ func TestMRDPool_RoundRobin(t *testing.T) {
 
 	// Make multiple requests and verify round-robin behavior
 	for i := 0; i < poolSize*3; i++ {
-		err := pool.Add(&buf, int64(i*1024), 1024, nil)
+		err := pool.Add(context.Background(), &buf, int64(i*1024), 1024, nil)
 		if err != nil {
 			t.Errorf("Add() error = %v", err)
 		}
@@ -190,7 +254,7 @@ func TestMRDPool_Close(t *testing.T) {
 
 	// Verify subsequent operations fail
 	var buf bytes.Buffer
-	err = pool.Add(&buf, 0, 1024, nil)
+	err = pool.Add(context.Background(), &buf, 0, 1024, nil)
 	if err == nil {
 		t.Error("Add() should fail on closed pool")
 	}
@@ -225,7 +289,7 @@ func TestMRDPool_ConcurrentAccess(t *testing.T) {
 		go func() {
 			for i := 0; i < numRequests; i++ {
 				var buf bytes.Buffer
-				err := pool.Add(&buf, int64(i*1024), 1024, nil)
+				err := pool.Add(context.Background(), &buf, int64(i*1024), 1024, nil)
 				if err != nil {
 					errChan <- err
 				}
@@ -262,7 +326,7 @@ func TestMRDPool_Add(t *testing.T) {
 
 	var buf bytes.Buffer
 	called := false
-	err := pool.Add(&buf, 0, 1024, func(offset, length int64, err error) {
+	err := pool.Add(context.Background(), &buf, 0, 1024, func(offset, length int64, err error) {
 		called = true
 		if offset != 0 || length != 1024 {
 			t.Errorf("Expected offset=0, length=1024, got offset=%d, length=%d", offset, length)
@@ -306,7 +370,7 @@ func TestMRDPool_GetStats(t *testing.T) {
 	// After some requests
 	var buf bytes.Buffer
 	for i := 0; i < 10; i++ {
-		pool.Add(&buf, int64(i*1024), 1024, nil)
+		pool.Add(context.Background(), &buf, int64(i*1024), 1024, nil)
 	}
 
 	stats = pool.GetStats()
@@ -337,7 +401,7 @@ func TestMRDPool_Wait(t *testing.T) {
 	var buf bytes.Buffer
 	callbackCount := 0
 	for i := 0; i < 5; i++ {
-		pool.Add(&buf, int64(i*1024), 1024, func(offset, length int64, err error) {
+		pool.Add(context.Background(), &buf, int64(i*1024), 1024, func(offset, length int64, err error) {
 			callbackCount++
 		})
 	}
@@ -392,6 +456,160 @@ func TestMRDPool_GetHandle(t *testing.T) {
 	}
 }
 
+// newMockPool returns a pool of n mock downloaders selected by policy.
+func newMockPool(n int, policy SelectionPolicy) (*MRDPool, []*mockMultiRangeDownloader) {
+	pool := &MRDPool{
+		downloaders: make([]MultiRangeDownloader, n),
+		poolSize:    n,
+		cfg:         &MRDPoolConfig{PoolSize: n, Selection: policy},
+	}
+	mocks := make([]*mockMultiRangeDownloader, n)
+	for i := range mocks {
+		mocks[i] = &mockMultiRangeDownloader{id: i}
+		pool.downloaders[i] = mocks[i]
+	}
+	pool.initSlots()
+	return pool, mocks
+}
+
+func TestParseSelectionPolicy(t *testing.T) {
+	for _, s := range []string{"round-robin", "least-outstanding", "power-of-two", "latency-weighted"} {
+		if p, err := ParseSelectionPolicy(s); err != nil || string(p) != s {
+			t.Errorf("ParseSelectionPolicy(%q) = %q, %v", s, p, err)
+		}
+	}
+	if _, err := ParseSelectionPolicy("random"); err == nil {
+		t.Error("ParseSelectionPolicy(\"random\") should fail")
+	}
+	if _, err := NewMRDPool(&MRDPoolConfig{PoolSize: 1, Client: &storage.Client{}, Bucket: "b", Object: "o", Selection: "random"}); err == nil {
+		t.Error("NewMRDPool() with an unknown selection policy should fail")
+	}
+}
+
+func TestMRDPool_LoadTracking(t *testing.T) {
+	pool, mocks := newMockPool(2, SelectRoundRobin)
+
+	var buf bytes.Buffer
+	completed := 0
+	for i := 0; i < 4; i++ {
+		if err := pool.Add(context.Background(), &buf, int64(i*1024), 1024, func(_, _ int64, _ error) { completed++ }); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	stats := pool.GetStats()
+	for i, d := range stats.Downloaders {
+		if d.InFlight != 2 || d.InFlightBytes != 2048 || d.Completed != 0 {
+			t.Errorf("downloader %d before Wait: %+v, want 2 ranges of 2048 bytes in flight", i, d)
+		}
+	}
+
+	mocks[0].Wait()
+	stats = pool.GetStats()
+	if d := stats.Downloaders[0]; d.InFlight != 0 || d.InFlightBytes != 0 || d.Completed != 2 || d.Latency <= 0 {
+		t.Errorf("downloader 0 after Wait: %+v, want 2 completed ranges", d)
+	}
+	if d := stats.Downloaders[1]; d.InFlight != 2 {
+		t.Errorf("downloader 1: %+v, want 2 ranges in flight", d)
+	}
+	if completed != 2 {
+		t.Errorf("got %d callbacks, want 2", completed)
+	}
+}
+
+func TestMRDPool_LeastOutstanding(t *testing.T) {
+	pool, mocks := newMockPool(3, SelectLeastOutstanding)
+	// Downloader 0 has a deep queue.
+	pool.loads[0].start(1024)
+	pool.loads[0].start(1024)
+	pool.loads[0].start(1024)
+
+	var buf bytes.Buffer
+	for i := 0; i < 6; i++ {
+		if err := pool.Add(context.Background(), &buf, int64(i*1024), 1024, nil); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	// The 6 ranges even out the queues: 0, 3 and 3 more.
+	for i, want := range []int{0, 3, 3} {
+		if got := mocks[i].pending(); got != want {
+			t.Errorf("downloader %d got %d ranges, want %d", i, got, want)
+		}
+	}
+
+	// Once downloader 1 completes, it takes the next ranges.
+	mocks[1].Wait()
+	for i := 0; i < 3; i++ {
+		pool.Add(context.Background(), &buf, 0, 1024, nil)
+	}
+	if got := mocks[1].pending(); got != 3 {
+		t.Errorf("downloader 1 got %d ranges after Wait, want 3", got)
+	}
+}
+
+func TestMRDPool_PowerOfTwo(t *testing.T) {
+	pool, mocks := newMockPool(3, SelectPowerOfTwo)
+	// Downloader 0 has the most bytes in flight, so it loses every
+	// comparison: any two distinct downloaders include another one.
+	pool.loads[0].start(1 << 30)
+
+	var buf bytes.Buffer
+	for i := 0; i < 100; i++ {
+		if err := pool.Add(context.Background(), &buf, 0, 1, nil); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if got := mocks[0].pending(); got != 0 {
+		t.Errorf("the loaded downloader got %d ranges, want 0", got)
+	}
+	if mocks[1].pending()+mocks[2].pending() != 100 {
+		t.Errorf("got %d and %d ranges on the other downloaders, want 100 in total", mocks[1].pending(), mocks[2].pending())
+	}
+
+	// A pool of one always selects it.
+	single, singleMocks := newMockPool(1, SelectPowerOfTwo)
+	single.Add(context.Background(), &buf, 0, 1, nil)
+	if singleMocks[0].pending() != 1 {
+		t.Error("a pool of one downloader should select it")
+	}
+}
+
+func TestMRDPool_LatencyWeighted(t *testing.T) {
+	pool, mocks := newMockPool(3, SelectLatencyWeighted)
+	pool.loads[0].observe(100 * time.Millisecond)
+	pool.loads[1].observe(10 * time.Millisecond)
+	pool.loads[2].observe(10 * time.Millisecond)
+
+	var buf bytes.Buffer
+	for i := 0; i < 8; i++ {
+		if err := pool.Add(context.Background(), &buf, 0, 1024, nil); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	// The slow downloader is only worth it once the fast ones have 9 ranges
+	// in flight each.
+	if got := mocks[0].pending(); got != 0 {
+		t.Errorf("the slow downloader got %d ranges, want 0", got)
+	}
+	if mocks[1].pending() != 4 || mocks[2].pending() != 4 {
+		t.Errorf("got %d and %d ranges on the fast downloaders, want 4 each", mocks[1].pending(), mocks[2].pending())
+	}
+}
+
+func TestDownloaderLoad_EWMA(t *testing.T) {
+	var l downloaderLoad
+	l.observe(100 * time.Millisecond)
+	if got := l.stats().Latency; got != 100*time.Millisecond {
+		t.Fatalf("first latency: got %v, want 100ms", got)
+	}
+	l.observe(200 * time.Millisecond)
+	if got, want := l.stats().Latency, 120*time.Millisecond; got != want {
+		t.Fatalf("EWMA latency: got %v, want %v", got, want)
+	}
+}
+
 // Helper function
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && containsSubstring(s, substr))
@@ -433,7 +651,7 @@ func TestMRDPool_ConcurrentRecreation(t *testing.T) {
 	for i := 0; i < numGoroutines; i++ {
 		go func(id int) {
 			var buf bytes.Buffer
-			err := pool.Add(&buf, int64(id*1024), 1024, nil)
+			err := pool.Add(context.Background(), &buf, int64(id*1024), 1024, nil)
 			successChan <- (err == nil)
 			doneChan <- true
 		}(i)
@@ -479,7 +697,7 @@ func TestMRDPool_AddWithRetry(t *testing.T) {
 
 	// Add should eventually hit the healthy downloader through retry logic
 	var buf bytes.Buffer
-	err := pool.Add(&buf, 0, 1024, nil)
+	err := pool.Add(context.Background(), &buf, 0, 1024, nil)
 
 	// Should succeed when it hits the healthy downloader (index 1)
 	if err != nil {
@@ -490,7 +708,7 @@ func TestMRDPool_AddWithRetry(t *testing.T) {
 	successCount := 0
 	for i := 0; i < 10; i++ {
 		var testBuf bytes.Buffer
-		if pool.Add(&testBuf, int64(i*1024), 1024, nil) == nil {
+		if pool.Add(context.Background(), &testBuf, int64(i*1024), 1024, nil) == nil {
 			successCount++
 		}
 	}
@@ -526,7 +744,7 @@ func ExampleMRDPool() {
 	var buf bytes.Buffer
 	completed := false
 
-	err = pool.Add(&buf, 0, 1024, func(offset, length int64, err error) {
+	err = pool.Add(context.Background(), &buf, 0, 1024, func(offset, length int64, err error) {
 		if err != nil {
 			fmt.Printf("Download failed: %v\n", err)
 		} else {
@@ -0,0 +1,18 @@
+package rapid
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// preallocate reserves size bytes of disk space for file, without changing
+// its size. File systems without fallocate support are left sparse.
+func preallocate(file *os.File, size int64) error {
+	const keepSize = 0x1 // FALLOC_FL_KEEP_SIZE
+	err := syscall.Fallocate(int(file.Fd()), keepSize, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package rapid
+
+import "os"
+
+// preallocate sizes file to size bytes. Without fallocate the disk space is
+// not reserved, the file is sparse until written.
+func preallocate(file *os.File, size int64) error {
+	return file.Truncate(size)
+}
@@ -0,0 +1,320 @@
+package rapid
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RangePattern is the pattern of the ranges generated by a RangeGenerator.
+type RangePattern string
+
+const (
+	// PatternSequential reads the object front to back, the default.
+	PatternSequential RangePattern = "sequential"
+	// PatternUniform reads blocks of the object at uniformly random offsets.
+	PatternUniform RangePattern = "uniform"
+	// PatternZipf reads blocks of the object with a Zipfian popularity: a few
+	// hot spots get most of the reads.
+	PatternZipf RangePattern = "zipf"
+	// PatternTrace replays the ranges of a trace file.
+	PatternTrace RangePattern = "trace"
+)
+
+// ParseRangePattern returns the RangePattern named s.
+func ParseRangePattern(s string) (RangePattern, error) {
+	switch p := RangePattern(s); p {
+	case PatternSequential, PatternUniform, PatternZipf, PatternTrace:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown range pattern %q: must be %s, %s, %s or %s",
+			s, PatternSequential, PatternUniform, PatternZipf, PatternTrace)
+	}
+}
+
+// SizeDistribution is the distribution of the lengths of generated ranges.
+type SizeDistribution string
+
+const (
+	// SizesFixed makes every range IOSize long, the default.
+	SizesFixed SizeDistribution = "fixed"
+	// SizesUniform draws lengths uniformly between MinIOSize and MaxIOSize.
+	SizesUniform SizeDistribution = "uniform"
+	// SizesExponential draws lengths from an exponential distribution of mean
+	// IOSize, clamped between MinIOSize and MaxIOSize: mostly small ranges,
+	// with a long tail of large ones.
+	SizesExponential SizeDistribution = "exponential"
+)
+
+// ParseSizeDistribution returns the SizeDistribution named s.
+func ParseSizeDistribution(s string) (SizeDistribution, error) {
+	switch d := SizeDistribution(s); d {
+	case SizesFixed, SizesUniform, SizesExponential:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown size distribution %q: must be %s, %s or %s",
+			s, SizesFixed, SizesUniform, SizesExponential)
+	}
+}
+
+const defaultZipfS = 1.1
+
+// RangeGeneratorConfig configures NewRangeGenerator.
+type RangeGeneratorConfig struct {
+	// Pattern is the pattern of the ranges, PatternSequential if empty.
+	Pattern    RangePattern
+	ObjectSize int64
+
+	// IOSize is the length of the ranges, or their mean length with
+	// SizesExponential. Random offsets are aligned to it.
+	IOSize int64
+	// Sizes is the distribution of the lengths of the ranges, SizesFixed if
+	// empty. MinIOSize and MaxIOSize bound the drawn lengths: they default to
+	// 1 byte and the object size with SizesExponential, and must be set with
+	// SizesUniform.
+	Sizes     SizeDistribution
+	MinIOSize int64
+	MaxIOSize int64
+
+	// ZipfS is the skew of PatternZipf, above 1: the higher, the hotter the
+	// hot spots. 1.1 if 0.
+	ZipfS float64
+
+	// TraceFile is the file replayed by PatternTrace, one range per line as
+	// an offset and a length separated by a space or a comma. Empty lines
+	// and lines starting with # are skipped.
+	TraceFile string
+
+	// Seed seeds the random patterns and sizes, so that a run can be
+	// repeated.
+	Seed int64
+}
+
+// RangeGenerator generates the ranges read by a benchmark, endlessly: once a
+// pass over the object or the trace is done, the next one starts.
+// Generators are not safe for concurrent use.
+type RangeGenerator interface {
+	Next() Range
+}
+
+// NewRangeGenerator returns the RangeGenerator of cfg.
+func NewRangeGenerator(cfg RangeGeneratorConfig) (RangeGenerator, error) {
+	if cfg.ObjectSize <= 0 {
+		return nil, fmt.Errorf("object size must be greater than 0")
+	}
+	if cfg.Pattern == PatternTrace {
+		return newTraceGenerator(cfg.TraceFile, cfg.ObjectSize)
+	}
+
+	if cfg.IOSize <= 0 {
+		return nil, fmt.Errorf("IO size must be greater than 0")
+	}
+	sizes, err := newSizeSampler(cfg)
+	if err != nil {
+		return nil, err
+	}
+	numBlocks := (cfg.ObjectSize + cfg.IOSize - 1) / cfg.IOSize
+
+	switch cfg.Pattern {
+	case "", PatternSequential:
+		return &sequentialGenerator{size: cfg.ObjectSize, sizes: sizes}, nil
+	case PatternUniform:
+		return &blockGenerator{
+			size:      cfg.ObjectSize,
+			blockSize: cfg.IOSize,
+			sizes:     sizes,
+			block:     func() int64 { return sizes.rng.Int63n(numBlocks) },
+		}, nil
+	case PatternZipf:
+		s := cfg.ZipfS
+		if s == 0 {
+			s = defaultZipfS
+		}
+		if s <= 1 {
+			return nil, fmt.Errorf("zipf skew must be greater than 1")
+		}
+		zipf := rand.NewZipf(sizes.rng, s, 1, uint64(numBlocks-1))
+		stride := scatterStride(numBlocks)
+		return &blockGenerator{
+			size:      cfg.ObjectSize,
+			blockSize: cfg.IOSize,
+			sizes:     sizes,
+			// Scatter the popular ranks over the object, so that the hot
+			// spots are not all at its start.
+			block: func() int64 { return int64(zipf.Uint64()) * stride % numBlocks },
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown range pattern %q", cfg.Pattern)
+	}
+}
+
+// sizeSampler draws the lengths of ranges from a SizeDistribution.
+type sizeSampler struct {
+	rng          *rand.Rand
+	distribution SizeDistribution
+	mean         int64
+	min, max     int64
+}
+
+func newSizeSampler(cfg RangeGeneratorConfig) (*sizeSampler, error) {
+	s := &sizeSampler{
+		rng:          rand.New(rand.NewSource(cfg.Seed)),
+		distribution: cfg.Sizes,
+		mean:         cfg.IOSize,
+		min:          cfg.MinIOSize,
+		max:          cfg.MaxIOSize,
+	}
+	switch cfg.Sizes {
+	case "", SizesFixed:
+		s.distribution = SizesFixed
+	case SizesUniform:
+		if s.min <= 0 || s.max < s.min {
+			return nil, fmt.Errorf("uniform IO sizes need 0 < min IO size <= max IO size")
+		}
+	case SizesExponential:
+		if s.min <= 0 {
+			s.min = 1
+		}
+		if s.max <= 0 {
+			s.max = cfg.ObjectSize
+		}
+		if s.max < s.min {
+			return nil, fmt.Errorf("max IO size cannot be below min IO size")
+		}
+	default:
+		return nil, fmt.Errorf("unknown size distribution %q", cfg.Sizes)
+	}
+	return s, nil
+}
+
+// next returns the length of the next range.
+func (s *sizeSampler) next() int64 {
+	switch s.distribution {
+	case SizesUniform:
+		return s.min + s.rng.Int63n(s.max-s.min+1)
+	case SizesExponential:
+		return min(max(int64(s.rng.ExpFloat64()*float64(s.mean)), s.min), s.max)
+	default:
+		return s.mean
+	}
+}
+
+// sequentialGenerator reads the object front to back, in ranges of the drawn
+// lengths, and starts over at its end.
+type sequentialGenerator struct {
+	size   int64
+	sizes  *sizeSampler
+	offset int64
+}
+
+func (g *sequentialGenerator) Next() Range {
+	if g.offset >= g.size {
+		g.offset = 0
+	}
+	r := Range{Offset: g.offset, Length: min(g.sizes.next(), g.size-g.offset)}
+	g.offset += r.Length
+	return r
+}
+
+// blockGenerator reads ranges of the drawn lengths at the start of random
+// blocks of the object, cut at its end.
+type blockGenerator struct {
+	size      int64
+	blockSize int64
+	sizes     *sizeSampler
+	// block returns the index of the next block.
+	block func() int64
+}
+
+func (g *blockGenerator) Next() Range {
+	offset := g.block() * g.blockSize
+	return Range{Offset: offset, Length: min(g.sizes.next(), g.size-offset)}
+}
+
+// scatterStride returns a stride coprime with n, around n times the golden
+// ratio, so that rank*stride mod n maps the ranks 0..n-1 to all the blocks
+// of an object of n blocks, consecutive ranks far apart.
+func scatterStride(n int64) int64 {
+	stride := max(n*618/1000, 1)
+	for gcd(stride, n) != 1 {
+		stride++
+	}
+	return stride
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// traceGenerator replays the ranges of a trace file.
+type traceGenerator struct {
+	ranges []Range
+	next   int
+}
+
+// newTraceGenerator loads the trace file at path, checking its ranges are
+// within an object of size bytes.
+func newTraceGenerator(path string, size int64) (*traceGenerator, error) {
+	if path == "" {
+		return nil, fmt.Errorf("trace file cannot be empty")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	defer file.Close()
+
+	g := &traceGenerator{}
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseTraceLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+		if r.Offset+r.Length > size {
+			return nil, fmt.Errorf("%s:%d: range [%d, %d) past the object size %d", path, lineNum, r.Offset, r.Offset+r.Length, size)
+		}
+		g.ranges = append(g.ranges, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trace file: %w", err)
+	}
+	if len(g.ranges) == 0 {
+		return nil, fmt.Errorf("trace file %s has no ranges", path)
+	}
+	return g, nil
+}
+
+// parseTraceLine parses a trace line: an offset and a length, separated by a
+// space or a comma.
+func parseTraceLine(line string) (Range, error) {
+	fields := strings.FieldsFunc(line, func(c rune) bool { return c == ',' || c == ' ' || c == '\t' })
+	if len(fields) != 2 {
+		return Range{}, fmt.Errorf("want an offset and a length, got %q", line)
+	}
+	offset, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || offset < 0 {
+		return Range{}, fmt.Errorf("invalid offset %q", fields[0])
+	}
+	length, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || length <= 0 {
+		return Range{}, fmt.Errorf("invalid length %q", fields[1])
+	}
+	return Range{Offset: offset, Length: length}, nil
+}
+
+func (g *traceGenerator) Next() Range {
+	r := g.ranges[g.next]
+	g.next = (g.next + 1) % len(g.ranges)
+	return r
+}
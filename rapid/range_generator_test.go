@@ -0,0 +1,184 @@
+package rapid
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// nextRanges returns the next n ranges of g.
+func nextRanges(g RangeGenerator, n int) []Range {
+	ranges := make([]Range, n)
+	for i := range ranges {
+		ranges[i] = g.Next()
+	}
+	return ranges
+}
+
+func TestNewRangeGenerator_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  RangeGeneratorConfig
+	}{
+		{name: "empty object", cfg: RangeGeneratorConfig{IOSize: 10}},
+		{name: "no IO size", cfg: RangeGeneratorConfig{ObjectSize: 100}},
+		{name: "uniform sizes without bounds", cfg: RangeGeneratorConfig{ObjectSize: 100, IOSize: 10, Sizes: SizesUniform}},
+		{name: "zipf skew", cfg: RangeGeneratorConfig{ObjectSize: 100, IOSize: 10, Pattern: PatternZipf, ZipfS: 0.5}},
+		{name: "no trace file", cfg: RangeGeneratorConfig{ObjectSize: 100, Pattern: PatternTrace}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRangeGenerator(tt.cfg); err == nil {
+				t.Fatal("NewRangeGenerator() should fail")
+			}
+		})
+	}
+}
+
+func TestSequentialGenerator(t *testing.T) {
+	g, err := NewRangeGenerator(RangeGeneratorConfig{ObjectSize: 25, IOSize: 10})
+	if err != nil {
+		t.Fatalf("NewRangeGenerator() error = %v", err)
+	}
+
+	// The pass over the object starts over at its end.
+	want := []Range{{0, 10}, {10, 10}, {20, 5}, {0, 10}}
+	got := nextRanges(g, len(want))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ranges = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRandomGenerators(t *testing.T) {
+	const objectSize, ioSize = 1000, 64
+	for _, pattern := range []RangePattern{PatternUniform, PatternZipf} {
+		for _, sizes := range []SizeDistribution{SizesFixed, SizesUniform, SizesExponential} {
+			t.Run(string(pattern)+"/"+string(sizes), func(t *testing.T) {
+				cfg := RangeGeneratorConfig{
+					Pattern:    pattern,
+					ObjectSize: objectSize,
+					IOSize:     ioSize,
+					Sizes:      sizes,
+					MinIOSize:  16,
+					MaxIOSize:  128,
+					Seed:       1,
+				}
+				g, err := NewRangeGenerator(cfg)
+				if err != nil {
+					t.Fatalf("NewRangeGenerator() error = %v", err)
+				}
+				ranges := nextRanges(g, 1000)
+				for _, r := range ranges {
+					if r.Offset%ioSize != 0 || r.Length <= 0 || r.Offset+r.Length > objectSize {
+						t.Fatalf("range %v not at a block start within the object", r)
+					}
+					if sizes != SizesFixed && r.Offset+r.Length < objectSize && (r.Length < 16 || r.Length > 128) {
+						t.Fatalf("range %v length outside [16, 128]", r)
+					}
+				}
+
+				// The same seed generates the same ranges.
+				again, _ := NewRangeGenerator(cfg)
+				for i, r := range nextRanges(again, len(ranges)) {
+					if r != ranges[i] {
+						t.Fatalf("range %d = %v with the same seed, want %v", i, r, ranges[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestZipfGenerator_HotSpots(t *testing.T) {
+	g, err := NewRangeGenerator(RangeGeneratorConfig{
+		Pattern:    PatternZipf,
+		ObjectSize: 1000 * 10,
+		IOSize:     10,
+		ZipfS:      1.5,
+		Seed:       1,
+	})
+	if err != nil {
+		t.Fatalf("NewRangeGenerator() error = %v", err)
+	}
+
+	counts := make(map[int64]int)
+	for _, r := range nextRanges(g, 10000) {
+		counts[r.Offset]++
+	}
+	// A uniform pattern would read every block about 10 times.
+	hottest := 0
+	for _, c := range counts {
+		hottest = max(hottest, c)
+	}
+	if hottest < 1000 {
+		t.Fatalf("hottest block read %d times, want a hot spot", hottest)
+	}
+	if counts[0] == hottest && counts[10] > 100 {
+		t.Fatal("hot spots are not scattered over the object")
+	}
+}
+
+func TestZipfGenerator_SingleBlock(t *testing.T) {
+	g, err := NewRangeGenerator(RangeGeneratorConfig{Pattern: PatternZipf, ObjectSize: 5, IOSize: 10})
+	if err != nil {
+		t.Fatalf("NewRangeGenerator() error = %v", err)
+	}
+	if r := g.Next(); r != (Range{0, 5}) {
+		t.Fatalf("Next() = %v, want the whole object", r)
+	}
+}
+
+func TestScatterStride(t *testing.T) {
+	for _, n := range []int64{1, 2, 10, 97, 1000} {
+		seen := make(map[int64]bool)
+		stride := scatterStride(n)
+		for rank := int64(0); rank < n; rank++ {
+			seen[rank*stride%n] = true
+		}
+		if int64(len(seen)) != n {
+			t.Fatalf("scatterStride(%d) = %d maps the ranks to %d blocks", n, stride, len(seen))
+		}
+	}
+}
+
+func TestTraceGenerator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.txt")
+	trace := "# offset length\n0 10\n\n50,20\n  30\t5  \n"
+	if err := os.WriteFile(path, []byte(trace), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewRangeGenerator(RangeGeneratorConfig{Pattern: PatternTrace, ObjectSize: 100, TraceFile: path})
+	if err != nil {
+		t.Fatalf("NewRangeGenerator() error = %v", err)
+	}
+	// The trace is replayed again once done.
+	want := []Range{{0, 10}, {50, 20}, {30, 5}, {0, 10}}
+	got := nextRanges(g, len(want))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ranges = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTraceGenerator_Invalid(t *testing.T) {
+	for name, trace := range map[string]string{
+		"empty":           "# no ranges\n",
+		"missing length":  "0\n",
+		"negative offset": "-1 10\n",
+		"past the end":    "95 10\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "trace.txt")
+			if err := os.WriteFile(path, []byte(trace), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewRangeGenerator(RangeGeneratorConfig{Pattern: PatternTrace, ObjectSize: 100, TraceFile: path}); err == nil {
+				t.Fatal("NewRangeGenerator() should fail")
+			}
+		})
+	}
+}
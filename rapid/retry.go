@@ -0,0 +1,228 @@
+package rapid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInitialBackoff    = 100 * time.Millisecond
+	defaultMaxBackoff        = 10 * time.Second
+	defaultBackoffMultiplier = 2
+)
+
+// validateRetry checks the retry settings of config.
+func validateRetry(config *MRDPoolConfig) error {
+	if config.MaxAttempts < 0 {
+		return fmt.Errorf("max attempts cannot be negative")
+	}
+	if config.InitialBackoff < 0 || config.MaxBackoff < 0 {
+		return fmt.Errorf("backoff cannot be negative")
+	}
+	if config.BackoffMultiplier != 0 && config.BackoffMultiplier < 1 {
+		return fmt.Errorf("backoff multiplier must be at least 1")
+	}
+	return nil
+}
+
+// maxAttempts returns the number of attempts of a range, at least 1.
+func (p *MRDPool) maxAttempts() int {
+	if p.cfg == nil || p.cfg.MaxAttempts < 1 {
+		return 1
+	}
+	return p.cfg.MaxAttempts
+}
+
+// backoff returns the backoff before the attempt following attempt, with
+// jitter: a random duration in the upper half of the exponential backoff, so
+// that the ranges failed together by a broken stream are not retried together.
+func (p *MRDPool) backoff(attempt int) time.Duration {
+	initial, maxBackoff, multiplier := defaultInitialBackoff, defaultMaxBackoff, float64(defaultBackoffMultiplier)
+	if p.cfg.InitialBackoff > 0 {
+		initial = p.cfg.InitialBackoff
+	}
+	if p.cfg.MaxBackoff > 0 {
+		maxBackoff = p.cfg.MaxBackoff
+	}
+	if p.cfg.BackoffMultiplier > 0 {
+		multiplier = p.cfg.BackoffMultiplier
+	}
+
+	backoff := float64(initial)
+	for i := 1; i < attempt && backoff < float64(maxBackoff); i++ {
+		backoff *= multiplier
+	}
+	backoff = min(backoff, float64(maxBackoff))
+	return time.Duration(backoff/2 + rand.Float64()*backoff/2)
+}
+
+// add implements Add without hedging. A range failing mid-flight is added
+// again, after a backoff, up to MaxAttempts attempts; the selection policy
+// picks its downloader again, recreating it if it is broken. Canceled ranges
+// are not retried.
+func (p *MRDPool) add(ctx context.Context, output io.Writer, offset, length int64, callback func(int64, int64, error)) error {
+	p.initSlots()
+	if p.maxAttempts() == 1 {
+		return p.addAttempt(ctx, output, offset, length, callback)
+	}
+
+	r := &rangeRetry{
+		p:        p,
+		ctx:      ctx,
+		output:   newAttemptOutput(output),
+		offset:   offset,
+		length:   length,
+		callback: callback,
+	}
+	// The range is tracked until finish reports it, see Wait.
+	p.pending.Add(1)
+	if err := r.start(); err != nil {
+		p.pending.Done()
+		return err
+	}
+	return nil
+}
+
+// rangeRetry is a range retried by add.
+type rangeRetry struct {
+	p        *MRDPool
+	ctx      context.Context
+	output   attemptOutput
+	offset   int64
+	length   int64
+	callback func(int64, int64, error)
+	// attempts is the number of attempts started.
+	attempts int
+}
+
+// start starts the next attempt of the range.
+func (r *rangeRetry) start() error {
+	r.attempts++
+	return r.p.addAttempt(r.ctx, r.output, r.offset, r.length, r.done)
+}
+
+// done is the callback of an attempt. It retries the range if the attempt
+// failed, or reports it.
+func (r *rangeRetry) done(offset, n int64, err error) {
+	if err == nil || errors.Is(err, ErrRangeCanceled) || r.attempts >= r.p.maxAttempts() {
+		r.finish(offset, n, err)
+		return
+	}
+
+	// The failed attempt no longer writes, its partial data is dropped.
+	r.output.reset()
+	backoff := r.p.backoff(r.attempts)
+	go func() {
+		timer := time.NewTimer(backoff)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.ctx.Done():
+			r.finish(offset, 0, rangeCanceled(r.ctx))
+			return
+		}
+
+		if err := r.start(); err != nil {
+			r.finish(offset, 0, err)
+		}
+	}()
+}
+
+// finish reports the range, after its last attempt, to the callback.
+func (r *rangeRetry) finish(offset, n int64, err error) {
+	defer r.p.pending.Done()
+	r.p.attempts[r.attempts-1].Add(1)
+	if err == nil {
+		err = r.output.commit()
+	} else {
+		r.output.reset()
+	}
+	if err != nil && r.attempts > 1 && !errors.Is(err, ErrRangeCanceled) {
+		err = fmt.Errorf("range failed after %d attempts: %w", r.attempts, err)
+	}
+	if r.callback != nil {
+		r.callback(offset, n, err)
+	}
+}
+
+// attemptOutput is the output of the attempts of a range. The partial data
+// of a failed attempt is dropped on reset, the data of the successful one is
+// written to the range output on commit.
+type attemptOutput interface {
+	io.Writer
+	reset()
+	commit() error
+}
+
+// newAttemptOutput returns the attemptOutput writing to output.
+func newAttemptOutput(output io.Writer) attemptOutput {
+	switch o := output.(type) {
+	case nil:
+		return discardOutput{io.Discard}
+	case truncater:
+		return &truncatingOutput{truncater: o, start: o.Len()}
+	}
+	if output == io.Discard {
+		return discardOutput{output}
+	}
+	return &bufferedOutput{output: output}
+}
+
+// truncater is an output that can drop data written last, e.g. a
+// bytes.Buffer.
+type truncater interface {
+	io.Writer
+	Len() int
+	Truncate(n int)
+}
+
+// truncatingOutput writes to a truncater directly, and truncates it back to
+// its length before the range on reset.
+type truncatingOutput struct {
+	truncater
+	start int
+}
+
+func (o *truncatingOutput) reset()        { o.Truncate(o.start) }
+func (o *truncatingOutput) commit() error { return nil }
+
+// discardOutput drops all data, so there is nothing to reset.
+type discardOutput struct {
+	io.Writer
+}
+
+func (discardOutput) reset()        {}
+func (discardOutput) commit() error { return nil }
+
+// bufferedOutput buffers the data of an attempt, for outputs that can't be
+// reset. It takes the length of the range in memory.
+type bufferedOutput struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	output io.Writer
+}
+
+func (o *bufferedOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *bufferedOutput) reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.buf.Reset()
+}
+
+func (o *bufferedOutput) commit() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, err := o.output.Write(o.buf.Bytes())
+	return err
+}
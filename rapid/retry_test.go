@@ -0,0 +1,217 @@
+package rapid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyDownloader fails the first failures ranges of all flakyDownloaders
+// sharing it mid-flight, after writing half of their data, and fills the
+// others with fill.
+type flakyDownloader struct {
+	mockMultiRangeDownloader
+	failures *atomic.Int32
+	fill     byte
+}
+
+func (d *flakyDownloader) Add(output io.Writer, offset, length int64, callback func(int64, int64, error)) {
+	go func() {
+		if d.failures.Add(-1) >= 0 {
+			n, _ := output.Write(bytes.Repeat([]byte{0xff}, int(length/2)))
+			callback(offset, int64(n), errors.New("stream broken"))
+			return
+		}
+		n, err := output.Write(bytes.Repeat([]byte{d.fill}, int(length)))
+		callback(offset, int64(n), err)
+	}()
+}
+
+func newFlakyPool(failures int32, cfg MRDPoolConfig) *MRDPool {
+	counter := &atomic.Int32{}
+	counter.Store(failures)
+	cfg.PoolSize = 2
+	return &MRDPool{
+		downloaders: []MultiRangeDownloader{
+			&flakyDownloader{failures: counter, fill: 1},
+			&flakyDownloader{failures: counter, fill: 1},
+		},
+		poolSize: 2,
+		cfg:      &cfg,
+	}
+}
+
+// addAndWait adds a range of 16 bytes to pool and returns its callback
+// arguments.
+func addAndWait(t *testing.T, ctx context.Context, pool *MRDPool, output io.Writer) (int64, error) {
+	t.Helper()
+	type result struct {
+		n   int64
+		err error
+	}
+	done := make(chan result, 1)
+	if err := pool.Add(ctx, output, 0, 16, func(_, n int64, err error) { done <- result{n, err} }); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	select {
+	case r := <-done:
+		return r.n, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("range not reported")
+		return 0, nil
+	}
+}
+
+// lockedWriter is an output that can't be reset.
+type lockedWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func TestValidateRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		config MRDPoolConfig
+	}{
+		{name: "negative attempts", config: MRDPoolConfig{MaxAttempts: -1}},
+		{name: "negative backoff", config: MRDPoolConfig{MaxAttempts: 3, InitialBackoff: -time.Second}},
+		{name: "shrinking backoff", config: MRDPoolConfig{MaxAttempts: 3, BackoffMultiplier: 0.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRetry(&tt.config); err == nil {
+				t.Fatal("validateRetry() should fail")
+			}
+		})
+	}
+}
+
+func TestMRDPool_Retry(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int32
+		maxAttempts  int
+		wantErr      bool
+		wantAttempts []uint64
+	}{
+		{name: "no failure", failures: 0, maxAttempts: 3, wantAttempts: []uint64{1, 0, 0}},
+		{name: "retried", failures: 2, maxAttempts: 3, wantAttempts: []uint64{0, 0, 1}},
+		{name: "out of attempts", failures: 3, maxAttempts: 3, wantErr: true, wantAttempts: []uint64{0, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newFlakyPool(tt.failures, MRDPoolConfig{MaxAttempts: tt.maxAttempts, InitialBackoff: time.Millisecond})
+
+			// The buffer holds data before the range, kept across attempts.
+			buf := bytes.NewBufferString("head")
+			n, err := addAndWait(t, context.Background(), pool, buf)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+					t.Fatalf("callback error = %v, want a failure after 3 attempts", err)
+				}
+				if buf.String() != "head" {
+					t.Fatalf("output = %q, want the partial data dropped", buf.String())
+				}
+			} else {
+				if err != nil || n != 16 {
+					t.Fatalf("callback got n=%d, err=%v, want 16 bytes", n, err)
+				}
+				if want := "head" + strings.Repeat("\x01", 16); buf.String() != want {
+					t.Fatalf("output = %q, want %q", buf.String(), want)
+				}
+			}
+
+			stats := pool.GetStats()
+			if len(stats.Attempts) != len(tt.wantAttempts) {
+				t.Fatalf("attempts = %v, want %v", stats.Attempts, tt.wantAttempts)
+			}
+			for i := range tt.wantAttempts {
+				if stats.Attempts[i] != tt.wantAttempts[i] {
+					t.Fatalf("attempts = %v, want %v", stats.Attempts, tt.wantAttempts)
+				}
+			}
+		})
+	}
+}
+
+func TestMRDPool_RetryBufferedOutput(t *testing.T) {
+	pool := newFlakyPool(1, MRDPoolConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+
+	// An output that can't be truncated only gets the successful attempt.
+	var w lockedWriter
+	if _, err := addAndWait(t, context.Background(), pool, &w); err != nil {
+		t.Fatalf("callback error = %v", err)
+	}
+	if want := strings.Repeat("\x01", 16); w.buf.String() != want {
+		t.Fatalf("output = %q, want %q", w.buf.String(), want)
+	}
+	if stats := pool.GetStats(); stats.Retries != 1 {
+		t.Fatalf("retries = %d, want 1", stats.Retries)
+	}
+}
+
+func TestMRDPool_RetryCanceled(t *testing.T) {
+	pool := newFlakyPool(1, MRDPoolConfig{MaxAttempts: 3, InitialBackoff: time.Hour})
+
+	// The range is canceled while waiting for the backoff of its retry.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var buf bytes.Buffer
+	if _, err := addAndWait(t, ctx, pool, &buf); !errors.Is(err, ErrRangeCanceled) {
+		t.Fatalf("callback error = %v, want ErrRangeCanceled", err)
+	}
+}
+
+func TestMRDPool_Backoff(t *testing.T) {
+	pool := &MRDPool{cfg: &MRDPoolConfig{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, BackoffMultiplier: 2}}
+
+	for _, tt := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 100 * time.Millisecond},
+		{attempt: 2, want: 200 * time.Millisecond},
+		{attempt: 4, want: 800 * time.Millisecond},
+		{attempt: 10, want: time.Second},
+	} {
+		for i := 0; i < 100; i++ {
+			if got := pool.backoff(tt.attempt); got < tt.want/2 || got > tt.want {
+				t.Fatalf("backoff(%d) = %v, want in [%v, %v]", tt.attempt, got, tt.want/2, tt.want)
+			}
+		}
+	}
+}
+
+func TestMRDPool_WaitDuringBackoff(t *testing.T) {
+	pool := newFlakyPool(1, MRDPoolConfig{MaxAttempts: 2, InitialBackoff: 200 * time.Millisecond})
+
+	var reported atomic.Bool
+	if err := pool.Add(context.Background(), &bytes.Buffer{}, 0, 16, func(_, _ int64, err error) {
+		if err != nil {
+			t.Errorf("callback error = %v", err)
+		}
+		reported.Store(true)
+	}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	// The first attempt fails right away, Wait is called while the retry
+	// backs off and the downloaders are idle.
+	time.Sleep(20 * time.Millisecond)
+	pool.Wait()
+	if !reported.Load() {
+		t.Fatal("Wait() returned before the retried range was reported")
+	}
+}
@@ -0,0 +1,173 @@
+package rapid
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// SelectionPolicy selects the downloader of the pool a range is added to.
+type SelectionPolicy string
+
+const (
+	// SelectRoundRobin cycles through the downloaders, the default.
+	SelectRoundRobin SelectionPolicy = "round-robin"
+	// SelectLeastOutstanding picks the downloader with the fewest ranges in
+	// flight.
+	SelectLeastOutstanding SelectionPolicy = "least-outstanding"
+	// SelectPowerOfTwo picks two random downloaders and keeps the one with
+	// the fewest bytes in flight.
+	SelectPowerOfTwo SelectionPolicy = "power-of-two"
+	// SelectLatencyWeighted picks the downloader with the lowest EWMA
+	// completion latency times its ranges in flight plus one, i.e. the
+	// shortest expected wait.
+	SelectLatencyWeighted SelectionPolicy = "latency-weighted"
+)
+
+// ParseSelectionPolicy returns the SelectionPolicy named s.
+func ParseSelectionPolicy(s string) (SelectionPolicy, error) {
+	switch p := SelectionPolicy(s); p {
+	case SelectRoundRobin, SelectLeastOutstanding, SelectPowerOfTwo, SelectLatencyWeighted:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown selection policy %q: must be %s, %s, %s or %s",
+			s, SelectRoundRobin, SelectLeastOutstanding, SelectPowerOfTwo, SelectLatencyWeighted)
+	}
+}
+
+// latencyEWMAWeight is the weight of the latest completion in the EWMA
+// latency of a downloader.
+const latencyEWMAWeight = 0.2
+
+// downloaderLoad tracks the ranges of one downloader through the Add
+// callbacks. It belongs to the pool slot, not to the downloader, so it
+// carries over when the downloader is recreated.
+type downloaderLoad struct {
+	// lastUsed is the Unix time in nanoseconds of the latest range.
+	lastUsed      atomic.Int64
+	inFlight      atomic.Int64
+	inFlightBytes atomic.Int64
+	completed     atomic.Uint64
+	// ewmaLatency is the EWMA completion latency in nanoseconds, 0 until the
+	// first completion.
+	ewmaLatency atomic.Int64
+}
+
+func newDownloaderLoad(now time.Time) *downloaderLoad {
+	l := &downloaderLoad{}
+	l.lastUsed.Store(now.UnixNano())
+	return l
+}
+
+// start notes a range of length bytes added to the downloader.
+func (l *downloaderLoad) start(length int64) {
+	l.lastUsed.Store(time.Now().UnixNano())
+	l.inFlight.Add(1)
+	l.inFlightBytes.Add(length)
+}
+
+// cancel undoes start, for a range that was not added after all.
+func (l *downloaderLoad) cancel(length int64) {
+	l.inFlight.Add(-1)
+	l.inFlightBytes.Add(-length)
+}
+
+// done notes the completion of a range of length bytes after latency.
+func (l *downloaderLoad) done(length int64, latency time.Duration) {
+	l.inFlight.Add(-1)
+	l.inFlightBytes.Add(-length)
+	l.completed.Add(1)
+	l.observe(latency)
+}
+
+// observe folds latency into the EWMA latency.
+func (l *downloaderLoad) observe(latency time.Duration) {
+	for {
+		old := l.ewmaLatency.Load()
+		next := int64(latency)
+		if old != 0 {
+			next = int64(latencyEWMAWeight*float64(latency) + (1-latencyEWMAWeight)*float64(old))
+		}
+		if l.ewmaLatency.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// DownloaderStats are the statistics of one downloader of the pool.
+type DownloaderStats struct {
+	// Index is the slot of the downloader in the pool.
+	Index         int
+	InFlight      int64
+	InFlightBytes int64
+	Completed     uint64
+	// Latency is the EWMA completion latency of the ranges.
+	Latency time.Duration
+}
+
+func (l *downloaderLoad) stats() DownloaderStats {
+	return DownloaderStats{
+		InFlight:      l.inFlight.Load(),
+		InFlightBytes: l.inFlightBytes.Load(),
+		Completed:     l.completed.Load(),
+		Latency:       time.Duration(l.ewmaLatency.Load()),
+	}
+}
+
+// selectIndex returns the index of the open downloader to add the next range
+// to. next is the request counter, the round-robin position; ties between
+// downloaders are broken in round-robin order from it. It must be called
+// with p.mu held.
+func (p *MRDPool) selectIndex(next uint64) int {
+	n := len(p.active)
+	policy := SelectRoundRobin
+	if p.cfg != nil && p.cfg.Selection != "" {
+		policy = p.cfg.Selection
+	}
+
+	switch policy {
+	case SelectLeastOutstanding:
+		return p.minIndex(next, func(l *downloaderLoad) float64 {
+			return float64(l.inFlight.Load())
+		})
+
+	case SelectPowerOfTwo:
+		if n == 1 {
+			return p.active[0]
+		}
+		i := rand.Intn(n)
+		j := rand.Intn(n - 1)
+		if j >= i {
+			j++
+		}
+		a, b := p.active[i], p.active[j]
+		if p.loads[b].inFlightBytes.Load() < p.loads[a].inFlightBytes.Load() {
+			return b
+		}
+		return a
+
+	case SelectLatencyWeighted:
+		return p.minIndex(next, func(l *downloaderLoad) float64 {
+			return float64(l.ewmaLatency.Load()) * float64(l.inFlight.Load()+1)
+		})
+
+	default:
+		return p.active[next%uint64(n)]
+	}
+}
+
+// minIndex returns the index of the downloader with the lowest cost, the
+// first one in round-robin order from next on ties.
+func (p *MRDPool) minIndex(next uint64, cost func(*downloaderLoad) float64) int {
+	n := len(p.active)
+	best, bestCost := -1, math.Inf(1)
+	for i := 0; i < n; i++ {
+		index := p.active[(next+uint64(i))%uint64(n)]
+		if c := cost(p.loads[index]); c < bestCost {
+			best, bestCost = index, c
+		}
+	}
+	return best
+}
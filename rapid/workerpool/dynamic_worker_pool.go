@@ -0,0 +1,344 @@
+package workerpool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// dynamicWorkerPool starts workers (goroutines) on demand, when tasks are
+// waiting and no worker is idle, up to a maximum, and retires the workers
+// idle for longer than a keep-alive. Like staticWorkerPool, it keeps two types
+// of workers - priority and normal. Priority workers will only execute tasks
+// that are marked as urgent while scheduling. Normal workers will execute both
+// urgent and normal tasks, but gives precedence to urgent task. Urgent tasks
+// start priority workers first, then normal ones.
+type dynamicWorkerPool struct {
+	maxPriorityWorker uint32 // Maximum number of priority workers in this pool.
+	maxNormalWorker   uint32 // Maximum number of normal workers in this pool.
+	keepAlive         time.Duration
+
+	// Wait group to wait for all workers to finish.
+	wg sync.WaitGroup
+
+	// Stop and task channels, with their statistics.
+	taskQueues
+
+	mu sync.Mutex
+	// started is set by Start, workers are started from then on, and until
+	// stopped is set by Stop, or draining by Drain.
+	started  bool
+	stopped  bool
+	draining bool
+	// Running workers, and the ones of them waiting for a task, by type.
+	priorityWorker, normalWorker         uint32
+	idlePriorityWorker, idleNormalWorker uint32
+	// spawned counts the workers started so far, for tests and statistics.
+	spawned uint64
+}
+
+// NewDynamicWorkerPool creates a new dynamic worker pool, running up to
+// maxPriorityWorker priority and maxNormalWorker normal workers. Workers idle
+// for keepAlive are retired.
+func NewDynamicWorkerPool(maxPriorityWorker uint32, maxNormalWorker uint32, keepAlive time.Duration, readGlobalMaxBlocks int64) (*dynamicWorkerPool, error) {
+	totalWorkers := maxPriorityWorker + maxNormalWorker
+	if totalWorkers == 0 {
+		return nil, fmt.Errorf("dynamicWorkerPool: can't create with 0 workers, priority: %d, normal: %d", maxPriorityWorker, maxNormalWorker)
+	}
+	if keepAlive <= 0 {
+		return nil, fmt.Errorf("dynamicWorkerPool: keep-alive must be positive, got %v", keepAlive)
+	}
+
+	fmt.Printf("dynamicWorkerPool: creating with up to %d normal, and %d priority workers, keep-alive %v.\n", maxNormalWorker, maxPriorityWorker, keepAlive)
+
+	// The channels are sized as the ones of staticWorkerPool, from the
+	// maximum number of workers. Without priority workers, urgent tasks
+	// still need room to wait for the normal ones.
+	priorityChSize := min(int(max(maxPriorityWorker, 1))*200, int(2*readGlobalMaxBlocks))
+	normalChSize := min(int(maxNormalWorker)*5000, int(2*readGlobalMaxBlocks))
+	return &dynamicWorkerPool{
+		maxPriorityWorker: maxPriorityWorker,
+		maxNormalWorker:   maxNormalWorker,
+		keepAlive:         keepAlive,
+		taskQueues:        newTaskQueues(priorityChSize, normalChSize),
+	}, nil
+}
+
+// Start lets the pool start workers, for the tasks already scheduled and the
+// next ones.
+func (dwp *dynamicWorkerPool) Start() {
+	dwp.mu.Lock()
+	defer dwp.mu.Unlock()
+	dwp.started = true
+	dwp.spawnLocked()
+}
+
+// Stop all the workers threads and wait for them to finish processing.
+func (dwp *dynamicWorkerPool) Stop() {
+	// Later calls wait for the first one to complete.
+	dwp.stopOnce.Do(func() {
+		// Notify all workers to stop, no worker is started afterwards.
+		fmt.Printf("dynamicWorkerPool: stopping all the workers.\n")
+		dwp.mu.Lock()
+		dwp.stopped = true
+		dwp.mu.Unlock()
+		close(dwp.stop)
+
+		dwp.wg.Wait()
+
+		// Close the channel after all workers are done.
+		if discarded := dwp.closeQueues(); discarded > 0 {
+			fmt.Printf("dynamicWorkerPool: discarded %d queued tasks.\n", discarded)
+		}
+	})
+}
+
+// Drain lets the workers execute the queued tasks and exit, then stops the
+// pool. Workers are started for the queued tasks, up to the maximums, but not
+// afterwards. If ctx is done first, the pool is stopped right away: the
+// running tasks are waited for, and the queued ones discarded.
+func (dwp *dynamicWorkerPool) Drain(ctx context.Context) error {
+	dwp.drainOnce.Do(func() {
+		fmt.Printf("dynamicWorkerPool: draining the queued tasks.\n")
+		dwp.mu.Lock()
+		dwp.spawnLocked()
+		dwp.draining = true
+		dwp.mu.Unlock()
+		close(dwp.drain)
+	})
+
+	drained := make(chan struct{})
+	go func() {
+		dwp.wg.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	dwp.Stop()
+	return err
+}
+
+// OnDiscard sets the callback called with the tasks discarded by Stop, or
+// dropped by Schedule once the pool is stopping.
+func (dwp *dynamicWorkerPool) OnDiscard(cb func(urgent bool, task Task)) {
+	dwp.setOnDiscard(cb)
+}
+
+// OnError sets the callback called with the tasks that failed or panicked.
+func (dwp *dynamicWorkerPool) OnError(cb func(urgent bool, task Task, err error)) {
+	dwp.setOnError(cb)
+}
+
+// Schedule schedules tasks to the worker pool, starting a worker if none is
+// idle. Pass urgent as true for priority scheduling.
+func (dwp *dynamicWorkerPool) Schedule(urgent bool, task Task) {
+	dwp.enqueue(urgent, task)
+	dwp.spawn()
+}
+
+// TrySchedule schedules tasks to the worker pool like Schedule, unless their
+// queue is full or the pool is stopped.
+func (dwp *dynamicWorkerPool) TrySchedule(urgent bool, task Task) error {
+	if err := dwp.trySchedule(urgent, task); err != nil {
+		return err
+	}
+	dwp.spawn()
+	return nil
+}
+
+// ScheduleContext schedules tasks to the worker pool like Schedule, waiting
+// for room in their queue until ctx is done or the pool is stopped.
+func (dwp *dynamicWorkerPool) ScheduleContext(ctx context.Context, urgent bool, task Task) error {
+	if err := dwp.scheduleContext(ctx, urgent, task); err != nil {
+		return err
+	}
+	dwp.spawn()
+	return nil
+}
+
+// Stats returns the live statistics of the worker pool.
+func (dwp *dynamicWorkerPool) Stats() Stats {
+	stats := dwp.stats()
+	priority, normal := dwp.workers()
+	stats.Workers = int(priority + normal)
+	return stats
+}
+
+// spawn starts workers for the waiting tasks, see spawnLocked.
+func (dwp *dynamicWorkerPool) spawn() {
+	dwp.mu.Lock()
+	defer dwp.mu.Unlock()
+	dwp.spawnLocked()
+}
+
+// spawnLocked starts workers until there are as many idle workers as waiting
+// tasks they can execute, or the maximums are reached. dwp.mu must be held.
+func (dwp *dynamicWorkerPool) spawnLocked() {
+	if !dwp.started || dwp.stopped || dwp.draining {
+		return
+	}
+
+	// Urgent tasks are executed by idle workers of both types.
+	for len(dwp.priorityCh) > int(dwp.idlePriorityWorker+dwp.idleNormalWorker) {
+		if dwp.priorityWorker < dwp.maxPriorityWorker {
+			dwp.spawnWorkerLocked(true)
+		} else if dwp.normalWorker < dwp.maxNormalWorker {
+			dwp.spawnWorkerLocked(false)
+		} else {
+			break
+		}
+	}
+
+	// Normal tasks only by idle normal workers, the ones not taken by the
+	// urgent tasks.
+	idleNormal := int(dwp.idleNormalWorker) - max(len(dwp.priorityCh)-int(dwp.idlePriorityWorker), 0)
+	for len(dwp.normalCh) > idleNormal && dwp.normalWorker < dwp.maxNormalWorker {
+		dwp.spawnWorkerLocked(false)
+		idleNormal++
+	}
+}
+
+// spawnWorkerLocked starts a worker, idle until it takes a task. dwp.mu must
+// be held.
+func (dwp *dynamicWorkerPool) spawnWorkerLocked(priority bool) {
+	if priority {
+		dwp.priorityWorker++
+		dwp.idlePriorityWorker++
+	} else {
+		dwp.normalWorker++
+		dwp.idleNormalWorker++
+	}
+	dwp.spawned++
+	dwp.wg.Add(1)
+	go dwp.do(priority)
+}
+
+// setIdle counts a worker of the given type as idle or not. A worker taking
+// a task may leave tasks waiting without an idle worker, so it starts more
+// workers if needed.
+func (dwp *dynamicWorkerPool) setIdle(priority bool, idle bool) {
+	dwp.mu.Lock()
+	defer dwp.mu.Unlock()
+	counter := &dwp.idleNormalWorker
+	if priority {
+		counter = &dwp.idlePriorityWorker
+	}
+	if idle {
+		*counter++
+	} else {
+		*counter--
+		dwp.spawnLocked()
+	}
+}
+
+// retire stops counting an idle worker of the given type, unless tasks it can
+// execute are waiting. It reports whether the worker retired.
+func (dwp *dynamicWorkerPool) retire(priority bool) bool {
+	dwp.mu.Lock()
+	defer dwp.mu.Unlock()
+	if len(dwp.priorityCh) > 0 || (!priority && len(dwp.normalCh) > 0) {
+		return false
+	}
+	dwp.exitLocked(priority)
+	return true
+}
+
+// exitLocked stops counting an idle worker of the given type. dwp.mu must be
+// held.
+func (dwp *dynamicWorkerPool) exitLocked(priority bool) {
+	if priority {
+		dwp.priorityWorker--
+		dwp.idlePriorityWorker--
+	} else {
+		dwp.normalWorker--
+		dwp.idleNormalWorker--
+	}
+}
+
+// drainQueued executes the queued tasks once the pool is draining, then
+// stops counting the worker.
+func (dwp *dynamicWorkerPool) drainQueued(priority bool) {
+	dwp.drainQueues(priority, func(urgent bool, task Task) {
+		dwp.execute(priority, urgent, task)
+	})
+	dwp.mu.Lock()
+	defer dwp.mu.Unlock()
+	dwp.exitLocked(priority)
+}
+
+// workers returns the number of running priority and normal workers.
+func (dwp *dynamicWorkerPool) workers() (priority, normal uint32) {
+	dwp.mu.Lock()
+	defer dwp.mu.Unlock()
+	return dwp.priorityWorker, dwp.normalWorker
+}
+
+// execute runs task, taken from the lane of urgent, the worker not being idle
+// meanwhile.
+func (dwp *dynamicWorkerPool) execute(priority bool, urgent bool, task Task) {
+	dwp.setIdle(priority, false)
+	dwp.run(urgent, task)
+	dwp.setIdle(priority, true)
+}
+
+// do is the core routine that runs in each worker thread.
+// It will keep listening to the channel for tasks and execute them, until
+// the pool is stopped or the worker is idle for the keep-alive.
+func (dwp *dynamicWorkerPool) do(priority bool) {
+	defer dwp.wg.Done()
+
+	timer := time.NewTimer(dwp.keepAlive)
+	defer timer.Stop()
+	resetTimer := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(dwp.keepAlive)
+	}
+
+	// A nil channel is never ready: priority workers don't take normal
+	// tasks.
+	normalCh := dwp.normalCh
+	if priority {
+		normalCh = nil
+	}
+	for {
+		// Give precedence to the priority channel.
+		select {
+		case <-dwp.stop:
+			return
+		case task := <-dwp.priorityCh:
+			dwp.execute(priority, true, task)
+			resetTimer()
+			continue
+		default:
+		}
+
+		select {
+		case <-dwp.stop:
+			return
+		case <-dwp.drain:
+			dwp.drainQueued(priority)
+			return
+		case task := <-dwp.priorityCh:
+			dwp.execute(priority, true, task)
+			resetTimer()
+		case task := <-normalCh:
+			dwp.execute(priority, false, task)
+			resetTimer()
+		case <-timer.C:
+			if dwp.retire(priority) {
+				return
+			}
+			timer.Reset(dwp.keepAlive)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingTask blocks until release is closed, and records the order it was
+// executed in.
+type blockingTask struct {
+	release  chan struct{}
+	executed atomic.Bool
+	order    *[]string
+	orderMu  *sync.Mutex
+	name     string
+}
+
+func (b *blockingTask) Execute() {
+	if b.order != nil {
+		b.orderMu.Lock()
+		*b.order = append(*b.order, b.name)
+		b.orderMu.Unlock()
+	}
+	<-b.release
+	b.executed.Store(true)
+}
+
+func TestNewDynamicWorkerPool_Failure(t *testing.T) {
+	pool, err := NewDynamicWorkerPool(0, 0, time.Second, 10)
+	assert.Error(t, err)
+	assert.Nil(t, pool)
+
+	pool, err = NewDynamicWorkerPool(1, 1, 0, 10)
+	assert.Error(t, err)
+	assert.Nil(t, pool)
+}
+
+func TestDynamicWorkerPool_SpawnOnDemand(t *testing.T) {
+	pool, err := NewDynamicWorkerPool(2, 3, time.Minute, 100)
+	require.NoError(t, err)
+	pool.Start()
+	defer pool.Stop()
+
+	priority, normal := pool.workers()
+	assert.Equal(t, uint32(0), priority+normal, "No worker should run before tasks are scheduled.")
+
+	release := make(chan struct{})
+	defer close(release)
+	for range 4 {
+		pool.Schedule(false, &blockingTask{release: release})
+	}
+
+	// Normal tasks start normal workers, up to the maximum.
+	require.Eventually(t, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return pool.normalWorker == 3 && pool.idleNormalWorker == 0
+	}, time.Second, time.Millisecond, "Normal workers were not started up to the maximum.")
+
+	// Urgent tasks start priority workers up to the maximum, the normal ones
+	// being busy.
+	for range 3 {
+		pool.Schedule(true, &blockingTask{release: release})
+	}
+	require.Eventually(t, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return pool.priorityWorker == 2 && pool.idlePriorityWorker == 0 && pool.normalWorker == 3
+	}, time.Second, time.Millisecond, "Priority workers were not started up to the maximum.")
+	assert.Equal(t, 1, len(pool.priorityCh), "Urgent task should wait for a worker.")
+	assert.Equal(t, 1, len(pool.normalCh), "Normal task should wait for a worker.")
+}
+
+func TestDynamicWorkerPool_ReuseIdleWorker(t *testing.T) {
+	pool, err := NewDynamicWorkerPool(1, 3, time.Minute, 100)
+	require.NoError(t, err)
+	pool.Start()
+	defer pool.Stop()
+
+	// Tasks scheduled one after another are executed by the same worker.
+	for range 10 {
+		dt := &blockingTask{release: make(chan struct{})}
+		close(dt.release)
+		pool.Schedule(false, dt)
+		require.Eventually(t, func() bool {
+			pool.mu.Lock()
+			defer pool.mu.Unlock()
+			return dt.executed.Load() && pool.idleNormalWorker == pool.normalWorker
+		}, time.Second, time.Millisecond, "Task was not executed in time.")
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	assert.Equal(t, uint64(1), pool.spawned)
+}
+
+func TestDynamicWorkerPool_RetireIdleWorkers(t *testing.T) {
+	pool, err := NewDynamicWorkerPool(2, 2, 20*time.Millisecond, 100)
+	require.NoError(t, err)
+	pool.Start()
+	defer pool.Stop()
+
+	release := make(chan struct{})
+	tasks := []*blockingTask{}
+	for i := range 4 {
+		task := &blockingTask{release: release}
+		tasks = append(tasks, task)
+		pool.Schedule(i%2 == 0, task)
+	}
+	require.Eventually(t, func() bool {
+		priority, normal := pool.workers()
+		return priority > 0 && normal == 2
+	}, time.Second, time.Millisecond, "Workers were not started.")
+
+	// Once the tasks are done, the idle workers retire after the keep-alive.
+	close(release)
+	assert.Eventually(t, func() bool {
+		priority, normal := pool.workers()
+		return priority+normal == 0
+	}, time.Second, 5*time.Millisecond, "Idle workers were not retired.")
+	for _, task := range tasks {
+		assert.True(t, task.executed.Load())
+	}
+
+	// Workers are started again for new tasks.
+	dt := &blockingTask{release: release}
+	pool.Schedule(true, dt)
+	assert.Eventually(t, dt.executed.Load, time.Second, time.Millisecond, "Task was not executed in time.")
+}
+
+func TestDynamicWorkerPool_UrgentPrecedence(t *testing.T) {
+	pool, err := NewDynamicWorkerPool(0, 1, time.Minute, 100)
+	require.NoError(t, err)
+	pool.Start()
+	defer pool.Stop()
+
+	// The only worker is busy while a normal then an urgent task are
+	// scheduled: the urgent one is executed first.
+	var order []string
+	var orderMu sync.Mutex
+	release := make(chan struct{})
+	first := &blockingTask{release: release, order: &order, orderMu: &orderMu, name: "first"}
+	pool.Schedule(false, first)
+	require.Eventually(t, func() bool {
+		orderMu.Lock()
+		defer orderMu.Unlock()
+		return len(order) == 1
+	}, time.Second, time.Millisecond, "First task was not executed in time.")
+
+	normal := &blockingTask{release: release, order: &order, orderMu: &orderMu, name: "normal"}
+	urgent := &blockingTask{release: release, order: &order, orderMu: &orderMu, name: "urgent"}
+	pool.Schedule(false, normal)
+	pool.Schedule(true, urgent)
+	close(release)
+
+	require.Eventually(t, func() bool { return normal.executed.Load() && urgent.executed.Load() }, time.Second, time.Millisecond, "Tasks were not executed in time.")
+	orderMu.Lock()
+	defer orderMu.Unlock()
+	assert.Equal(t, []string{"first", "urgent", "normal"}, order)
+}
+
+func TestDynamicWorkerPool_PriorityWorkersSkipNormalTasks(t *testing.T) {
+	pool, err := NewDynamicWorkerPool(2, 1, time.Minute, 100)
+	require.NoError(t, err)
+	pool.Start()
+	defer pool.Stop()
+
+	// The normal worker is busy, and a priority worker idle.
+	release := make(chan struct{})
+	defer close(release)
+	pool.Schedule(false, &blockingTask{release: release})
+	urgent := &blockingTask{release: make(chan struct{})}
+	close(urgent.release)
+	pool.Schedule(true, urgent)
+	require.Eventually(t, urgent.executed.Load, time.Second, time.Millisecond, "Urgent task was not executed in time.")
+
+	// The next normal task waits for the normal worker.
+	pool.Schedule(false, &blockingTask{release: release})
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, len(pool.normalCh), "Normal task should wait for a normal worker.")
+}
+
+func TestDynamicWorkerPool_ScheduleBeforeStart(t *testing.T) {
+	pool, err := NewDynamicWorkerPool(1, 1, time.Minute, 100)
+	require.NoError(t, err)
+
+	dt := &blockingTask{release: make(chan struct{})}
+	close(dt.release)
+	pool.Schedule(false, dt)
+	priority, normal := pool.workers()
+	assert.Equal(t, uint32(0), priority+normal, "No worker should run before Start.")
+
+	pool.Start()
+	defer pool.Stop()
+	assert.Eventually(t, dt.executed.Load, time.Second, time.Millisecond, "Task was not executed in time.")
+}
+
+func TestDynamicWorkerPool_TrySchedule(t *testing.T) {
+	// Queues of 2 tasks, and a single normal worker.
+	pool, err := NewDynamicWorkerPool(0, 1, time.Minute, 1)
+	require.NoError(t, err)
+	pool.Start()
+
+	release := make(chan struct{})
+	running := &blockingTask{release: release}
+	require.NoError(t, pool.TrySchedule(false, running))
+	require.Eventually(t, func() bool { return pool.Stats().BusyWorkers == 1 }, time.Second, time.Millisecond, "Task was not started in time.")
+	for range 2 {
+		require.NoError(t, pool.TrySchedule(false, &blockingTask{release: release}))
+	}
+	assert.ErrorIs(t, pool.TrySchedule(false, &blockingTask{release: release}), ErrQueueFull)
+
+	stats := pool.Stats()
+	assert.Equal(t, 1, stats.Workers)
+	assert.Equal(t, 2, stats.Normal.Queued)
+	assert.Equal(t, uint64(3), stats.Normal.Scheduled)
+	assert.Equal(t, uint64(1), stats.Normal.Rejected)
+
+	close(release)
+	require.Eventually(t, func() bool { return pool.Stats().Normal.Executed == 3 }, time.Second, time.Millisecond, "Tasks were not executed in time.")
+	pool.Stop()
+	assert.ErrorIs(t, pool.TrySchedule(false, &dummyTask{}), ErrPoolStopped)
+}
+
+func TestDynamicWorkerPool_ScheduleContext(t *testing.T) {
+	pool, err := NewDynamicWorkerPool(1, 1, time.Minute, 100)
+	require.NoError(t, err)
+	pool.Start()
+	defer pool.Stop()
+
+	dt := &blockingTask{release: make(chan struct{})}
+	close(dt.release)
+	require.NoError(t, pool.ScheduleContext(context.Background(), true, dt))
+	assert.Eventually(t, dt.executed.Load, time.Second, time.Millisecond, "Task was not executed in time.")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, pool.ScheduleContext(ctx, true, &dummyTask{}), context.Canceled)
+	assert.Equal(t, uint64(1), pool.Stats().Priority.Rejected)
+}
+
+func TestDynamicWorkerPool_Drain(t *testing.T) {
+	pool, err := NewDynamicWorkerPool(1, 2, time.Minute, 100)
+	require.NoError(t, err)
+	pool.Start()
+
+	// The workers are busy, the other tasks queued. The normal workers may
+	// take both urgent tasks, leaving the priority worker idle.
+	release := make(chan struct{})
+	tasks := []*blockingTask{}
+	for i := range 8 {
+		task := &blockingTask{release: release}
+		tasks = append(tasks, task)
+		pool.Schedule(i%4 == 0, task)
+	}
+	require.Eventually(t, func() bool { return pool.Stats().BusyWorkers >= 2 }, time.Second, time.Millisecond, "Tasks were not started in time.")
+
+	errCh := make(chan error)
+	go func() { errCh <- pool.Drain(context.Background()) }()
+	require.Eventually(t, func() bool {
+		return pool.TrySchedule(false, &dummyTask{}) == ErrPoolStopped
+	}, time.Second, time.Millisecond, "Tasks should not be scheduled while draining.")
+
+	close(release)
+	require.NoError(t, <-errCh)
+	for _, task := range tasks {
+		assert.True(t, task.executed.Load(), "Queued task was not executed.")
+	}
+	priority, normal := pool.workers()
+	assert.Equal(t, uint32(0), priority+normal, "Workers should exit once drained.")
+	stats := pool.Stats()
+	assert.Equal(t, uint64(0), stats.Priority.Discarded+stats.Normal.Discarded)
+}
+
+func TestDynamicWorkerPool_DrainAndStopTwice(t *testing.T) {
+	pool, err := NewDynamicWorkerPool(1, 1, time.Minute, 100)
+	require.NoError(t, err)
+	pool.Start()
+	task := &blockingTask{release: make(chan struct{})}
+	close(task.release)
+	pool.Schedule(false, task)
+
+	// Drain, then Stop, can be called again.
+	assert.NoError(t, pool.Drain(context.Background()))
+	assert.NotPanics(t, func() { assert.NoError(t, pool.Drain(context.Background())) }, "Should not panic when draining twice.")
+	assert.NotPanics(t, pool.Stop, "Should not panic when stopping after drain.")
+	assert.NotPanics(t, pool.Stop, "Should not panic when stopping twice.")
+	assert.True(t, task.executed.Load(), "Queued task was not executed.")
+
+	// And Drain after Stop, which starts no worker.
+	pool, err = NewDynamicWorkerPool(1, 1, time.Minute, 100)
+	require.NoError(t, err)
+	pool.Start()
+	pool.Stop()
+	assert.NotPanics(t, func() { assert.NoError(t, pool.Drain(context.Background())) }, "Should not panic when draining after stop.")
+	priority, normal := pool.workers()
+	assert.Equal(t, uint32(0), priority+normal)
+}
+
+func TestDynamicWorkerPool_PanicKeepsWorker(t *testing.T) {
+	pool, err := NewDynamicWorkerPool(0, 1, time.Minute, 100)
+	require.NoError(t, err)
+	var gotErr atomic.Value
+	pool.OnError(func(_ bool, _ Task, err error) { gotErr.Store(err) })
+	pool.Start()
+	defer pool.Stop()
+
+	pool.Schedule(false, &errorTask{err: errors.New("boom"), panics: true})
+	require.Eventually(t, func() bool { return gotErr.Load() != nil }, time.Second, time.Millisecond, "Panic was not reported in time.")
+	var panicErr *PanicError
+	assert.ErrorAs(t, gotErr.Load().(error), &panicErr)
+
+	// The worker is idle again, and executes the next task.
+	dt := &blockingTask{release: make(chan struct{})}
+	close(dt.release)
+	pool.Schedule(false, dt)
+	require.Eventually(t, dt.executed.Load, time.Second, time.Millisecond, "Task was not executed after a panic.")
+	assert.Equal(t, uint64(1), pool.Stats().Normal.Panicked)
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	assert.Equal(t, uint64(1), pool.spawned)
+}
+
+func TestDynamicWorkerPool_Stop(t *testing.T) {
+	pool, err := NewDynamicWorkerPool(2, 3, time.Minute, 5)
+	require.NoError(t, err)
+	pool.Start()
+	pool.Schedule(false, &dummyTask{})
+
+	// Stop the pool and check if channels are closed.
+	pool.Stop()
+
+	assert.NotPanics(t, func() { pool.Schedule(true, &dummyTask{}) }, "Should not panic when scheduling after stop.")
+	assert.Equal(t, uint64(1), pool.Stats().Priority.Rejected)
+	assert.Panics(t, func() { pool.normalCh <- &dummyTask{} }, "normalCh channel is not closed.")
+}
@@ -1,6 +1,7 @@
 package workerpool
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"sync"
@@ -14,15 +15,11 @@ type staticWorkerPool struct {
 	priorityWorker uint32 // Number of priority workers in this pool.
 	normalWorker   uint32 // Number of normal workers in this pool.
 
-	// Stop channel to notify all the workers to stop.
-	stop chan bool
-
 	// Wait group to wait for all workers to finish.
 	wg sync.WaitGroup
 
-	// Channels for normal and priority tasks.
-	priorityCh chan Task
-	normalCh   chan Task
+	// Stop and task channels, with their statistics.
+	taskQueues
 }
 
 // NewStaticWorkerPool creates a new thread pool
@@ -43,9 +40,7 @@ func NewStaticWorkerPool(priorityWorker uint32, normalWorker uint32, readGlobalM
 	return &staticWorkerPool{
 		priorityWorker: priorityWorker,
 		normalWorker:   normalWorker,
-		stop:           make(chan bool),
-		priorityCh:     make(chan Task, priorityChSize),
-		normalCh:       make(chan Task, normalChSize),
+		taskQueues:     newTaskQueues(priorityChSize, normalChSize),
 	}, nil
 }
 
@@ -98,15 +93,54 @@ func (swp *staticWorkerPool) Start() {
 
 // Stop all the workers threads and wait for them to finish processing.
 func (swp *staticWorkerPool) Stop() {
-	// Notify all workers to stop.
-	fmt.Printf("staticWorkerPool: stopping all the workers.\n")
-	close(swp.stop)
+	// Later calls wait for the first one to complete.
+	swp.stopOnce.Do(func() {
+		// Notify all workers to stop.
+		fmt.Printf("staticWorkerPool: stopping all the workers.\n")
+		close(swp.stop)
+
+		swp.wg.Wait()
+
+		// Close the channel after all workers are done.
+		if discarded := swp.closeQueues(); discarded > 0 {
+			fmt.Printf("staticWorkerPool: discarded %d queued tasks.\n", discarded)
+		}
+	})
+}
 
-	swp.wg.Wait()
+// Drain lets the workers execute the queued tasks and exit, then stops the
+// pool. If ctx is done first, the pool is stopped right away: the running
+// tasks are waited for, and the queued ones discarded.
+func (swp *staticWorkerPool) Drain(ctx context.Context) error {
+	swp.drainOnce.Do(func() {
+		fmt.Printf("staticWorkerPool: draining the queued tasks.\n")
+		close(swp.drain)
+	})
+
+	drained := make(chan struct{})
+	go func() {
+		swp.wg.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	swp.Stop()
+	return err
+}
 
-	// Close the channel after all workers are done.
-	close(swp.priorityCh)
-	close(swp.normalCh)
+// OnDiscard sets the callback called with the tasks discarded by Stop, or
+// dropped by Schedule once the pool is stopping.
+func (swp *staticWorkerPool) OnDiscard(cb func(urgent bool, task Task)) {
+	swp.setOnDiscard(cb)
+}
+
+// OnError sets the callback called with the tasks that failed or panicked.
+func (swp *staticWorkerPool) OnError(cb func(urgent bool, task Task, err error)) {
+	swp.setOnError(cb)
 }
 
 // Schedule schedules tasks to the worker pool.
@@ -114,11 +148,26 @@ func (swp *staticWorkerPool) Stop() {
 func (swp *staticWorkerPool) Schedule(urgent bool, task Task) {
 	// urgent specifies the priority of this task.
 	// true means high priority and false means low priority
-	if urgent {
-		swp.priorityCh <- task
-	} else {
-		swp.normalCh <- task
-	}
+	swp.enqueue(urgent, task)
+}
+
+// TrySchedule schedules tasks to the worker pool, unless their queue is full
+// or the pool is stopped.
+func (swp *staticWorkerPool) TrySchedule(urgent bool, task Task) error {
+	return swp.trySchedule(urgent, task)
+}
+
+// ScheduleContext schedules tasks to the worker pool, waiting for room in
+// their queue until ctx is done or the pool is stopped.
+func (swp *staticWorkerPool) ScheduleContext(ctx context.Context, urgent bool, task Task) error {
+	return swp.scheduleContext(ctx, urgent, task)
+}
+
+// Stats returns the live statistics of the worker pool.
+func (swp *staticWorkerPool) Stats() Stats {
+	stats := swp.stats()
+	stats.Workers = int(swp.priorityWorker + swp.normalWorker)
+	return stats
 }
 
 // do is the core routine that runs in each worker thread.
@@ -136,8 +185,11 @@ func (swp *staticWorkerPool) do(priority bool) {
 				select {
 				case <-swp.stop:
 					return
+				case <-swp.drain:
+					swp.drainQueues(true, swp.run)
+					return
 				case task := <-swp.priorityCh:
-					task.Execute()
+					swp.run(true, task)
 				}
 			}
 		}
@@ -148,15 +200,18 @@ func (swp *staticWorkerPool) do(priority bool) {
 			case <-swp.stop:
 				return
 			case task := <-swp.priorityCh:
-				task.Execute()
+				swp.run(true, task)
 			default:
 				select {
 				case <-swp.stop:
 					return
+				case <-swp.drain:
+					swp.drainQueues(false, swp.run)
+					return
 				case task := <-swp.priorityCh:
-					task.Execute()
+					swp.run(true, task)
 				case task := <-swp.normalCh:
-					task.Execute()
+					swp.run(false, task)
 				}
 			}
 		}
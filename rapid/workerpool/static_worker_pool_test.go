@@ -1,7 +1,11 @@
 package workerpool
 
 import (
+	"context"
+	"errors"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -17,6 +21,24 @@ func (d *dummyTask) Execute() {
 	d.executed = true
 }
 
+// errorTask is an ErrorTask returning err, or panicking with it if panics is
+// set.
+type errorTask struct {
+	err    error
+	panics bool
+}
+
+func (e *errorTask) Execute() {
+	panic("Execute should not be called for an ErrorTask")
+}
+
+func (e *errorTask) Run() error {
+	if e.panics {
+		panic(e.err)
+	}
+	return e.err
+}
+
 func TestNewStaticWorkerPool_Success(t *testing.T) {
 	tests := []struct {
 		name               string
@@ -156,9 +178,52 @@ func TestStaticWorkerPool_ScheduleAfterStop(t *testing.T) {
 	require.NotNil(t, pool)
 	pool.Start()
 
+	var discarded []Task
+	pool.OnDiscard(func(urgent bool, task Task) {
+		assert.True(t, urgent)
+		discarded = append(discarded, task)
+	})
+
+	pool.Stop()
+
+	// The task is dropped, rejected and discarded, instead of panicking.
+	task := &dummyTask{}
+	assert.NotPanics(t, func() { pool.Schedule(true, task) }, "Should not panic when scheduling after stop.")
+	assert.Equal(t, []Task{task}, discarded)
+	assert.False(t, task.executed)
+	stats := pool.Stats()
+	assert.Equal(t, uint64(0), stats.Priority.Scheduled)
+	assert.Equal(t, uint64(1), stats.Priority.Rejected)
+}
+
+func TestStaticWorkerPool_ScheduleBlockedOnStop(t *testing.T) {
+	// Queues of 2 tasks, the workers not started yet.
+	pool, err := NewStaticWorkerPool(1, 1, 1)
+	require.NoError(t, err)
+	var discarded atomic.Int64
+	pool.OnDiscard(func(urgent bool, task Task) { discarded.Add(1) })
+	pool.Schedule(false, &dummyTask{})
+	pool.Schedule(false, &dummyTask{})
+
+	// The queue is full, so Schedule blocks until Stop drops the task.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Schedule(false, &dummyTask{})
+	}()
+	time.Sleep(20 * time.Millisecond)
 	pool.Stop()
 
-	assert.Panics(t, func() { pool.Schedule(true, &dummyTask{}) }, "Should panic when scheduling after cancel.")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Schedule is still blocked after Stop.")
+	}
+	assert.Equal(t, int64(3), discarded.Load(), "The queued and the blocked tasks should be discarded.")
+	stats := pool.Stats()
+	assert.Equal(t, uint64(2), stats.Normal.Scheduled)
+	assert.Equal(t, uint64(1), stats.Normal.Rejected)
+	assert.Equal(t, uint64(2), stats.Normal.Discarded)
 }
 
 func TestStaticWorkerPool_Stop(t *testing.T) {
@@ -175,6 +240,239 @@ func TestStaticWorkerPool_Stop(t *testing.T) {
 	assert.Panics(t, func() { pool.priorityCh <- &dummyTask{} }, "priorityCh channel is not closed.")
 }
 
+func TestStaticWorkerPool_TrySchedule(t *testing.T) {
+	// Queues of 2 tasks, the workers not started yet.
+	pool, err := NewStaticWorkerPool(1, 1, 1)
+	require.NoError(t, err)
+
+	tasks := []*dummyTask{{}, {}}
+	for _, dt := range tasks {
+		require.NoError(t, pool.TrySchedule(false, dt))
+	}
+	assert.ErrorIs(t, pool.TrySchedule(false, &dummyTask{}), ErrQueueFull)
+	require.NoError(t, pool.TrySchedule(true, &dummyTask{}), "Priority queue should not be full.")
+
+	stats := pool.Stats()
+	assert.Equal(t, LaneStats{Queued: 2, Capacity: 2, Scheduled: 2, Rejected: 1}, stats.Normal)
+	assert.Equal(t, 1, stats.Priority.Queued)
+	assert.Equal(t, 2, stats.Workers)
+
+	pool.Start()
+	require.Eventually(t, func() bool {
+		stats := pool.Stats()
+		return stats.Normal.Executed == 2 && stats.Priority.Executed == 1
+	}, time.Second, time.Millisecond, "Tasks were not executed in time.")
+	stats = pool.Stats()
+	assert.Equal(t, 0, stats.Normal.Queued)
+	assert.Positive(t, stats.Normal.QueueTime, "Tasks waited for the workers to start.")
+
+	pool.Stop()
+	assert.ErrorIs(t, pool.TrySchedule(true, &dummyTask{}), ErrPoolStopped)
+	assert.Equal(t, uint64(1), pool.Stats().Priority.Rejected)
+}
+
+func TestStaticWorkerPool_ScheduleContext(t *testing.T) {
+	pool, err := NewStaticWorkerPool(1, 1, 1)
+	require.NoError(t, err)
+	for range 2 {
+		require.NoError(t, pool.ScheduleContext(context.Background(), false, &dummyTask{}))
+	}
+
+	// The queue is full, the workers not started.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, pool.ScheduleContext(ctx, false, &dummyTask{}), context.DeadlineExceeded)
+	assert.ErrorIs(t, pool.ScheduleContext(ctx, false, &dummyTask{}), context.DeadlineExceeded, "Done context should fail right away.")
+
+	// Stop unblocks the callers waiting for room.
+	errCh := make(chan error)
+	go func() { errCh <- pool.ScheduleContext(context.Background(), false, &dummyTask{}) }()
+	time.Sleep(10 * time.Millisecond)
+	pool.Stop()
+	assert.ErrorIs(t, <-errCh, ErrPoolStopped)
+	assert.Equal(t, uint64(3), pool.Stats().Normal.Rejected)
+}
+
+func TestStaticWorkerPool_Stats(t *testing.T) {
+	pool, err := NewStaticWorkerPool(1, 1, 5)
+	require.NoError(t, err)
+	pool.Start()
+	defer pool.Stop()
+
+	release := make(chan struct{})
+	pool.Schedule(false, &blockingTask{release: release})
+	require.Eventually(t, func() bool { return pool.Stats().BusyWorkers == 1 }, time.Second, time.Millisecond, "Task was not started in time.")
+
+	time.Sleep(5 * time.Millisecond)
+	close(release)
+	require.Eventually(t, func() bool { return pool.Stats().Normal.Executed == 1 }, time.Second, time.Millisecond, "Task was not executed in time.")
+	stats := pool.Stats()
+	assert.Equal(t, 0, stats.BusyWorkers)
+	assert.GreaterOrEqual(t, stats.Normal.ExecuteTime, 5*time.Millisecond)
+	assert.Equal(t, stats.Normal.ExecuteTime, stats.Normal.MeanExecuteTime())
+	assert.Equal(t, time.Duration(0), stats.Priority.MeanQueueTime(), "No task executed in the priority lane.")
+	assert.Equal(t, uint64(1), stats.Normal.Scheduled)
+}
+
+func TestStaticWorkerPool_Drain(t *testing.T) {
+	pool, err := NewStaticWorkerPool(1, 1, 100)
+	require.NoError(t, err)
+	pool.Start()
+
+	// Both workers are busy, the other tasks queued.
+	release := make(chan struct{})
+	tasks := []*blockingTask{}
+	for i := range 8 {
+		task := &blockingTask{release: release}
+		tasks = append(tasks, task)
+		pool.Schedule(i%4 == 0, task)
+	}
+	require.Eventually(t, func() bool { return pool.Stats().BusyWorkers == 2 }, time.Second, time.Millisecond, "Tasks were not started in time.")
+
+	errCh := make(chan error)
+	go func() { errCh <- pool.Drain(context.Background()) }()
+	require.Eventually(t, func() bool {
+		return pool.TrySchedule(false, &dummyTask{}) == ErrPoolStopped
+	}, time.Second, time.Millisecond, "Tasks should not be scheduled while draining.")
+
+	close(release)
+	require.NoError(t, <-errCh)
+	for _, task := range tasks {
+		assert.True(t, task.executed.Load(), "Queued task was not executed.")
+	}
+	stats := pool.Stats()
+	assert.Equal(t, uint64(0), stats.Priority.Discarded+stats.Normal.Discarded)
+	assert.Panics(t, func() { pool.normalCh <- &dummyTask{} }, "normalCh channel is not closed.")
+}
+
+func TestStaticWorkerPool_DrainTimeout(t *testing.T) {
+	pool, err := NewStaticWorkerPool(0, 1, 100)
+	require.NoError(t, err)
+	var discarded []Task
+	pool.OnDiscard(func(urgent bool, task Task) {
+		assert.False(t, urgent)
+		discarded = append(discarded, task)
+	})
+	pool.Start()
+
+	// The only worker is busy until after the deadline, the other tasks
+	// are discarded.
+	release := make(chan struct{})
+	running := &blockingTask{release: release}
+	pool.Schedule(false, running)
+	require.Eventually(t, func() bool { return pool.Stats().BusyWorkers == 1 }, time.Second, time.Millisecond, "Task was not started in time.")
+	queued := []Task{&dummyTask{}, &dummyTask{}, &dummyTask{}}
+	for _, task := range queued {
+		pool.Schedule(false, task)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	assert.ErrorIs(t, pool.Drain(ctx), context.DeadlineExceeded)
+
+	assert.True(t, running.executed.Load(), "Running task should be waited for.")
+	assert.Equal(t, queued, discarded)
+	assert.Equal(t, uint64(3), pool.Stats().Normal.Discarded)
+}
+
+func TestStaticWorkerPool_DrainAndStopTwice(t *testing.T) {
+	pool, err := NewStaticWorkerPool(1, 1, 100)
+	require.NoError(t, err)
+	pool.Start()
+	task := &blockingTask{release: make(chan struct{})}
+	close(task.release)
+	pool.Schedule(false, task)
+
+	// Drain, then Stop, can be called again.
+	assert.NoError(t, pool.Drain(context.Background()))
+	assert.NotPanics(t, func() { assert.NoError(t, pool.Drain(context.Background())) }, "Should not panic when draining twice.")
+	assert.NotPanics(t, pool.Stop, "Should not panic when stopping after drain.")
+	assert.NotPanics(t, pool.Stop, "Should not panic when stopping twice.")
+	assert.True(t, task.executed.Load(), "Queued task was not executed.")
+
+	// And Drain after Stop.
+	pool, err = NewStaticWorkerPool(1, 1, 100)
+	require.NoError(t, err)
+	pool.Start()
+	pool.Stop()
+	assert.NotPanics(t, func() { assert.NoError(t, pool.Drain(context.Background())) }, "Should not panic when draining after stop.")
+}
+
+func TestStaticWorkerPool_StopDiscards(t *testing.T) {
+	pool, err := NewStaticWorkerPool(1, 1, 100)
+	require.NoError(t, err)
+	discarded := map[bool]int{}
+	pool.OnDiscard(func(urgent bool, task Task) { discarded[urgent]++ })
+
+	// The workers are not started, so the tasks stay queued.
+	tasks := []*dummyTask{{}, {}, {}}
+	pool.Schedule(true, tasks[0])
+	pool.Schedule(false, tasks[1])
+	require.NoError(t, pool.TrySchedule(false, tasks[2]))
+	pool.Stop()
+
+	assert.Equal(t, map[bool]int{true: 1, false: 2}, discarded)
+	for _, task := range tasks {
+		assert.False(t, task.executed)
+	}
+	stats := pool.Stats()
+	assert.Equal(t, uint64(1), stats.Priority.Discarded)
+	assert.Equal(t, uint64(2), stats.Normal.Discarded)
+}
+
+func TestStaticWorkerPool_TaskErrors(t *testing.T) {
+	pool, err := NewStaticWorkerPool(1, 1, 100)
+	require.NoError(t, err)
+	var mu sync.Mutex
+	errs := map[Task]error{}
+	pool.OnError(func(urgent bool, task Task, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs[task] = err
+	})
+	pool.Start()
+	defer pool.Stop()
+
+	failing := &errorTask{err: errors.New("failed")}
+	succeeding := &errorTask{}
+	panicking := &errorTask{err: errors.New("boom"), panics: true}
+	pool.Schedule(false, failing)
+	pool.Schedule(false, succeeding)
+	pool.Schedule(true, panicking)
+	require.Eventually(t, func() bool {
+		stats := pool.Stats()
+		return stats.Normal.Executed == 2 && stats.Priority.Executed == 1
+	}, time.Second, time.Millisecond, "Tasks were not executed in time.")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, errs, 2)
+	assert.EqualError(t, errs[failing], "failed")
+	var panicErr *PanicError
+	require.ErrorAs(t, errs[panicking], &panicErr)
+	assert.ErrorIs(t, panicErr, panicking.err)
+	assert.Contains(t, string(panicErr.Stack), "(*errorTask).Run")
+	stats := pool.Stats()
+	assert.Equal(t, uint64(1), stats.Normal.Failed)
+	assert.Equal(t, uint64(1), stats.Priority.Failed)
+	assert.Equal(t, uint64(1), stats.Priority.Panicked)
+}
+
+func TestStaticWorkerPool_PanicKeepsWorker(t *testing.T) {
+	pool, err := NewStaticWorkerPool(0, 1, 100)
+	require.NoError(t, err)
+	pool.Start()
+	defer pool.Stop()
+
+	// The only worker recovers from the panic and executes the next task.
+	pool.Schedule(false, &errorTask{err: errors.New("boom"), panics: true})
+	dt := &dummyTask{}
+	pool.Schedule(false, dt)
+	assert.Eventually(t, func() bool { return pool.Stats().Normal.Executed == 2 }, time.Second, time.Millisecond, "Task was not executed after a panic.")
+	assert.Equal(t, 0, pool.Stats().BusyWorkers)
+}
+
 func TestNewStaticWorkerPoolForCurrentCPU(t *testing.T) {
 	readGlobalMaxBlocks := int64(100)
 
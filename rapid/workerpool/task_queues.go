@@ -0,0 +1,323 @@
+package workerpool
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// taskQueues are the priority and normal lanes of a worker pool, shared by
+// its implementations, with their statistics. Tasks are queued wrapped in a
+// queuedTask, to measure the time they spend queued.
+type taskQueues struct {
+	// Stop channel to notify all the workers to stop.
+	stop chan bool
+	// drain is closed by Drain, for the workers to execute the queued tasks
+	// and exit.
+	drain chan struct{}
+	// stopOnce and drainOnce make Stop and Drain safe to call again, e.g.
+	// Stop after Drain.
+	stopOnce  sync.Once
+	drainOnce sync.Once
+
+	// Channels for normal and priority tasks.
+	priorityCh chan Task
+	normalCh   chan Task
+
+	// queuesMu guards the channels against their closing by closeQueues
+	// while Schedule, TrySchedule and ScheduleContext send to them.
+	queuesMu sync.RWMutex
+
+	// callbacksMu guards the callbacks of OnDiscard and OnError.
+	callbacksMu sync.RWMutex
+	onDiscard   func(urgent bool, task Task)
+	onError     func(urgent bool, task Task, err error)
+
+	priorityLane laneCounters
+	normalLane   laneCounters
+	// busy is the number of tasks executing.
+	busy atomic.Int64
+}
+
+// laneCounters are the statistics of a lane, see LaneStats.
+type laneCounters struct {
+	scheduled   atomic.Uint64
+	executed    atomic.Uint64
+	rejected    atomic.Uint64
+	discarded   atomic.Uint64
+	failed      atomic.Uint64
+	panicked    atomic.Uint64
+	queueTime   atomic.Int64
+	executeTime atomic.Int64
+}
+
+func newTaskQueues(priorityChSize, normalChSize int) taskQueues {
+	return taskQueues{
+		stop:       make(chan bool),
+		drain:      make(chan struct{}),
+		priorityCh: make(chan Task, priorityChSize),
+		normalCh:   make(chan Task, normalChSize),
+	}
+}
+
+// queuedTask is a task waiting in a lane since enqueued.
+type queuedTask struct {
+	task     Task
+	enqueued time.Time
+}
+
+func (t *queuedTask) Execute() { t.task.Execute() }
+
+// lane returns the channel and the counters of the lane of urgent.
+func (q *taskQueues) lane(urgent bool) (chan Task, *laneCounters) {
+	if urgent {
+		return q.priorityCh, &q.priorityLane
+	}
+	return q.normalCh, &q.normalLane
+}
+
+// enqueue implements Schedule: it adds task to its lane, blocking while it is
+// full. Once the pool is stopping, task is dropped: it is counted as rejected
+// and passed to the OnDiscard callback.
+func (q *taskQueues) enqueue(urgent bool, task Task) {
+	q.queuesMu.RLock()
+	err := q.send(context.Background(), urgent, task)
+	q.queuesMu.RUnlock()
+	if err != nil {
+		q.callbacksMu.RLock()
+		onDiscard := q.onDiscard
+		q.callbacksMu.RUnlock()
+		if onDiscard != nil {
+			onDiscard(urgent, task)
+		}
+	}
+}
+
+// trySchedule implements TrySchedule.
+func (q *taskQueues) trySchedule(urgent bool, task Task) error {
+	q.queuesMu.RLock()
+	defer q.queuesMu.RUnlock()
+	ch, counters := q.lane(urgent)
+	if q.isStopping() {
+		counters.rejected.Add(1)
+		return ErrPoolStopped
+	}
+
+	// Counted before the send, so that a worker never executes a task not
+	// counted as scheduled yet.
+	counters.scheduled.Add(1)
+	select {
+	case ch <- &queuedTask{task: task, enqueued: time.Now()}:
+		return nil
+	default:
+		counters.unschedule()
+		return ErrQueueFull
+	}
+}
+
+// scheduleContext implements ScheduleContext.
+func (q *taskQueues) scheduleContext(ctx context.Context, urgent bool, task Task) error {
+	q.queuesMu.RLock()
+	defer q.queuesMu.RUnlock()
+	return q.send(ctx, urgent, task)
+}
+
+// send adds task to its lane, waiting for room until ctx is done or the pool
+// is stopping. A task not added is counted as rejected. q.queuesMu must be
+// read-locked.
+func (q *taskQueues) send(ctx context.Context, urgent bool, task Task) error {
+	ch, counters := q.lane(urgent)
+	if q.isStopping() {
+		counters.rejected.Add(1)
+		return ErrPoolStopped
+	}
+	if err := ctx.Err(); err != nil {
+		counters.rejected.Add(1)
+		return err
+	}
+
+	// Counted before the send, so that a worker never executes a task not
+	// counted as scheduled yet.
+	counters.scheduled.Add(1)
+	select {
+	case ch <- &queuedTask{task: task, enqueued: time.Now()}:
+		return nil
+	case <-ctx.Done():
+		counters.unschedule()
+		return ctx.Err()
+	case <-q.stop:
+		counters.unschedule()
+		return ErrPoolStopped
+	case <-q.drain:
+		counters.unschedule()
+		return ErrPoolStopped
+	}
+}
+
+// isStopping reports whether Stop or Drain was called.
+func (q *taskQueues) isStopping() bool {
+	select {
+	case <-q.stop:
+		return true
+	case <-q.drain:
+		return true
+	default:
+		return false
+	}
+}
+
+// setOnDiscard implements OnDiscard.
+func (q *taskQueues) setOnDiscard(cb func(urgent bool, task Task)) {
+	q.callbacksMu.Lock()
+	defer q.callbacksMu.Unlock()
+	q.onDiscard = cb
+}
+
+// setOnError implements OnError.
+func (q *taskQueues) setOnError(cb func(urgent bool, task Task, err error)) {
+	q.callbacksMu.Lock()
+	defer q.callbacksMu.Unlock()
+	q.onError = cb
+}
+
+// closeQueues closes the lanes, once the workers are done, and discards the
+// tasks left in them. It returns the number of tasks discarded. The stop
+// channel must be closed first, so that ScheduleContext calls waiting for
+// room return.
+func (q *taskQueues) closeQueues() int {
+	q.queuesMu.Lock()
+	close(q.priorityCh)
+	close(q.normalCh)
+	q.queuesMu.Unlock()
+
+	q.callbacksMu.RLock()
+	onDiscard := q.onDiscard
+	q.callbacksMu.RUnlock()
+	return q.discard(true, onDiscard) + q.discard(false, onDiscard)
+}
+
+// discard drops the tasks left in the closed lane of urgent, passing them to
+// onDiscard if not nil.
+func (q *taskQueues) discard(urgent bool, onDiscard func(urgent bool, task Task)) int {
+	ch, counters := q.lane(urgent)
+	n := 0
+	for task := range ch {
+		if t, ok := task.(*queuedTask); ok {
+			task = t.task
+		}
+		counters.discarded.Add(1)
+		n++
+		if onDiscard != nil {
+			onDiscard(urgent, task)
+		}
+	}
+	return n
+}
+
+// drainQueues executes the queued tasks with run until the lanes are empty,
+// or the pool is stopped. Priority workers only execute the urgent tasks.
+func (q *taskQueues) drainQueues(priority bool, run func(urgent bool, task Task)) {
+	for {
+		select {
+		case <-q.stop:
+			return
+		case task := <-q.priorityCh:
+			run(true, task)
+			continue
+		default:
+		}
+		if priority {
+			return
+		}
+
+		select {
+		case task := <-q.normalCh:
+			run(false, task)
+		default:
+			return
+		}
+	}
+}
+
+// run executes task, taken from the lane of urgent, records its times, and
+// reports its error, see OnError.
+func (q *taskQueues) run(urgent bool, task Task) {
+	_, counters := q.lane(urgent)
+	start := time.Now()
+	if t, ok := task.(*queuedTask); ok {
+		counters.queueTime.Add(int64(start.Sub(t.enqueued)))
+		task = t.task
+	}
+
+	q.busy.Add(1)
+	err := executeTask(task)
+	q.busy.Add(-1)
+	counters.executeTime.Add(int64(time.Since(start)))
+	counters.executed.Add(1)
+	if err == nil {
+		return
+	}
+
+	counters.failed.Add(1)
+	if panicErr, ok := err.(*PanicError); ok {
+		counters.panicked.Add(1)
+		fmt.Printf("workerpool: task panicked: %v\n%s", panicErr.Value, panicErr.Stack)
+	}
+	q.callbacksMu.RLock()
+	onError := q.onError
+	q.callbacksMu.RUnlock()
+	if onError != nil {
+		onError(urgent, task, err)
+	}
+}
+
+// executeTask executes task, with Run for an ErrorTask, and returns its error. A
+// panic of task is recovered and returned as a *PanicError, so that the
+// worker keeps running.
+func executeTask(task Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	if t, ok := task.(ErrorTask); ok {
+		return t.Run()
+	}
+	task.Execute()
+	return nil
+}
+
+// stats returns the statistics of the lanes, without the workers.
+func (q *taskQueues) stats() Stats {
+	return Stats{
+		Priority:    q.priorityLane.stats(q.priorityCh),
+		Normal:      q.normalLane.stats(q.normalCh),
+		BusyWorkers: int(q.busy.Load()),
+	}
+}
+
+// unschedule counts a task counted as scheduled, but not added to the lane,
+// as rejected.
+func (c *laneCounters) unschedule() {
+	c.scheduled.Add(^uint64(0))
+	c.rejected.Add(1)
+}
+
+func (c *laneCounters) stats(ch chan Task) LaneStats {
+	return LaneStats{
+		Queued:      len(ch),
+		Capacity:    cap(ch),
+		Scheduled:   c.scheduled.Load(),
+		Executed:    c.executed.Load(),
+		Rejected:    c.rejected.Load(),
+		Discarded:   c.discarded.Load(),
+		Failed:      c.failed.Load(),
+		Panicked:    c.panicked.Load(),
+		QueueTime:   time.Duration(c.queueTime.Load()),
+		ExecuteTime: time.Duration(c.executeTime.Load()),
+	}
+}
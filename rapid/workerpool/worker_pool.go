@@ -1,17 +1,145 @@
 package workerpool
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned by TrySchedule when the lane of the task is
+	// full.
+	ErrQueueFull = errors.New("workerpool: queue is full")
+
+	// ErrPoolStopped is returned when scheduling a task on a stopped or
+	// draining pool.
+	ErrPoolStopped = errors.New("workerpool: pool is stopped")
+)
+
 // Task interface defines the contract for a runnable task.
 type Task interface {
 	Execute()
 }
 
+// ErrorTask is a task whose execution can fail. Worker pools execute it with
+// Run instead of Execute, and report its error, see OnError.
+type ErrorTask interface {
+	Task
+	Run() error
+}
+
+// PanicError is the error reported for a task that panicked. The worker
+// executing the task recovers and keeps running.
+type PanicError struct {
+	Value any    // Value passed to panic.
+	Stack []byte // Stack of the panicking goroutine.
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("workerpool: task panicked: %v", e.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
 type WorkerPool interface {
 	// Start initializes the worker pool and prepares it to accept tasks.
 	Start()
 
 	// Stop gracefully shuts down the worker pool, waiting for all tasks to complete.
+	// The tasks still queued are discarded, see OnDiscard.
 	Stop()
 
-	// Schedule adds a task to the worker pool for execution.
+	// Drain executes the queued tasks, then stops the worker pool. No task
+	// can be scheduled meanwhile. If ctx is done first, it stops the worker
+	// pool like Stop and returns the error of ctx. Drain and Stop can be
+	// called again, or one after the other: the pool is drained and stopped
+	// once, later calls wait for the pool to stop.
+	Drain(ctx context.Context) error
+
+	// OnDiscard sets a callback called with every task discarded by Stop, or
+	// dropped by Schedule once the pool is stopping, e.g. to fail it. It must
+	// be set before Stop.
+	OnDiscard(cb func(urgent bool, task Task))
+
+	// OnError sets a callback called by the workers with every task that
+	// failed: an ErrorTask whose Run returned an error, or a task that
+	// panicked, with a *PanicError.
+	OnError(cb func(urgent bool, task Task, err error))
+
+	// Schedule adds a task to the worker pool for execution. It blocks while
+	// the queue is full. Once the pool is stopping, by Stop or Drain, the task
+	// is dropped: it is counted as rejected and passed to the OnDiscard
+	// callback.
 	Schedule(urgent bool, task Task)
+
+	// TrySchedule adds a task to the worker pool for execution, without
+	// blocking. It returns ErrQueueFull if the queue is full, and
+	// ErrPoolStopped once the pool is stopped.
+	TrySchedule(urgent bool, task Task) error
+
+	// ScheduleContext adds a task to the worker pool for execution, blocking
+	// while the queue is full. It returns the error of ctx if ctx is done
+	// first, and ErrPoolStopped once the pool is stopped.
+	ScheduleContext(ctx context.Context, urgent bool, task Task) error
+
+	// Stats returns the live statistics of the worker pool.
+	Stats() Stats
+}
+
+// LaneStats are the statistics of the tasks of a priority, urgent or normal.
+type LaneStats struct {
+	// Queued is the number of tasks waiting for a worker, out of Capacity.
+	Queued   int
+	Capacity int
+
+	// Scheduled, Executed and Rejected count the tasks added to the queue,
+	// the ones done, and the ones Schedule, TrySchedule or ScheduleContext
+	// failed to add. Discarded counts the tasks dropped from the queue by
+	// Stop.
+	Scheduled uint64
+	Executed  uint64
+	Rejected  uint64
+	Discarded uint64
+
+	// Failed counts the executed tasks that failed, Panicked the ones of
+	// them that panicked.
+	Failed   uint64
+	Panicked uint64
+
+	// QueueTime and ExecuteTime are the total times the executed tasks
+	// waited in the queue, and took to execute.
+	QueueTime   time.Duration
+	ExecuteTime time.Duration
+}
+
+// Stats are the statistics of a worker pool.
+type Stats struct {
+	Priority LaneStats
+	Normal   LaneStats
+
+	// Workers is the number of running workers, BusyWorkers the ones of them
+	// executing a task.
+	Workers     int
+	BusyWorkers int
+}
+
+// MeanQueueTime returns the mean time the executed tasks waited in the queue.
+func (s LaneStats) MeanQueueTime() time.Duration {
+	if s.Executed == 0 {
+		return 0
+	}
+	return s.QueueTime / time.Duration(s.Executed)
+}
+
+// MeanExecuteTime returns the mean time the executed tasks took to execute.
+func (s LaneStats) MeanExecuteTime() time.Duration {
+	if s.Executed == 0 {
+		return 0
+	}
+	return s.ExecuteTime / time.Duration(s.Executed)
 }
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/raj-prince/custom-go-client-benchmark/util"
+)
+
+// workerStats collects the results of a single worker. It is owned by the
+// worker goroutine until the benchmark completes.
+type workerStats struct {
+	object     string
+	operations uint64
+	bytes      uint64
+	errors     uint64
+	start      time.Time
+	end        time.Time
+	firstByte  *util.Histogram
+	total      *util.Histogram
+}
+
+func newWorkerStats(object string) *workerStats {
+	return &workerStats{
+		object:    object,
+		firstByte: util.NewHistogram(),
+		total:     util.NewHistogram(),
+	}
+}
+
+// Report is the end-of-run summary written by --report-json and
+// --report-csv. Field names don't depend on the client protocol, so reports
+// of different runs can be diffed.
+type Report struct {
+	Config           ReportConfig    `json:"config"`
+	StartTime        time.Time       `json:"start_time"`
+	EndTime          time.Time       `json:"end_time"`
+	DurationSeconds  float64         `json:"duration_seconds"`
+	Error            string          `json:"error,omitempty"`
+	Total            WorkerReport    `json:"total"`
+	Workers          []WorkerReport  `json:"workers"`
+	OpenLoop         *OpenLoopReport `json:"open_loop,omitempty"`
+	Hedge            *HedgeReport    `json:"hedge,omitempty"`
+	FirstByteLatency LatencyReport   `json:"first_byte_latency"`
+	TotalLatency     LatencyReport   `json:"total_latency"`
+}
+
+// ReportConfig is the configuration of the run.
+type ReportConfig struct {
+	ClientProtocol     string  `json:"client_protocol"`
+	Bucket             string  `json:"bucket"`
+	ObjectPrefix       string  `json:"object_prefix"`
+	ObjectSuffix       string  `json:"object_suffix"`
+	Workers            int     `json:"workers"`
+	ReadCallsPerWorker int     `json:"read_calls_per_worker"`
+	DurationSeconds    float64 `json:"duration_seconds"`
+	WarmupSeconds      float64 `json:"warmup_seconds"`
+	MaxBytes           int64   `json:"max_bytes"`
+	Workload           string  `json:"workload"`
+	RangeSize          int64   `json:"range_size"`
+	Stride             int64   `json:"stride"`
+	MixedWeights       [3]int  `json:"mixed_weights"`
+	WorkloadSeed       int64   `json:"workload_seed"`
+	ReadStallRetry     bool    `json:"read_stall_retry"`
+	FakeGCS            bool    `json:"fake_gcs"`
+}
+
+// WorkerReport is the result of a single worker, or of all of them for
+// Report.Total, whose Worker is -1.
+type WorkerReport struct {
+	Worker          int     `json:"worker"`
+	Object          string  `json:"object,omitempty"`
+	Operations      uint64  `json:"operations"`
+	Bytes           uint64  `json:"bytes"`
+	Errors          uint64  `json:"errors"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	ThroughputMiBps float64 `json:"throughput_mib_per_sec"`
+}
+
+// OpenLoopReport is the arrival accounting of an open-loop run. Latencies of
+// such a run are measured from the intended send time of each read.
+type OpenLoopReport struct {
+	Rate         float64 `json:"rate"`
+	TargetMBps   float64 `json:"target_mib_per_sec"`
+	Distribution string  `json:"distribution"`
+	MaxBacklog   int     `json:"max_backlog"`
+	Arrivals     uint64  `json:"arrivals"`
+	Issued       uint64  `json:"issued"`
+	Dropped      uint64  `json:"dropped"`
+	Unissued     uint64  `json:"unissued"`
+	PeakBacklog  int     `json:"peak_backlog"`
+}
+
+func newOpenLoopReport(s util.OpenLoopStats) *OpenLoopReport {
+	return &OpenLoopReport{
+		Rate:         *arrivalRate,
+		TargetMBps:   *targetMBps,
+		Distribution: *arrivalDistribution,
+		MaxBacklog:   *maxBacklog,
+		Arrivals:     s.Arrivals,
+		Issued:       s.Issued,
+		Dropped:      s.Dropped,
+		Unissued:     s.Unissued,
+		PeakBacklog:  s.PeakBacklog,
+	}
+}
+
+// HedgeReport reports the hedged NewRangeReader calls of a --hedge run,
+// including the warm-up ones. Latencies are the ones of NewRangeReader, i.e.
+// until the response headers are received. Primary latencies are the ones of
+// the first call alone, so the improvements estimate what hedging saved at
+// the tail. As a cancelled first call is only known to take longer than the
+// winning one, they are lower bounds.
+type HedgeReport struct {
+	Calls             uint64  `json:"calls"`
+	Hedged            uint64  `json:"hedged"`
+	BackupWins        uint64  `json:"backup_wins"`
+	HedgeRate         float64 `json:"hedge_rate"`
+	ThresholdMs       float64 `json:"threshold_ms"`
+	P99Ms             float64 `json:"p99_ms"`
+	P999Ms            float64 `json:"p99_9_ms"`
+	PrimaryP99Ms      float64 `json:"primary_p99_ms"`
+	PrimaryP999Ms     float64 `json:"primary_p99_9_ms"`
+	P99ImprovementMs  float64 `json:"p99_improvement_ms"`
+	P999ImprovementMs float64 `json:"p99_9_improvement_ms"`
+
+	// ThresholdsMs are the hedge delays per key with --hedge-delay-key.
+	ThresholdsMs map[string]float64 `json:"thresholds_ms,omitempty"`
+}
+
+func newHedgeReport(s util.HedgeStats) *HedgeReport {
+	r := &HedgeReport{
+		Calls:             s.Calls,
+		Hedged:            s.Hedged,
+		BackupWins:        s.BackupWins,
+		HedgeRate:         s.HedgeRate(),
+		ThresholdMs:       toMs(s.Threshold),
+		P99Ms:             toMs(s.Latency.Percentile(0.99)),
+		P999Ms:            toMs(s.Latency.Percentile(0.999)),
+		PrimaryP99Ms:      toMs(s.PrimaryLatency.Percentile(0.99)),
+		PrimaryP999Ms:     toMs(s.PrimaryLatency.Percentile(0.999)),
+		P99ImprovementMs:  toMs(s.TailImprovement(0.99)),
+		P999ImprovementMs: toMs(s.TailImprovement(0.999)),
+	}
+	if len(s.Thresholds) > 0 {
+		r.ThresholdsMs = make(map[string]float64, len(s.Thresholds))
+		for _, t := range s.Thresholds {
+			r.ThresholdsMs[t.Key] = toMs(t.Value)
+		}
+	}
+	return r
+}
+
+// LatencyReport summarizes a latency histogram, in milliseconds.
+type LatencyReport struct {
+	Count   uint64          `json:"count"`
+	MinMs   float64         `json:"min_ms"`
+	MeanMs  float64         `json:"mean_ms"`
+	MaxMs   float64         `json:"max_ms"`
+	P50Ms   float64         `json:"p50_ms"`
+	P90Ms   float64         `json:"p90_ms"`
+	P99Ms   float64         `json:"p99_ms"`
+	P999Ms  float64         `json:"p99_9_ms"`
+	Buckets []LatencyBucket `json:"buckets"`
+}
+
+// LatencyBucket holds the latencies in (previous UpperBoundMs, UpperBoundMs].
+type LatencyBucket struct {
+	UpperBoundMs float64 `json:"upper_bound_ms"`
+	Count        uint64  `json:"count"`
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func newLatencyReport(h *util.Histogram) LatencyReport {
+	r := LatencyReport{
+		Count:   h.Count(),
+		MinMs:   toMs(h.Min()),
+		MeanMs:  toMs(h.Mean()),
+		MaxMs:   toMs(h.Max()),
+		P50Ms:   toMs(h.Percentile(0.5)),
+		P90Ms:   toMs(h.Percentile(0.9)),
+		P99Ms:   toMs(h.Percentile(0.99)),
+		P999Ms:  toMs(h.Percentile(0.999)),
+		Buckets: []LatencyBucket{},
+	}
+	// Every bucket is reported, so that the reports of two runs have the
+	// same rows.
+	for _, b := range h.AllBuckets() {
+		r.Buckets = append(r.Buckets, LatencyBucket{UpperBoundMs: toMs(b.UpperBound), Count: b.Count})
+	}
+	return r
+}
+
+func throughputMiBps(bytes uint64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) / float64(MB) / d.Seconds()
+}
+
+// newReport builds the report of a run from the stats of every worker. start
+// is the end of the warm-up, the report only covers [start, end].
+func newReport(start, end time.Time, workers []*workerStats, runErr error) *Report {
+	end = latest(start, end)
+	r := &Report{
+		Config: ReportConfig{
+			ClientProtocol:     *clientProtocol,
+			Bucket:             *bucketName,
+			ObjectPrefix:       *objectNamePrefix,
+			ObjectSuffix:       *objectNameSuffix,
+			Workers:            *numOfWorker,
+			ReadCallsPerWorker: *numOfReadCallPerWorker,
+			DurationSeconds:    runDuration.Seconds(),
+			WarmupSeconds:      warmup.Seconds(),
+			MaxBytes:           *maxBytes,
+			Workload:           workloadConfig.Type,
+			RangeSize:          workloadConfig.RangeSize,
+			Stride:             workloadConfig.Stride,
+			MixedWeights:       workloadConfig.MixedWeights,
+			WorkloadSeed:       *workloadSeed,
+			ReadStallRetry:     *enableReadStallRetry,
+			FakeGCS:            *fakeGCS,
+		},
+		StartTime:       start,
+		EndTime:         end,
+		DurationSeconds: end.Sub(start).Seconds(),
+		Workers:         []WorkerReport{},
+	}
+	if runErr != nil {
+		r.Error = runErr.Error()
+	}
+
+	firstByte, total := util.NewHistogram(), util.NewHistogram()
+	r.Total.Worker = -1
+	r.Total.DurationSeconds = r.DurationSeconds
+	for i, ws := range workers {
+		d := max(ws.end.Sub(latest(ws.start, start)), 0)
+		r.Workers = append(r.Workers, WorkerReport{
+			Worker:          i,
+			Object:          ws.object,
+			Operations:      ws.operations,
+			Bytes:           ws.bytes,
+			Errors:          ws.errors,
+			DurationSeconds: d.Seconds(),
+			ThroughputMiBps: throughputMiBps(ws.bytes, d),
+		})
+		r.Total.Operations += ws.operations
+		r.Total.Bytes += ws.bytes
+		r.Total.Errors += ws.errors
+		firstByte.Merge(ws.firstByte)
+		total.Merge(ws.total)
+	}
+	r.Total.ThroughputMiBps = throughputMiBps(r.Total.Bytes, end.Sub(start))
+	r.FirstByteLatency = newLatencyReport(firstByte)
+	r.TotalLatency = newLatencyReport(total)
+	return r
+}
+
+// latest returns the later of a and b.
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// WriteJSON writes the report to path as indented JSON.
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("while encoding the report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("while writing the report: %w", err)
+	}
+	return nil
+}
+
+// WriteCSV writes the report to path as "section,key,value" rows. Rows are
+// written in a fixed order, so two reports can be compared with diff or
+// joined on section and key.
+func (r *Report) WriteCSV(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("while creating the report: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	row := func(section, key string, value any) {
+		// Errors are sticky and reported by w.Error below.
+		_ = w.Write([]string{section, key, fmt.Sprint(value)})
+	}
+
+	row("section", "key", "value")
+	c := r.Config
+	row("config", "client_protocol", c.ClientProtocol)
+	row("config", "bucket", c.Bucket)
+	row("config", "object_prefix", c.ObjectPrefix)
+	row("config", "object_suffix", c.ObjectSuffix)
+	row("config", "workers", c.Workers)
+	row("config", "read_calls_per_worker", c.ReadCallsPerWorker)
+	row("config", "duration_seconds", c.DurationSeconds)
+	row("config", "warmup_seconds", c.WarmupSeconds)
+	row("config", "max_bytes", c.MaxBytes)
+	row("config", "workload", c.Workload)
+	row("config", "range_size", c.RangeSize)
+	row("config", "stride", c.Stride)
+	row("config", "mixed_weights", fmt.Sprintf("%d,%d,%d", c.MixedWeights[0], c.MixedWeights[1], c.MixedWeights[2]))
+	row("config", "workload_seed", c.WorkloadSeed)
+	row("config", "read_stall_retry", c.ReadStallRetry)
+	row("config", "fake_gcs", c.FakeGCS)
+	row("run", "start_time", r.StartTime.Format(time.RFC3339Nano))
+	row("run", "end_time", r.EndTime.Format(time.RFC3339Nano))
+	row("run", "duration_seconds", r.DurationSeconds)
+	row("run", "error", r.Error)
+
+	workerRows := func(section string, wr WorkerReport) {
+		if wr.Object != "" {
+			row(section, "object", wr.Object)
+		}
+		row(section, "operations", wr.Operations)
+		row(section, "bytes", wr.Bytes)
+		row(section, "errors", wr.Errors)
+		row(section, "duration_seconds", wr.DurationSeconds)
+		row(section, "throughput_mib_per_sec", wr.ThroughputMiBps)
+	}
+	workerRows("total", r.Total)
+	for _, wr := range r.Workers {
+		workerRows("worker_"+strconv.Itoa(wr.Worker), wr)
+	}
+
+	if ol := r.OpenLoop; ol != nil {
+		row("open_loop", "rate", ol.Rate)
+		row("open_loop", "target_mib_per_sec", ol.TargetMBps)
+		row("open_loop", "distribution", ol.Distribution)
+		row("open_loop", "max_backlog", ol.MaxBacklog)
+		row("open_loop", "arrivals", ol.Arrivals)
+		row("open_loop", "issued", ol.Issued)
+		row("open_loop", "dropped", ol.Dropped)
+		row("open_loop", "unissued", ol.Unissued)
+		row("open_loop", "peak_backlog", ol.PeakBacklog)
+	}
+
+	if h := r.Hedge; h != nil {
+		row("hedge", "calls", h.Calls)
+		row("hedge", "hedged", h.Hedged)
+		row("hedge", "backup_wins", h.BackupWins)
+		row("hedge", "hedge_rate", h.HedgeRate)
+		row("hedge", "threshold_ms", h.ThresholdMs)
+		row("hedge", "p99_ms", h.P99Ms)
+		row("hedge", "p99_9_ms", h.P999Ms)
+		row("hedge", "primary_p99_ms", h.PrimaryP99Ms)
+		row("hedge", "primary_p99_9_ms", h.PrimaryP999Ms)
+		row("hedge", "p99_improvement_ms", h.P99ImprovementMs)
+		row("hedge", "p99_9_improvement_ms", h.P999ImprovementMs)
+		for _, key := range sortedKeys(h.ThresholdsMs) {
+			row("hedge_thresholds", key+"_ms", h.ThresholdsMs[key])
+		}
+	}
+
+	latencyRows := func(section string, l LatencyReport) {
+		row(section, "count", l.Count)
+		row(section, "min_ms", l.MinMs)
+		row(section, "mean_ms", l.MeanMs)
+		row(section, "max_ms", l.MaxMs)
+		row(section, "p50_ms", l.P50Ms)
+		row(section, "p90_ms", l.P90Ms)
+		row(section, "p99_ms", l.P99Ms)
+		row(section, "p99_9_ms", l.P999Ms)
+		for _, b := range l.Buckets {
+			row(section+"_histogram", "le_"+strconv.FormatFloat(b.UpperBoundMs, 'g', 6, 64)+"_ms", b.Count)
+		}
+	}
+	latencyRows("first_byte_latency", r.FirstByteLatency)
+	latencyRows("total_latency", r.TotalLatency)
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("while writing the report: %w", err)
+	}
+	return f.Close()
+}
+
+// Print writes a short human readable summary of the report to stdout.
+func (r *Report) Print() {
+	fmt.Printf("Operations: %d, Bytes: %d, Errors: %d, Throughput: %.2f MiB/s\n",
+		r.Total.Operations, r.Total.Bytes, r.Total.Errors, r.Total.ThroughputMiBps)
+	if ol := r.OpenLoop; ol != nil {
+		fmt.Printf("Open loop: Arrivals: %d, Issued: %d, Dropped: %d, Unissued: %d, Peak backlog: %d\n",
+			ol.Arrivals, ol.Issued, ol.Dropped, ol.Unissued, ol.PeakBacklog)
+	}
+	if h := r.Hedge; h != nil {
+		fmt.Printf("Hedge: Calls: %d, Hedge rate: %.4f, Backup wins: %d, Threshold: %.3f ms, p99 improvement: >= %.3f ms, p99.9 improvement: >= %.3f ms\n",
+			h.Calls, h.HedgeRate, h.BackupWins, h.ThresholdMs, h.P99ImprovementMs, h.P999ImprovementMs)
+		for _, key := range sortedKeys(h.ThresholdsMs) {
+			fmt.Printf("Hedge threshold %s: %.3f ms\n", key, h.ThresholdsMs[key])
+		}
+	}
+	for _, l := range []struct {
+		name string
+		r    LatencyReport
+	}{{"First byte latency", r.FirstByteLatency}, {"Total latency", r.TotalLatency}} {
+		fmt.Printf("%s (ms): p50 %.3f, p90 %.3f, p99 %.3f, p99.9 %.3f, max %.3f\n",
+			l.name, l.r.P50Ms, l.r.P90Ms, l.r.P99Ms, l.r.P999Ms, l.r.MaxMs)
+	}
+}
+
+// sortedKeys returns the keys of m in increasing order.
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/raj-prince/custom-go-client-benchmark/util"
+)
+
+func TestNewLatencyReport(t *testing.T) {
+	h := util.NewHistogram()
+	for i := 1; i <= 100; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	r := newLatencyReport(h)
+
+	if r.Count != 100 || r.MinMs != 1 || r.MaxMs != 100 || r.MeanMs != 50.5 {
+		t.Fatalf("unexpected summary: %+v", r)
+	}
+	if r.P50Ms < 50 || r.P50Ms > 55 || r.P99Ms < 99 || r.P99Ms > 100 {
+		t.Fatalf("p50 %v ms, p99 %v ms, want about 50 and 99 ms", r.P50Ms, r.P99Ms)
+	}
+
+	// Every bucket is reported, so that all reports have the same layout.
+	empty := newLatencyReport(util.NewHistogram())
+	if len(r.Buckets) != len(empty.Buckets) {
+		t.Fatalf("got %d buckets, the empty report has %d", len(r.Buckets), len(empty.Buckets))
+	}
+	var count uint64
+	for i, b := range r.Buckets {
+		count += b.Count
+		if b.UpperBoundMs != empty.Buckets[i].UpperBoundMs {
+			t.Fatalf("bucket %d upper bound is %v ms, %v ms in the empty report", i, b.UpperBoundMs, empty.Buckets[i].UpperBoundMs)
+		}
+		if empty.Buckets[i].Count != 0 {
+			t.Fatalf("bucket %d of the empty report has %d latencies", i, empty.Buckets[i].Count)
+		}
+	}
+	if count != r.Count {
+		t.Fatalf("buckets hold %d latencies, want %d", count, r.Count)
+	}
+}
+
+// testReport returns a report with the given latencies.
+func testReport(latencies ...time.Duration) *Report {
+	h := util.NewHistogram()
+	for _, d := range latencies {
+		h.Record(d)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &Report{
+		Config:           ReportConfig{ClientProtocol: "grpc", Bucket: "bucket", Workers: 1},
+		StartTime:        start,
+		EndTime:          start.Add(time.Second),
+		DurationSeconds:  1,
+		Total:            WorkerReport{Worker: -1, Operations: uint64(len(latencies)), Bytes: uint64(len(latencies) * MB)},
+		Workers:          []WorkerReport{{Worker: 0, Object: "obj-0", Operations: uint64(len(latencies))}},
+		FirstByteLatency: newLatencyReport(h),
+		TotalLatency:     newLatencyReport(h),
+	}
+}
+
+func TestReportWriteJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	want := testReport(time.Millisecond, 2*time.Millisecond, 40*time.Millisecond)
+	if err := want.WriteJSON(path); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Report
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid JSON report: %v", err)
+	}
+	if got.Config != want.Config || got.Total != want.Total || !got.StartTime.Equal(want.StartTime) {
+		t.Fatalf("got report %+v, want %+v", got, want)
+	}
+	if got.TotalLatency.Count != 3 || len(got.TotalLatency.Buckets) != len(want.TotalLatency.Buckets) {
+		t.Fatalf("got total latency %+v, want %+v", got.TotalLatency, want.TotalLatency)
+	}
+	if got.OpenLoop != nil || got.Hedge != nil {
+		t.Fatalf("unexpected open loop %+v or hedge %+v", got.OpenLoop, got.Hedge)
+	}
+}
+
+// readCSV returns the rows of the CSV report written by r.
+func readCSV(t *testing.T, r *Report) [][]string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "report.csv")
+	if err := r.WriteCSV(path); err != nil {
+		t.Fatalf("WriteCSV() error = %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV report: %v", err)
+	}
+	return rows
+}
+
+func TestReportWriteCSV(t *testing.T) {
+	fast := readCSV(t, testReport(time.Millisecond, 2*time.Millisecond))
+	slow := readCSV(t, testReport(time.Second))
+
+	if got := fast[0]; len(got) != 3 || got[0] != "section" || got[1] != "key" || got[2] != "value" {
+		t.Fatalf("got header %v", got)
+	}
+	// The reports of two runs have the same keys, in the same order, even if
+	// their latencies fall in different buckets.
+	if len(fast) != len(slow) {
+		t.Fatalf("reports have %d and %d rows", len(fast), len(slow))
+	}
+	values := make(map[string]string)
+	histogram := 0
+	for i := range fast {
+		if fast[i][0] != slow[i][0] || fast[i][1] != slow[i][1] {
+			t.Fatalf("row %d is %v in one report, %v in the other", i, fast[i][:2], slow[i][:2])
+		}
+		values[fast[i][0]+"/"+fast[i][1]] = fast[i][2]
+		if fast[i][0] == "total_latency_histogram" {
+			histogram++
+		}
+	}
+	if want := len(newLatencyReport(util.NewHistogram()).Buckets); histogram != want {
+		t.Fatalf("got %d histogram rows, want one per bucket, %d", histogram, want)
+	}
+
+	for key, want := range map[string]string{
+		"config/client_protocol": "grpc",
+		"total/operations":       "2",
+		"worker_0/object":        "obj-0",
+		"total_latency/count":    "2",
+		"total_latency/max_ms":   "2",
+		// The 1ms latency is in the bucket of upper bound 2^10us.
+		"total_latency_histogram/le_1.024_ms": "1",
+		// Empty buckets are written too.
+		"total_latency_histogram/le_0.00109_ms": "0",
+	} {
+		if got, ok := values[key]; !ok || got != want {
+			t.Errorf("%s = %q (present: %v), want %q", key, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// runWindow bounds a run by --warmup, --duration and --max-bytes. Every worker
+// shares the context returned by newRunWindow, which is cancelled once the
+// run is over.
+type runWindow struct {
+	// measureStart is the end of the warm-up. Reads started before it are not
+	// measured.
+	measureStart time.Time
+
+	maxBytes int64
+	bytes    atomic.Int64
+	cancel   context.CancelFunc
+}
+
+// newRunWindow starts a run now, which lasts warmup + duration if duration is
+// > 0, or until maxBytes measured bytes are read if maxBytes is > 0.
+func newRunWindow(ctx context.Context, warmup, duration time.Duration, maxBytes int64) (context.Context, *runWindow) {
+	w := &runWindow{
+		measureStart: time.Now().Add(warmup),
+		maxBytes:     maxBytes,
+	}
+	if duration > 0 {
+		ctx, w.cancel = context.WithDeadline(ctx, w.measureStart.Add(duration))
+	} else {
+		ctx, w.cancel = context.WithCancel(ctx)
+	}
+	return ctx, w
+}
+
+// measured reports whether a read started at start is out of the warm-up.
+func (w *runWindow) measured(start time.Time) bool {
+	return !start.Before(w.measureStart)
+}
+
+// addBytes accounts n measured bytes and ends the run once the --max-bytes
+// budget is spent.
+func (w *runWindow) addBytes(n int64) {
+	if w.bytes.Add(n) >= w.maxBytes && w.maxBytes > 0 {
+		w.cancel()
+	}
+}
+
+// stop ends the run and releases its context.
+func (w *runWindow) stop() {
+	w.cancel()
+}
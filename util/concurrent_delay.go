@@ -0,0 +1,74 @@
+package util
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ConcurrentDelay is a goroutine-safe Delay. It has the same semantics, the
+// value is updated lock-free with compare-and-swap, so concurrent Increase and
+// Decrease calls are all applied, in some order.
+type ConcurrentDelay struct {
+	increaseFactor float64
+	decreaseFactor float64
+	minDelay       time.Duration
+	maxDelay       time.Duration
+	value          atomic.Int64
+}
+
+// NewConcurrentDelay returns a ConcurrentDelay, see NewDelay for the
+// arguments.
+func NewConcurrentDelay(targetPercentile float64, increaseRate float64, initialDelay, minDelay, maxDelay time.Duration) (*ConcurrentDelay, error) {
+	d, err := NewDelay(targetPercentile, increaseRate, initialDelay, minDelay, maxDelay)
+	if err != nil {
+		return nil, err
+	}
+
+	cd := &ConcurrentDelay{
+		increaseFactor: d.increaseFactor,
+		decreaseFactor: d.decreaseFactor,
+		minDelay:       d.minDelay,
+		maxDelay:       d.maxDelay,
+	}
+	cd.value.Store(int64(d.value))
+	return cd, nil
+}
+
+// update atomically replaces the value v with next(v).
+func (d *ConcurrentDelay) update(next func(v time.Duration) time.Duration) {
+	for {
+		old := d.value.Load()
+		if d.value.CompareAndSwap(old, int64(next(time.Duration(old)))) {
+			return
+		}
+	}
+}
+
+// Increase notes that the RPC took longer than the delay returned by Value.
+func (d *ConcurrentDelay) Increase() {
+	d.update(func(v time.Duration) time.Duration {
+		return min(time.Duration(float64(v)*d.increaseFactor), d.maxDelay)
+	})
+}
+
+// Decrease notes that the RPC completed before the delay returned by Value.
+func (d *ConcurrentDelay) Decrease() {
+	d.update(func(v time.Duration) time.Duration {
+		return max(time.Duration(float64(v)*d.decreaseFactor), d.minDelay)
+	})
+}
+
+// Update notes that the RPC either took longer than the delay or completed
+// before the delay, depending on the specified latency.
+func (d *ConcurrentDelay) Update(latency time.Duration) {
+	if latency > d.Value() {
+		d.Increase()
+	} else {
+		d.Decrease()
+	}
+}
+
+// Value returns the desired delay to wait before hedging the RPC call.
+func (d *ConcurrentDelay) Value() time.Duration {
+	return time.Duration(d.value.Load())
+}
@@ -0,0 +1,186 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewConcurrentDelay(t *testing.T) {
+	if _, err := NewConcurrentDelay(1.5, 15, time.Millisecond, time.Millisecond, time.Hour); err == nil {
+		t.Fatal("NewConcurrentDelay with an invalid percentile: got nil error")
+	}
+
+	cd, err := NewConcurrentDelay(0.99, 15, time.Second, time.Millisecond, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cd.Value(); got != 10*time.Millisecond {
+		t.Fatalf("Value: got %v, want the initial delay clamped to 10ms", got)
+	}
+}
+
+func TestConcurrentDelayMatchesDelay(t *testing.T) {
+	d, err := NewDelay(0.99, 15, time.Millisecond, time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cd, err := NewConcurrentDelay(0.99, 15, time.Millisecond, time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		latency := time.Duration(-math.Log(rnd.Float64()) * float64(10*time.Millisecond))
+		d.Update(latency)
+		cd.Update(latency)
+		if d.Value() != cd.Value() {
+			t.Fatalf("after %d updates: ConcurrentDelay %v, Delay %v", i+1, cd.Value(), d.Value())
+		}
+	}
+}
+
+func TestConcurrentDelayBounds(t *testing.T) {
+	cd, err := NewConcurrentDelay(0.99, 15, time.Millisecond, time.Millisecond, 2*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if g%2 == 0 {
+					cd.Increase()
+				} else {
+					cd.Decrease()
+				}
+				if v := cd.Value(); v < time.Millisecond || v > 2*time.Millisecond {
+					t.Errorf("Value %v is out of [1ms, 2ms]", v)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestConcurrentDelayNoLostIncrease(t *testing.T) {
+	// Without lost updates, n concurrent Increase calls multiply the value by
+	// increaseFactor^n, as n sequential calls would.
+	cd, err := NewConcurrentDelay(0.99, 15, time.Millisecond, time.Millisecond, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDelay(0.99, 15, time.Millisecond, time.Millisecond, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const goroutines, calls = 8, 20
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < calls; i++ {
+				cd.Increase()
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < goroutines*calls; i++ {
+		d.Increase()
+	}
+
+	// Truncation to nanoseconds may differ with the order of the calls.
+	if diff := math.Abs(float64(cd.Value()-d.Value())) / float64(d.Value()); diff > 1e-6 {
+		t.Fatalf("after %d concurrent increases: got %v, want %v", goroutines*calls, cd.Value(), d.Value())
+	}
+}
+
+func TestConcurrentDelayConvergence99(t *testing.T) {
+	// As TestConvergence99, with the samples applied by concurrent goroutines.
+	cd, err := NewConcurrentDelay(1-0.01, 15, time.Millisecond, time.Millisecond, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for i := 0; i < 100000; i++ {
+				cd.Update(time.Duration(-math.Log(rnd.Float64()) * float64(time.Second)))
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	// The p99 of an exponential distribution with mean 1s is ln(100)s.
+	want := math.Log(100)
+	if got := cd.Value().Seconds(); math.Abs(got-want)/want > 0.3 {
+		t.Fatalf("converged to %.3fs, want ~%.3fs", got, want)
+	}
+}
+
+// lockedDelay is a Delay behind a mutex, the baseline of the benchmarks.
+type lockedDelay struct {
+	mu sync.Mutex
+	d  *Delay
+}
+
+func (l *lockedDelay) Update(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.d.Update(latency)
+}
+
+func (l *lockedDelay) Value() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.d.Value()
+}
+
+// BenchmarkDelayContention compares the cost of Update and Value calls from
+// many goroutines on a ConcurrentDelay and on a Delay behind a mutex.
+func BenchmarkDelayContention(b *testing.B) {
+	for _, parallelism := range []int{1, 8, 64, 512} {
+		b.Run(fmt.Sprintf("concurrent/p=%d", parallelism), func(b *testing.B) {
+			cd, err := NewConcurrentDelay(0.99, 15, time.Millisecond, time.Millisecond, time.Hour)
+			if err != nil {
+				b.Fatal(err)
+			}
+			benchmarkDelay(b, parallelism, cd.Update, cd.Value)
+		})
+		b.Run(fmt.Sprintf("mutex/p=%d", parallelism), func(b *testing.B) {
+			d, err := NewDelay(0.99, 15, time.Millisecond, time.Millisecond, time.Hour)
+			if err != nil {
+				b.Fatal(err)
+			}
+			l := &lockedDelay{d: d}
+			benchmarkDelay(b, parallelism, l.Update, l.Value)
+		})
+	}
+}
+
+func benchmarkDelay(b *testing.B, parallelism int, update func(time.Duration), value func() time.Duration) {
+	b.SetParallelism(parallelism)
+	b.RunParallel(func(pb *testing.PB) {
+		latency := time.Duration(0)
+		for pb.Next() {
+			// A hedged call reads the threshold and then reports its latency.
+			_ = value()
+			latency = (latency + 7*time.Millisecond) % (20 * time.Millisecond)
+			update(latency)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DelayConfig holds the arguments of NewConcurrentDelay.
+type DelayConfig struct {
+	TargetPercentile float64
+	IncreaseRate     float64
+	InitialDelay     time.Duration
+	MinDelay         time.Duration
+	MaxDelay         time.Duration
+}
+
+// DelaySnapshot is the state of one key of a DelayRegistry.
+type DelaySnapshot struct {
+	Key      string
+	Value    time.Duration
+	LastUsed time.Time
+}
+
+type delayEntry struct {
+	delay    *ConcurrentDelay
+	lastUsed atomic.Int64 // Unix nanoseconds.
+}
+
+// DelayRegistry keeps a separate ConcurrentDelay per key, e.g. per operation
+// type, object size class or transport, as their latencies can differ by
+// orders of magnitude. Keys not used for the idle timeout are evicted, and
+// start over from the initial delay if they are used again.
+//
+// DelayRegistry is goroutine-safe.
+type DelayRegistry struct {
+	cfg         DelayConfig
+	idleTimeout time.Duration
+	now         func() time.Time
+
+	mu        sync.RWMutex
+	entries   map[string]*delayEntry
+	lastSweep time.Time
+}
+
+// NewDelayRegistry returns an empty DelayRegistry creating delays with cfg.
+// An idleTimeout of 0 never evicts keys.
+func NewDelayRegistry(cfg DelayConfig, idleTimeout time.Duration) (*DelayRegistry, error) {
+	if _, err := cfg.newDelay(); err != nil {
+		return nil, err
+	}
+	if idleTimeout < 0 {
+		return nil, fmt.Errorf("invalid idleTimeout (%v): must be >= 0", idleTimeout)
+	}
+	return &DelayRegistry{
+		cfg:         cfg,
+		idleTimeout: idleTimeout,
+		now:         time.Now,
+		entries:     make(map[string]*delayEntry),
+		lastSweep:   time.Now(),
+	}, nil
+}
+
+func (c DelayConfig) newDelay() (*ConcurrentDelay, error) {
+	return NewConcurrentDelay(c.TargetPercentile, c.IncreaseRate, c.InitialDelay, c.MinDelay, c.MaxDelay)
+}
+
+// Get returns the delay of key, creating it if needed. Every idle timeout, Get
+// also evicts the idle keys.
+func (r *DelayRegistry) Get(key string) *ConcurrentDelay {
+	now := r.now()
+
+	r.mu.RLock()
+	e, ok := r.entries[key]
+	sweep := r.idleTimeout > 0 && now.Sub(r.lastSweep) >= r.idleTimeout
+	r.mu.RUnlock()
+
+	if !ok {
+		r.mu.Lock()
+		if e, ok = r.entries[key]; !ok {
+			// cfg was validated by NewDelayRegistry.
+			d, _ := r.cfg.newDelay()
+			e = &delayEntry{delay: d}
+			r.entries[key] = e
+		}
+		r.mu.Unlock()
+	}
+	e.lastUsed.Store(now.UnixNano())
+
+	if sweep {
+		r.EvictIdle()
+	}
+	return e.delay
+}
+
+// EvictIdle removes the keys not used for the idle timeout and returns how
+// many were removed.
+func (r *DelayRegistry) EvictIdle() int {
+	if r.idleTimeout == 0 {
+		return 0
+	}
+	now := r.now()
+	deadline := now.Add(-r.idleTimeout).UnixNano()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastSweep = now
+	evicted := 0
+	for key, e := range r.entries {
+		if e.lastUsed.Load() <= deadline {
+			delete(r.entries, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// Len returns the number of keys.
+func (r *DelayRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.entries)
+}
+
+// Snapshot returns the current delay of every key, sorted by key.
+func (r *DelayRegistry) Snapshot() []DelaySnapshot {
+	r.mu.RLock()
+	snapshot := make([]DelaySnapshot, 0, len(r.entries))
+	for key, e := range r.entries {
+		snapshot = append(snapshot, DelaySnapshot{
+			Key:      key,
+			Value:    e.delay.Value(),
+			LastUsed: time.Unix(0, e.lastUsed.Load()),
+		})
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Key < snapshot[j].Key })
+	return snapshot
+}
+
+// SizeClass returns a key component grouping sizes by power of 2, e.g.
+// "<=64KiB" for 40000 bytes.
+func SizeClass(size int64) string {
+	class := int64(1)
+	for class < size {
+		class <<= 1
+	}
+	switch {
+	case class >= 1<<30:
+		return fmt.Sprintf("<=%dGiB", class>>30)
+	case class >= 1<<20:
+		return fmt.Sprintf("<=%dMiB", class>>20)
+	case class >= 1<<10:
+		return fmt.Sprintf("<=%dKiB", class>>10)
+	default:
+		return fmt.Sprintf("<=%dB", class)
+	}
+}
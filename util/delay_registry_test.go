@@ -0,0 +1,214 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testDelayConfig = DelayConfig{
+	TargetPercentile: 0.99,
+	IncreaseRate:     15,
+	InitialDelay:     10 * time.Millisecond,
+	MinDelay:         time.Millisecond,
+	MaxDelay:         time.Hour,
+}
+
+// fakeClock is a settable DelayRegistry clock.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestRegistry(t *testing.T, idleTimeout time.Duration) (*DelayRegistry, *fakeClock) {
+	t.Helper()
+	r, err := NewDelayRegistry(testDelayConfig, idleTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	r.now = clock.Now
+	r.lastSweep = clock.Now()
+	return r, clock
+}
+
+func TestNewDelayRegistry(t *testing.T) {
+	cfg := testDelayConfig
+	cfg.TargetPercentile = 1.5
+	if _, err := NewDelayRegistry(cfg, time.Minute); err == nil {
+		t.Fatal("NewDelayRegistry with an invalid percentile: got nil error")
+	}
+	if _, err := NewDelayRegistry(testDelayConfig, -time.Minute); err == nil {
+		t.Fatal("NewDelayRegistry with a negative idle timeout: got nil error")
+	}
+}
+
+func TestDelayRegistryGet(t *testing.T) {
+	r, _ := newTestRegistry(t, time.Minute)
+
+	small := r.Get("small")
+	if small != r.Get("small") {
+		t.Fatal("Get returned a different delay for the same key")
+	}
+	large := r.Get("large")
+	if small == large {
+		t.Fatal("Get returned the same delay for different keys")
+	}
+
+	// The keys adapt independently.
+	for i := 0; i < 100; i++ {
+		small.Update(time.Microsecond)
+		large.Update(time.Second)
+	}
+	if small.Value() >= testDelayConfig.InitialDelay || large.Value() <= testDelayConfig.InitialDelay {
+		t.Fatalf("got small %v, large %v, want them on either side of %v", small.Value(), large.Value(), testDelayConfig.InitialDelay)
+	}
+	if r.Len() != 2 {
+		t.Fatalf("Len: got %d, want 2", r.Len())
+	}
+}
+
+func TestDelayRegistryEvictIdle(t *testing.T) {
+	r, clock := newTestRegistry(t, time.Minute)
+
+	idle := r.Get("idle")
+	idle.Increase()
+	clock.Advance(30 * time.Second)
+	r.Get("active")
+	clock.Advance(40 * time.Second)
+
+	if n := r.EvictIdle(); n != 1 {
+		t.Fatalf("EvictIdle: got %d, want 1", n)
+	}
+	if s := r.Snapshot(); len(s) != 1 || s[0].Key != "active" {
+		t.Fatalf("Snapshot after eviction: got %+v, want only active", s)
+	}
+	// An evicted key starts over from the initial delay.
+	if d := r.Get("idle"); d == idle || d.Value() != testDelayConfig.InitialDelay {
+		t.Fatalf("Get after eviction: got %v, want a new delay of %v", d.Value(), testDelayConfig.InitialDelay)
+	}
+}
+
+func TestDelayRegistryGetSweeps(t *testing.T) {
+	r, clock := newTestRegistry(t, time.Minute)
+
+	r.Get("idle")
+	clock.Advance(2 * time.Minute)
+	r.Get("active")
+	if r.Len() != 1 {
+		t.Fatalf("Len: got %d, want 1 after Get swept the idle key", r.Len())
+	}
+}
+
+func TestDelayRegistryNoIdleTimeout(t *testing.T) {
+	r, clock := newTestRegistry(t, 0)
+
+	r.Get("key")
+	clock.Advance(24 * time.Hour)
+	r.Get("other")
+	if n := r.EvictIdle(); n != 0 || r.Len() != 2 {
+		t.Fatalf("EvictIdle: got %d evicted, %d left, want 0 and 2", n, r.Len())
+	}
+}
+
+func TestDelayRegistrySnapshot(t *testing.T) {
+	r, clock := newTestRegistry(t, time.Minute)
+
+	for _, key := range []string{"c", "a", "b"} {
+		r.Get(key)
+		clock.Advance(time.Second)
+	}
+	r.Get("b").Increase()
+
+	s := r.Snapshot()
+	if len(s) != 3 || s[0].Key != "a" || s[1].Key != "b" || s[2].Key != "c" {
+		t.Fatalf("Snapshot: got %+v, want keys a, b, c", s)
+	}
+	if s[1].Value <= testDelayConfig.InitialDelay || s[0].Value != testDelayConfig.InitialDelay {
+		t.Fatalf("Snapshot values: got %+v", s)
+	}
+	if want := time.Unix(1003, 0); !s[1].LastUsed.Equal(want) {
+		t.Fatalf("LastUsed of b: got %v, want %v", s[1].LastUsed, want)
+	}
+}
+
+func TestDelayRegistryConcurrent(t *testing.T) {
+	r, err := NewDelayRegistry(testDelayConfig, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				r.Get(fmt.Sprintf("key-%d", (g+i)%4)).Update(time.Duration(i) * time.Microsecond)
+				if i%100 == 0 {
+					r.Snapshot()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if r.Len() > 4 {
+		t.Fatalf("Len: got %d, want <= 4", r.Len())
+	}
+}
+
+func TestRegistryHedger(t *testing.T) {
+	r, _ := newTestRegistry(t, time.Minute)
+	h := NewRegistryHedger(r)
+
+	res, err := HedgeKey(context.Background(), h, "fast", func(ctx context.Context) (int, error) {
+		return 1, nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Cancel()
+
+	if got := h.Threshold("fast"); got >= testDelayConfig.InitialDelay {
+		t.Fatalf("Threshold of fast: got %v, want it decreased below %v", got, testDelayConfig.InitialDelay)
+	}
+	s := h.Stats()
+	if s.Calls != 1 || len(s.Thresholds) != 1 || s.Thresholds[0].Key != "fast" {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestSizeClass(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "<=1B"},
+		{1, "<=1B"},
+		{1000, "<=1KiB"},
+		{1024, "<=1KiB"},
+		{40000, "<=64KiB"},
+		{1 << 20, "<=1MiB"},
+		{100 << 20, "<=128MiB"},
+		{3 << 30, "<=4GiB"},
+	}
+	for _, tt := range tests {
+		if got := SizeClass(tt.size); got != tt.want {
+			t.Errorf("SizeClass(%d): got %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
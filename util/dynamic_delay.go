@@ -9,7 +9,7 @@ import (
 // Dynamic delay calculates the delay at a fixed percentile, based on
 // delay samples.
 
-// Delay is not goroutine-safe.
+// Delay is not goroutine-safe, see ConcurrentDelay.
 type Delay struct {
 	increaseFactor float64
 	decreaseFactor float64
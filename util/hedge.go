@@ -0,0 +1,260 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hedger decides when to hedge a call: once a call has been running for
+// longer than the Delay value, a backup call is started and the first one to
+// succeed is used. The latency of every call is fed back to the Delay, so the
+// hedge threshold tracks its target percentile.
+//
+// A Hedger either uses a single delay, or a delay per key of a DelayRegistry,
+// see HedgeKey.
+//
+// Hedger is goroutine-safe.
+type Hedger struct {
+	delay  *ConcurrentDelay
+	delays *DelayRegistry
+
+	// mu guards the histograms.
+	mu             sync.Mutex
+	latency        *Histogram
+	primaryLatency *Histogram
+
+	calls      atomic.Uint64
+	hedged     atomic.Uint64
+	backupWins atomic.Uint64
+}
+
+// HedgeStats reports the calls made through a Hedger.
+type HedgeStats struct {
+	// Calls is the number of hedged calls, Hedged the number of them that
+	// started a backup call, and BackupWins the number of them that used the
+	// result of the backup call.
+	Calls      uint64
+	Hedged     uint64
+	BackupWins uint64
+
+	// Threshold is the current hedge delay, or 0 with a DelayRegistry.
+	Threshold time.Duration
+
+	// Thresholds are the current hedge delays of every key with a
+	// DelayRegistry.
+	Thresholds []DelaySnapshot
+
+	// Latency is the latency of the calls, as seen by the caller.
+	Latency *Histogram
+
+	// PrimaryLatency is the latency of the first call alone, i.e. the latency
+	// without hedging. When the backup call won, the first call is cancelled
+	// and the time it ran until then is recorded, so the percentiles of
+	// PrimaryLatency are lower bounds.
+	PrimaryLatency *Histogram
+}
+
+// HedgeRate returns the fraction of calls that started a backup call.
+func (s HedgeStats) HedgeRate() float64 {
+	if s.Calls == 0 {
+		return 0
+	}
+	return float64(s.Hedged) / float64(s.Calls)
+}
+
+// TailImprovement returns by how much hedging lowered the latency at
+// percentile p. It is a lower bound, see PrimaryLatency.
+func (s HedgeStats) TailImprovement(p float64) time.Duration {
+	return s.PrimaryLatency.Percentile(p) - s.Latency.Percentile(p)
+}
+
+// NewHedger returns a Hedger using delay as the hedge threshold.
+func NewHedger(delay *ConcurrentDelay) *Hedger {
+	return &Hedger{
+		delay:          delay,
+		latency:        NewHistogram(),
+		primaryLatency: NewHistogram(),
+	}
+}
+
+// NewRegistryHedger returns a Hedger using the delay of the key of each call
+// in delays as the hedge threshold.
+func NewRegistryHedger(delays *DelayRegistry) *Hedger {
+	return &Hedger{
+		delays:         delays,
+		latency:        NewHistogram(),
+		primaryLatency: NewHistogram(),
+	}
+}
+
+// delayFor returns the delay of key.
+func (h *Hedger) delayFor(key string) *ConcurrentDelay {
+	if h.delays != nil {
+		return h.delays.Get(key)
+	}
+	return h.delay
+}
+
+// Threshold returns the current hedge delay of key. key is ignored without a
+// DelayRegistry.
+func (h *Hedger) Threshold(key string) time.Duration {
+	return h.delayFor(key).Value()
+}
+
+// record accounts a completed call. Only the latency of a successful call
+// updates the hedge delay: fast failures, e.g. of a canceled context, would
+// drag it down and hedge every later call.
+func (h *Hedger) record(delay *ConcurrentDelay, latency, primaryLatency time.Duration, hedged, backupWon, failed bool) {
+	h.calls.Add(1)
+	if hedged {
+		h.hedged.Add(1)
+	}
+	if backupWon {
+		h.backupWins.Add(1)
+	}
+
+	if !failed {
+		delay.Update(latency)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latency.Record(latency)
+	h.primaryLatency.Record(primaryLatency)
+}
+
+// Stats returns the calls so far.
+func (h *Hedger) Stats() HedgeStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := HedgeStats{
+		Calls:          h.calls.Load(),
+		Hedged:         h.hedged.Load(),
+		BackupWins:     h.backupWins.Load(),
+		Latency:        NewHistogram(),
+		PrimaryLatency: NewHistogram(),
+	}
+	if h.delays != nil {
+		s.Thresholds = h.delays.Snapshot()
+	} else {
+		s.Threshold = h.delay.Value()
+	}
+	s.Latency.Merge(h.latency)
+	s.PrimaryLatency.Merge(h.primaryLatency)
+	return s
+}
+
+// HedgeResult is the result of the call used by Hedge.
+type HedgeResult[T any] struct {
+	Value T
+
+	// Backup reports whether the value comes from the backup call.
+	Backup bool
+
+	// Cancel cancels the context of the call that returned Value. It must be
+	// called once Value, e.g. a reader, is no longer used.
+	Cancel context.CancelFunc
+}
+
+type callResult[T any] struct {
+	value  T
+	err    error
+	backup bool
+	end    time.Time
+}
+
+// Hedge runs call, and a backup call if the first one hasn't returned after
+// the hedge threshold. It returns the result of the first call to succeed, or
+// the first error if both fail. The other call is cancelled, and release is
+// called on its value if it succeeds anyway, e.g. to close a reader. release
+// may be nil.
+//
+// The latency fed back to the Delay is the latency seen by the caller, from
+// the start of the first call.
+func Hedge[T any](ctx context.Context, h *Hedger, call func(ctx context.Context) (T, error), release func(T)) (HedgeResult[T], error) {
+	return HedgeKey(ctx, h, "", call, release)
+}
+
+// HedgeKey is Hedge using the delay of key when h has a DelayRegistry.
+func HedgeKey[T any](ctx context.Context, h *Hedger, key string, call func(ctx context.Context) (T, error), release func(T)) (HedgeResult[T], error) {
+	delay := h.delayFor(key)
+	start := time.Now()
+	results := make(chan callResult[T], 2)
+	var cancels [2]context.CancelFunc
+	launch := func(backup bool) {
+		callCtx, cancel := context.WithCancel(ctx)
+		i := 0
+		if backup {
+			i = 1
+		}
+		cancels[i] = cancel
+		go func() {
+			v, err := call(callCtx)
+			results <- callResult[T]{value: v, err: err, backup: backup, end: time.Now()}
+		}()
+	}
+
+	launch(false)
+	timer := time.NewTimer(delay.Value())
+	defer timer.Stop()
+
+	var first *callResult[T]
+	var primaryEnd time.Time
+	running := 1
+	hedged := false
+	for {
+		select {
+		case <-timer.C:
+			if !hedged {
+				hedged = true
+				running++
+				launch(true)
+			}
+			continue
+		case r := <-results:
+			running--
+			if !r.backup {
+				primaryEnd = r.end
+			}
+			if r.err != nil && running > 0 {
+				// Wait for the other call.
+				first = &r
+				continue
+			}
+			if r.err != nil && first != nil {
+				// Both failed, return the first error.
+				r = *first
+			}
+
+			// A cancelled first call ran until now.
+			if primaryEnd.IsZero() {
+				primaryEnd = time.Now()
+			}
+			h.record(delay, r.end.Sub(start), primaryEnd.Sub(start), hedged, r.err == nil && r.backup, r.err != nil)
+
+			winner, loser := 0, 1
+			if r.backup {
+				winner, loser = 1, 0
+			}
+			if cancels[loser] != nil {
+				cancels[loser]()
+			}
+			if running > 0 {
+				go func() {
+					if l := <-results; l.err == nil && release != nil {
+						release(l.value)
+					}
+				}()
+			}
+
+			if r.err != nil {
+				cancels[winner]()
+				return HedgeResult[T]{}, r.err
+			}
+			return HedgeResult[T]{Value: r.value, Backup: r.backup, Cancel: cancels[winner]}, nil
+		}
+	}
+}
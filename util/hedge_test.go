@@ -0,0 +1,167 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestHedger(t *testing.T, threshold time.Duration) *Hedger {
+	t.Helper()
+	d, err := NewConcurrentDelay(0.99, 15, threshold, time.Millisecond, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHedger(d)
+}
+
+func TestHedgeFastCallIsNotHedged(t *testing.T) {
+	h := newTestHedger(t, 50*time.Millisecond)
+	var calls atomic.Int32
+
+	res, err := Hedge(context.Background(), h, func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		return 42, nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Cancel()
+
+	if res.Value != 42 || res.Backup {
+		t.Fatalf("got %+v, want the value of the first call", res)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("got %d calls, want 1", calls.Load())
+	}
+	if s := h.Stats(); s.Calls != 1 || s.Hedged != 0 || s.BackupWins != 0 || s.HedgeRate() != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if h.Threshold("") >= 50*time.Millisecond {
+		t.Fatalf("threshold: got %v, want it decreased below 50ms", h.Threshold(""))
+	}
+}
+
+func TestHedgeBackupWins(t *testing.T) {
+	h := newTestHedger(t, 10*time.Millisecond)
+	var calls atomic.Int32
+	released := make(chan int, 1)
+
+	start := time.Now()
+	res, err := Hedge(context.Background(), h, func(ctx context.Context) (int, error) {
+		if calls.Add(1) == 1 {
+			// The first call is stuck and ignores cancellation.
+			time.Sleep(100 * time.Millisecond)
+			return 1, nil
+		}
+		return 2, nil
+	}, func(v int) { released <- v })
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Cancel()
+
+	if res.Value != 2 || !res.Backup {
+		t.Fatalf("got %+v, want the value of the backup call", res)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("Hedge took %v, want ~10ms", elapsed)
+	}
+	if v := <-released; v != 1 {
+		t.Fatalf("released %d, want the value of the losing call", v)
+	}
+
+	s := h.Stats()
+	if s.Calls != 1 || s.Hedged != 1 || s.BackupWins != 1 || s.HedgeRate() != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if s.TailImprovement(0.99) < 0 {
+		t.Fatalf("TailImprovement: got %v, want >= 0", s.TailImprovement(0.99))
+	}
+}
+
+func TestHedgeCancelsLoser(t *testing.T) {
+	h := newTestHedger(t, 5*time.Millisecond)
+	var calls atomic.Int32
+	cancelled := make(chan struct{})
+
+	res, err := Hedge(context.Background(), h, func(ctx context.Context) (int, error) {
+		if calls.Add(1) == 1 {
+			<-ctx.Done()
+			close(cancelled)
+			return 0, ctx.Err()
+		}
+		return 2, nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the losing call was not cancelled")
+	}
+}
+
+func TestHedgeFirstCallFailsAfterHedging(t *testing.T) {
+	h := newTestHedger(t, 5*time.Millisecond)
+	var calls atomic.Int32
+
+	res, err := Hedge(context.Background(), h, func(ctx context.Context) (int, error) {
+		if calls.Add(1) == 1 {
+			time.Sleep(10 * time.Millisecond)
+			return 0, errors.New("first failed")
+		}
+		time.Sleep(20 * time.Millisecond)
+		return 2, nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("got error %v, want the value of the backup call", err)
+	}
+	defer res.Cancel()
+	if res.Value != 2 || !res.Backup {
+		t.Fatalf("got %+v, want the value of the backup call", res)
+	}
+}
+
+func TestHedgeBothFail(t *testing.T) {
+	h := newTestHedger(t, 5*time.Millisecond)
+	var calls atomic.Int32
+	firstErr := errors.New("first failed")
+
+	_, err := Hedge(context.Background(), h, func(ctx context.Context) (int, error) {
+		if calls.Add(1) == 1 {
+			time.Sleep(10 * time.Millisecond)
+			return 0, firstErr
+		}
+		time.Sleep(10 * time.Millisecond)
+		return 0, errors.New("backup failed")
+	}, nil)
+	if !errors.Is(err, firstErr) {
+		t.Fatalf("got error %v, want %v", err, firstErr)
+	}
+}
+
+func TestHedgeFailuresKeepThreshold(t *testing.T) {
+	h := newTestHedger(t, 50*time.Millisecond)
+	notFound := errors.New("not found")
+
+	// A burst of fast failures is counted, but leaves the threshold alone.
+	for range 100 {
+		if _, err := Hedge(context.Background(), h, func(ctx context.Context) (int, error) {
+			return 0, notFound
+		}, nil); !errors.Is(err, notFound) {
+			t.Fatalf("got error %v, want %v", err, notFound)
+		}
+	}
+	if s := h.Stats(); s.Calls != 100 || s.Hedged != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if got := h.Threshold(""); got != 50*time.Millisecond {
+		t.Fatalf("threshold: got %v after failures, want 50ms", got)
+	}
+}
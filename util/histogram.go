@@ -0,0 +1,154 @@
+package util
+
+import (
+	"math"
+	"time"
+)
+
+// Histogram is a latency histogram with logarithmic buckets: every power of
+// two of microseconds is split into histogramSubBuckets buckets, which bounds
+// the relative error of a percentile to about 9%. All histograms share the
+// same bucket layout, so they can be merged.
+//
+// Histogram is not goroutine-safe.
+type Histogram struct {
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+const (
+	histogramSubBuckets = 8
+	// The last bucket holds every latency above 2^32us, i.e. ~71 minutes.
+	histogramBuckets = 32 * histogramSubBuckets
+)
+
+// HistogramBucket is a bucket of a Histogram, holding the latencies in
+// (previous bucket UpperBound, UpperBound].
+type HistogramBucket struct {
+	UpperBound time.Duration
+	Count      uint64
+}
+
+// NewHistogram returns an empty Histogram.
+func NewHistogram() *Histogram {
+	return &Histogram{counts: make([]uint64, histogramBuckets)}
+}
+
+// bucketIndex returns the bucket holding latency d.
+func bucketIndex(d time.Duration) int {
+	us := float64(d) / float64(time.Microsecond)
+	if us <= 1 {
+		return 0
+	}
+	i := int(math.Ceil(math.Log2(us)*histogramSubBuckets)) - 1
+	return min(max(i, 0), histogramBuckets-1)
+}
+
+// bucketUpperBound returns the largest latency held by bucket i.
+func bucketUpperBound(i int) time.Duration {
+	if i == histogramBuckets-1 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(math.Exp2(float64(i+1)/histogramSubBuckets) * float64(time.Microsecond))
+}
+
+// Record adds latency d to the histogram.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucketIndex(d)]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+// Merge adds all the latencies recorded by other to h.
+func (h *Histogram) Merge(other *Histogram) {
+	if other.count == 0 {
+		return
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count
+	h.sum += other.sum
+}
+
+// Count returns the number of recorded latencies.
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Min returns the smallest recorded latency, or 0 if the histogram is empty.
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+// Max returns the largest recorded latency, or 0 if the histogram is empty.
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean returns the mean of the recorded latencies, or 0 if the histogram is
+// empty.
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Percentile returns the latency at percentile p in [0, 1], e.g. 0.99 for
+// p99. The result is the upper bound of the bucket holding that latency,
+// clamped to [Min, Max], or 0 if the histogram is empty.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := max(uint64(math.Ceil(p*float64(h.count))), 1)
+
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return min(max(bucketUpperBound(i), h.min), h.max)
+		}
+	}
+	return h.max
+}
+
+// Buckets returns the non-empty buckets in increasing latency order.
+func (h *Histogram) Buckets() []HistogramBucket {
+	var buckets []HistogramBucket
+	for i, c := range h.counts {
+		if c > 0 {
+			buckets = append(buckets, HistogramBucket{UpperBound: bucketUpperBound(i), Count: c})
+		}
+	}
+	return buckets
+}
+
+// AllBuckets returns every bucket, including the empty ones, in increasing
+// latency order. The buckets are the same for all histograms.
+func (h *Histogram) AllBuckets() []HistogramBucket {
+	buckets := make([]HistogramBucket, len(h.counts))
+	for i, c := range h.counts {
+		buckets[i] = HistogramBucket{UpperBound: bucketUpperBound(i), Count: c}
+	}
+	return buckets
+}
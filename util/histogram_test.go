@@ -0,0 +1,98 @@
+package util
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHistogramEmpty(t *testing.T) {
+	h := NewHistogram()
+	if h.Count() != 0 || h.Min() != 0 || h.Max() != 0 || h.Mean() != 0 || h.Percentile(0.99) != 0 {
+		t.Fatalf("empty histogram: count %d, min %v, max %v, mean %v, p99 %v", h.Count(), h.Min(), h.Max(), h.Mean(), h.Percentile(0.99))
+	}
+	if b := h.Buckets(); len(b) != 0 {
+		t.Fatalf("empty histogram has buckets: %v", b)
+	}
+	if b := h.AllBuckets(); len(b) != histogramBuckets {
+		t.Fatalf("empty histogram has %d buckets, want %d", len(b), histogramBuckets)
+	}
+}
+
+func TestHistogramBucketBounds(t *testing.T) {
+	for _, d := range []time.Duration{0, 1, time.Microsecond, 1500 * time.Nanosecond, time.Millisecond, 123 * time.Millisecond, time.Second, time.Hour} {
+		i := bucketIndex(d)
+		if d > bucketUpperBound(i) {
+			t.Errorf("%v is above the upper bound %v of its bucket %d", d, bucketUpperBound(i), i)
+		}
+		if i > 0 && d <= bucketUpperBound(i-1) {
+			t.Errorf("%v belongs to bucket %d, with upper bound %v", d, i-1, bucketUpperBound(i-1))
+		}
+	}
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	h := NewHistogram()
+	samples := make([]time.Duration, 100000)
+	for i := range samples {
+		samples[i] = time.Duration(-math.Log(rnd.Float64()) * float64(10*time.Millisecond))
+		h.Record(samples[i])
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	if h.Count() != uint64(len(samples)) {
+		t.Fatalf("Count: got %d, want %d", h.Count(), len(samples))
+	}
+	if h.Min() != samples[0] || h.Max() != samples[len(samples)-1] {
+		t.Fatalf("Min, Max: got %v, %v, want %v, %v", h.Min(), h.Max(), samples[0], samples[len(samples)-1])
+	}
+	for _, p := range []float64{0.5, 0.9, 0.99, 0.999} {
+		want := samples[int(math.Ceil(p*float64(len(samples))))-1]
+		got := h.Percentile(p)
+		if got < want || float64(got) > float64(want)*1.1 {
+			t.Errorf("Percentile(%v): got %v, want within 10%% above %v", p, got, want)
+		}
+	}
+	if h.Percentile(1) != h.Max() {
+		t.Errorf("Percentile(1): got %v, want %v", h.Percentile(1), h.Max())
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b, all := NewHistogram(), NewHistogram(), NewHistogram()
+	for i := 1; i <= 1000; i++ {
+		d := time.Duration(i) * time.Millisecond
+		if i%3 == 0 {
+			a.Record(d)
+		} else {
+			b.Record(d)
+		}
+		all.Record(d)
+	}
+
+	merged := NewHistogram()
+	merged.Merge(a)
+	merged.Merge(b)
+	merged.Merge(NewHistogram())
+
+	if merged.Count() != all.Count() || merged.Min() != all.Min() || merged.Max() != all.Max() || merged.Mean() != all.Mean() {
+		t.Fatalf("merged: count %d, min %v, max %v, mean %v, want %d, %v, %v, %v",
+			merged.Count(), merged.Min(), merged.Max(), merged.Mean(), all.Count(), all.Min(), all.Max(), all.Mean())
+	}
+	for _, p := range []float64{0.5, 0.9, 0.99} {
+		if merged.Percentile(p) != all.Percentile(p) {
+			t.Errorf("Percentile(%v): got %v, want %v", p, merged.Percentile(p), all.Percentile(p))
+		}
+	}
+
+	var total uint64
+	for _, b := range merged.Buckets() {
+		total += b.Count
+	}
+	if total != all.Count() {
+		t.Fatalf("bucket counts sum to %d, want %d", total, all.Count())
+	}
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// LatencyTrace is the sequence of read latencies of one metrics CSV file, as
+// written by the read benchmarks:
+//
+//	Timestamp,ReadLatency(s),Throughput(MiB/s)
+//	1725522018,0.110,0.000
+type LatencyTrace struct {
+	// Name is the base name of the file.
+	Name string
+
+	// Timestamps are the Unix times of the reads, in seconds.
+	Timestamps []int64
+	Latencies  []time.Duration
+}
+
+// ReadLatencyTrace parses the metrics CSV file at path.
+func ReadLatencyTrace(path string) (LatencyTrace, error) {
+	trace := LatencyTrace{Name: filepath.Base(path)}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return trace, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	// Skip the header row.
+	if _, err := r.Read(); err != nil {
+		return trace, fmt.Errorf("while reading the header of %s: %w", path, err)
+	}
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return trace, fmt.Errorf("while reading %s: %w", path, err)
+		}
+		if len(record) < 2 {
+			return trace, fmt.Errorf("%s: line %d: got %d fields, want at least 2", path, len(trace.Latencies)+2, len(record))
+		}
+		timestamp, err := strconv.ParseInt(record[0], 10, 64)
+		if err != nil {
+			return trace, fmt.Errorf("%s: line %d: invalid timestamp: %w", path, len(trace.Latencies)+2, err)
+		}
+		latency, err := strconv.ParseFloat(record[1], 64)
+		if err != nil {
+			return trace, fmt.Errorf("%s: line %d: invalid latency: %w", path, len(trace.Latencies)+2, err)
+		}
+		trace.Timestamps = append(trace.Timestamps, timestamp)
+		trace.Latencies = append(trace.Latencies, time.Duration(latency*float64(time.Second)))
+	}
+	return trace, nil
+}
+
+// ReadLatencyTraces parses every .csv file in dir and its subdirectories, in
+// lexical order of path, skipping the empty ones.
+func ReadLatencyTraces(dir string) ([]LatencyTrace, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == ".csv" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("while listing the CSV files of %s: %w", dir, err)
+	}
+	sort.Strings(paths)
+
+	var traces []LatencyTrace
+	for _, path := range paths {
+		trace, err := ReadLatencyTrace(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(trace.Latencies) > 0 {
+			traces = append(traces, trace)
+		}
+	}
+	if len(traces) == 0 {
+		return nil, fmt.Errorf("no latency in the CSV files of %s", dir)
+	}
+	return traces, nil
+}
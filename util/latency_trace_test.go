@@ -0,0 +1,67 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadLatencyTraces(t *testing.T) {
+	dir := t.TempDir()
+	const header = "Timestamp,ReadLatency(s),Throughput(MiB/s)\n"
+	writeFile(t, filepath.Join(dir, "b.csv"), header+"1725522019,0.339,0.000\n")
+	writeFile(t, filepath.Join(dir, "a.csv"), header+"1725522018,0.110,0.000\n1725522020,1.5,0.000\n")
+	writeFile(t, filepath.Join(dir, "empty.csv"), header)
+	writeFile(t, filepath.Join(dir, "notes.txt"), "not a trace")
+
+	traces, err := ReadLatencyTraces(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 2 || traces[0].Name != "a.csv" || traces[1].Name != "b.csv" {
+		t.Fatalf("got traces %+v, want a.csv and b.csv", traces)
+	}
+	a := traces[0]
+	if len(a.Latencies) != 2 || a.Latencies[0] != 110*time.Millisecond || a.Latencies[1] != 1500*time.Millisecond {
+		t.Fatalf("latencies of a.csv: got %v", a.Latencies)
+	}
+	if a.Timestamps[0] != 1725522018 || a.Timestamps[1] != 1725522020 {
+		t.Fatalf("timestamps of a.csv: got %v", a.Timestamps)
+	}
+}
+
+func TestReadLatencyTracesErrors(t *testing.T) {
+	const header = "Timestamp,ReadLatency(s),Throughput(MiB/s)\n"
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "invalid latency", content: header + "1725522018,slow,0.000\n"},
+		{name: "invalid timestamp", content: header + "now,0.1,0.000\n"},
+		{name: "missing field", content: header + "1725522018\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, "trace.csv"), tt.content)
+			if _, err := ReadLatencyTraces(dir); err == nil {
+				t.Fatal("got nil error")
+			}
+		})
+	}
+
+	if _, err := ReadLatencyTraces(t.TempDir()); err == nil {
+		t.Fatal("ReadLatencyTraces of an empty directory: got nil error")
+	}
+}
@@ -0,0 +1,198 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Inter-arrival time distributions of an OpenLoop.
+const (
+	ConstantArrivals = "constant"
+	PoissonArrivals  = "poisson"
+)
+
+// OpenLoopConfig configures an OpenLoop.
+type OpenLoopConfig struct {
+	// Rate is the target number of arrivals per second. Must be > 0.
+	Rate float64
+
+	// Distribution of the inter-arrival times, ConstantArrivals or
+	// PoissonArrivals.
+	Distribution string
+
+	// Workers is the number of goroutines serving the arrivals, i.e. the
+	// maximum number of outstanding requests. Must be > 0.
+	Workers int
+
+	// MaxBacklog is the number of arrivals that can wait for a free worker.
+	// Arrivals beyond it are dropped. 0 drops every arrival finding all the
+	// workers busy.
+	MaxBacklog int
+
+	// MaxArrivals stops the loop after this many arrivals, 0 means no limit.
+	MaxArrivals uint64
+
+	// Seed of the Poisson inter-arrival times.
+	Seed int64
+}
+
+// OpenLoopStats reports the arrivals of an OpenLoop.
+type OpenLoopStats struct {
+	// Arrivals is the number of requests due so far.
+	Arrivals uint64
+	// Issued is the number of arrivals handed to a worker.
+	Issued uint64
+	// Completed is the number of issued requests that returned.
+	Completed uint64
+	// Dropped is the number of arrivals that found the backlog full.
+	Dropped uint64
+	// Unissued is the number of arrivals left in the backlog when the loop
+	// stopped.
+	Unissued uint64
+	// Backlog is the number of arrivals currently waiting for a worker, and
+	// PeakBacklog the largest Backlog seen.
+	Backlog     int
+	PeakBacklog int
+}
+
+// OpenLoop issues requests at a fixed arrival rate, independently of how long
+// they take. Unlike closed-loop workers, which only send the next request once
+// the previous one is done, it doesn't hide the latency of an overloaded
+// server (coordinated omission): requests are handed their intended send
+// time, which latencies should be measured from.
+type OpenLoop struct {
+	cfg OpenLoopConfig
+	rnd *rand.Rand
+
+	backlog chan time.Time
+
+	arrivals    atomic.Uint64
+	issued      atomic.Uint64
+	completed   atomic.Uint64
+	dropped     atomic.Uint64
+	unissued    atomic.Uint64
+	peakBacklog atomic.Int64
+}
+
+// NewOpenLoop returns an OpenLoop.
+func NewOpenLoop(cfg OpenLoopConfig) (*OpenLoop, error) {
+	if cfg.Rate <= 0 {
+		return nil, fmt.Errorf("invalid rate (%v): must be > 0", cfg.Rate)
+	}
+	if cfg.Distribution != ConstantArrivals && cfg.Distribution != PoissonArrivals {
+		return nil, fmt.Errorf("invalid distribution %q: must be %s or %s", cfg.Distribution, ConstantArrivals, PoissonArrivals)
+	}
+	if cfg.Workers <= 0 {
+		return nil, fmt.Errorf("invalid workers (%d): must be > 0", cfg.Workers)
+	}
+	if cfg.MaxBacklog < 0 {
+		return nil, fmt.Errorf("invalid max backlog (%d): must be >= 0", cfg.MaxBacklog)
+	}
+	return &OpenLoop{
+		cfg:     cfg,
+		rnd:     rand.New(rand.NewSource(cfg.Seed)),
+		backlog: make(chan time.Time, cfg.MaxBacklog),
+	}, nil
+}
+
+// interArrival returns the time until the next arrival.
+func (o *OpenLoop) interArrival() time.Duration {
+	mean := float64(time.Second) / o.cfg.Rate
+	if o.cfg.Distribution == PoissonArrivals {
+		return time.Duration(o.rnd.ExpFloat64() * mean)
+	}
+	return time.Duration(mean)
+}
+
+// Run generates arrivals until ctx is done or MaxArrivals is reached. Each
+// arrival calls issue on one of the workers, identified by worker in
+// [0, Workers), with its intended send time. A worker serves one arrival at a
+// time. Run returns once every issued request has completed.
+//
+// Run must be called at most once.
+func (o *OpenLoop) Run(ctx context.Context, issue func(ctx context.Context, worker int, intended time.Time)) OpenLoopStats {
+	var wg sync.WaitGroup
+	for i := 0; i < o.cfg.Workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case intended, ok := <-o.backlog:
+					if !ok {
+						return
+					}
+					if ctx.Err() != nil {
+						o.unissued.Add(1)
+						return
+					}
+					o.issued.Add(1)
+					issue(ctx, worker, intended)
+					o.completed.Add(1)
+				}
+			}
+		}(i)
+	}
+
+	o.generate(ctx)
+	close(o.backlog)
+	wg.Wait()
+
+	for range o.backlog {
+		o.unissued.Add(1)
+	}
+	return o.Stats()
+}
+
+// generate sends arrivals to the backlog at their intended time. Arrivals due
+// while the generator was late are sent right away, with their original
+// intended time.
+func (o *OpenLoop) generate(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	next := time.Now()
+	for o.cfg.MaxArrivals == 0 || o.arrivals.Load() < o.cfg.MaxArrivals {
+		if wait := time.Until(next); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+
+		o.arrivals.Add(1)
+		select {
+		case o.backlog <- next:
+			if n := int64(len(o.backlog)); n > o.peakBacklog.Load() {
+				o.peakBacklog.Store(n)
+			}
+		default:
+			o.dropped.Add(1)
+		}
+		next = next.Add(o.interArrival())
+	}
+}
+
+// Stats returns the arrivals so far. It can be called while Run is running.
+func (o *OpenLoop) Stats() OpenLoopStats {
+	return OpenLoopStats{
+		Arrivals:    o.arrivals.Load(),
+		Issued:      o.issued.Load(),
+		Completed:   o.completed.Load(),
+		Dropped:     o.dropped.Load(),
+		Unissued:    o.unissued.Load(),
+		Backlog:     len(o.backlog),
+		PeakBacklog: int(o.peakBacklog.Load()),
+	}
+}
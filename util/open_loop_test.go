@@ -0,0 +1,122 @@
+package util
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewOpenLoopInvalidConfig(t *testing.T) {
+	for _, cfg := range []OpenLoopConfig{
+		{Rate: 0, Distribution: ConstantArrivals, Workers: 1},
+		{Rate: 10, Distribution: "uniform", Workers: 1},
+		{Rate: 10, Distribution: PoissonArrivals, Workers: 0},
+		{Rate: 10, Distribution: PoissonArrivals, Workers: 1, MaxBacklog: -1},
+	} {
+		if _, err := NewOpenLoop(cfg); err == nil {
+			t.Errorf("NewOpenLoop(%+v): got nil error", cfg)
+		}
+	}
+}
+
+func TestOpenLoopConstantRate(t *testing.T) {
+	o, err := NewOpenLoop(OpenLoopConfig{Rate: 1000, Distribution: ConstantArrivals, Workers: 4, MaxBacklog: 100, MaxArrivals: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var intended []time.Time
+	start := time.Now()
+	stats := o.Run(context.Background(), func(_ context.Context, worker int, t time.Time) {
+		mu.Lock()
+		intended = append(intended, t)
+		mu.Unlock()
+	})
+	elapsed := time.Since(start)
+
+	if stats.Arrivals != 200 || stats.Issued != 200 || stats.Completed != 200 || stats.Dropped != 0 || stats.Unissued != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if elapsed < 150*time.Millisecond {
+		t.Fatalf("200 arrivals at 1000/s took %v, want ~200ms", elapsed)
+	}
+
+	// Intended send times are exactly 1ms apart, however late the workers are.
+	sort.Slice(intended, func(i, j int) bool { return intended[i].Before(intended[j]) })
+	for i := 1; i < len(intended); i++ {
+		if d := intended[i].Sub(intended[i-1]); d != time.Millisecond {
+			t.Fatalf("arrivals %d and %d are intended %v apart, want 1ms", i-1, i, d)
+		}
+	}
+}
+
+func TestOpenLoopPoissonRate(t *testing.T) {
+	o, err := NewOpenLoop(OpenLoopConfig{Rate: 2000, Distribution: PoissonArrivals, Workers: 8, MaxBacklog: 1000, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	stats := o.Run(ctx, func(context.Context, int, time.Time) {})
+
+	// ~1000 arrivals expected, with a standard deviation of ~32.
+	if stats.Arrivals < 800 || stats.Arrivals > 1200 {
+		t.Fatalf("got %d arrivals in 500ms at 2000/s", stats.Arrivals)
+	}
+	if stats.Issued+stats.Dropped+stats.Unissued != stats.Arrivals {
+		t.Fatalf("arrivals are not accounted for: %+v", stats)
+	}
+}
+
+func TestOpenLoopOverload(t *testing.T) {
+	o, err := NewOpenLoop(OpenLoopConfig{Rate: 1000, Distribution: ConstantArrivals, Workers: 1, MaxBacklog: 5, MaxArrivals: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var latencies []time.Duration
+	stats := o.Run(context.Background(), func(_ context.Context, worker int, intended time.Time) {
+		time.Sleep(10 * time.Millisecond)
+		latencies = append(latencies, time.Since(intended))
+	})
+
+	if stats.Dropped == 0 {
+		t.Fatalf("a worker serving 100/s at 1000/s dropped nothing: %+v", stats)
+	}
+	if stats.PeakBacklog != 5 {
+		t.Fatalf("PeakBacklog: got %d, want 5", stats.PeakBacklog)
+	}
+	if stats.Issued+stats.Dropped != stats.Arrivals || stats.Completed != stats.Issued {
+		t.Fatalf("arrivals are not accounted for: %+v", stats)
+	}
+
+	// Requests wait in the backlog, so latency from the intended send time
+	// grows past the 10ms service time.
+	if latencies[len(latencies)-1] < 40*time.Millisecond {
+		t.Fatalf("latency of the last request: got %v, want >= 40ms", latencies[len(latencies)-1])
+	}
+}
+
+func TestOpenLoopCancel(t *testing.T) {
+	o, err := NewOpenLoop(OpenLoopConfig{Rate: 10000, Distribution: ConstantArrivals, Workers: 1, MaxBacklog: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stats := o.Run(ctx, func(ctx context.Context, worker int, intended time.Time) {
+		cancel()
+		<-ctx.Done()
+	})
+
+	if stats.Issued+stats.Dropped+stats.Unissued != stats.Arrivals {
+		t.Fatalf("arrivals are not accounted for: %+v", stats)
+	}
+	if stats.Completed != stats.Issued {
+		t.Fatalf("Run returned before every issued request completed: %+v", stats)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Read patterns supported by the --workload flag.
+const (
+	sequentialWorkload = "sequential"
+	randomWorkload     = "random"
+	stridedWorkload    = "strided"
+	mixedWorkload      = "mixed"
+)
+
+// ReadRange is a single read issued by a worker. A Length of -1 reads from
+// Offset till the end of the object.
+type ReadRange struct {
+	Offset int64
+	Length int64
+}
+
+// Workload generates the sequence of ranges a worker reads from its object.
+// A Workload is owned by a single worker and is not goroutine-safe.
+type Workload interface {
+	// Name returns the read pattern implemented by the workload.
+	Name() string
+
+	// Next returns the range for the next read call.
+	Next() ReadRange
+}
+
+// WorkloadConfig describes how to build the Workload of each worker.
+type WorkloadConfig struct {
+	// Type is one of sequential, random, strided or mixed.
+	Type string
+
+	// RangeSize is the number of bytes read per call. Zero means the whole
+	// object, which is only valid for the sequential workload.
+	RangeSize int64
+
+	// Stride is the distance between the offsets of two consecutive strided
+	// reads. Zero means 2 * RangeSize, i.e. every other range is skipped.
+	Stride int64
+
+	// MixedWeights is the relative weight of the sequential, random and
+	// strided patterns in the mixed workload.
+	MixedWeights [3]int
+}
+
+// needsObjectSize reports whether building the workload requires the size of
+// the object, which costs one metadata call per worker.
+func (c *WorkloadConfig) needsObjectSize() bool {
+	return c.Type != sequentialWorkload || c.RangeSize > 0
+}
+
+// validate checks that the config can build a workload.
+func (c *WorkloadConfig) validate() error {
+	if c.RangeSize < 0 {
+		return fmt.Errorf("invalid range size (%d): must be >= 0", c.RangeSize)
+	}
+	if c.Stride < 0 {
+		return fmt.Errorf("invalid stride (%d): must be >= 0", c.Stride)
+	}
+
+	switch c.Type {
+	case sequentialWorkload:
+		return nil
+	case randomWorkload, stridedWorkload:
+		if c.RangeSize == 0 {
+			return fmt.Errorf("%s workload requires a range size > 0", c.Type)
+		}
+		return nil
+	case mixedWorkload:
+		if c.RangeSize == 0 {
+			return fmt.Errorf("%s workload requires a range size > 0", c.Type)
+		}
+		total := 0
+		for _, w := range c.MixedWeights {
+			if w < 0 {
+				return fmt.Errorf("invalid mixed weights (%v): must be >= 0", c.MixedWeights)
+			}
+			total += w
+		}
+		if total == 0 {
+			return fmt.Errorf("invalid mixed weights (%v): at least one must be > 0", c.MixedWeights)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown workload %q: must be one of %s, %s, %s or %s",
+			c.Type, sequentialWorkload, randomWorkload, stridedWorkload, mixedWorkload)
+	}
+}
+
+// parseMixedWeights parses weights in the "sequential,random,strided" form,
+// e.g. "2,1,1".
+func parseMixedWeights(s string) (weights [3]int, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != len(weights) {
+		err = fmt.Errorf("invalid mixed weights %q: want 3 comma separated values", s)
+		return
+	}
+	for i, p := range parts {
+		weights[i], err = strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			err = fmt.Errorf("invalid mixed weights %q: %w", s, err)
+			return
+		}
+	}
+	return
+}
+
+// NewWorkload returns the Workload described by cfg for an object of
+// objectSize bytes. objectSize is ignored when cfg doesn't need it.
+func NewWorkload(cfg *WorkloadConfig, objectSize int64, rnd *rand.Rand) (Workload, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.needsObjectSize() && objectSize <= 0 {
+		return nil, fmt.Errorf("%s workload requires a non-empty object, got size %d", cfg.Type, objectSize)
+	}
+
+	rangeSize := min(cfg.RangeSize, objectSize)
+	stride := cfg.Stride
+	if stride == 0 {
+		stride = 2 * rangeSize
+	}
+
+	switch cfg.Type {
+	case sequentialWorkload:
+		return &sequentialReads{objectSize: objectSize, rangeSize: rangeSize}, nil
+	case randomWorkload:
+		return &randomReads{objectSize: objectSize, rangeSize: rangeSize, rnd: rnd}, nil
+	case stridedWorkload:
+		return &stridedReads{objectSize: objectSize, rangeSize: rangeSize, stride: stride}, nil
+	default:
+		return &mixedReads{
+			workloads: [3]Workload{
+				&sequentialReads{objectSize: objectSize, rangeSize: rangeSize},
+				&randomReads{objectSize: objectSize, rangeSize: rangeSize, rnd: rnd},
+				&stridedReads{objectSize: objectSize, rangeSize: rangeSize, stride: stride},
+			},
+			weights: cfg.MixedWeights,
+			rnd:     rnd,
+		}, nil
+	}
+}
+
+// sequentialReads reads the object front to back in rangeSize chunks and
+// wraps around at the end. A zero rangeSize reads the whole object per call.
+type sequentialReads struct {
+	objectSize int64
+	rangeSize  int64
+	offset     int64
+}
+
+func (s *sequentialReads) Name() string {
+	return sequentialWorkload
+}
+
+func (s *sequentialReads) Next() ReadRange {
+	if s.rangeSize == 0 {
+		return ReadRange{Offset: 0, Length: -1}
+	}
+
+	r := ReadRange{Offset: s.offset, Length: min(s.rangeSize, s.objectSize-s.offset)}
+	s.offset += r.Length
+	if s.offset >= s.objectSize {
+		s.offset = 0
+	}
+	return r
+}
+
+// randomReads reads rangeSize bytes at a uniformly random offset.
+type randomReads struct {
+	objectSize int64
+	rangeSize  int64
+	rnd        *rand.Rand
+}
+
+func (r *randomReads) Name() string {
+	return randomWorkload
+}
+
+func (r *randomReads) Next() ReadRange {
+	return ReadRange{Offset: r.rnd.Int63n(r.objectSize - r.rangeSize + 1), Length: r.rangeSize}
+}
+
+// stridedReads reads rangeSize bytes every stride bytes and wraps around at
+// the end of the object.
+type stridedReads struct {
+	objectSize int64
+	rangeSize  int64
+	stride     int64
+	offset     int64
+}
+
+func (s *stridedReads) Name() string {
+	return stridedWorkload
+}
+
+func (s *stridedReads) Next() ReadRange {
+	r := ReadRange{Offset: s.offset, Length: min(s.rangeSize, s.objectSize-s.offset)}
+	s.offset += s.stride
+	if s.offset >= s.objectSize {
+		s.offset = 0
+	}
+	return r
+}
+
+// mixedReads picks one of the sequential, random and strided patterns per
+// call, proportionally to weights.
+type mixedReads struct {
+	workloads [3]Workload
+	weights   [3]int
+	rnd       *rand.Rand
+}
+
+func (m *mixedReads) Name() string {
+	return mixedWorkload
+}
+
+func (m *mixedReads) Next() ReadRange {
+	total := 0
+	for _, w := range m.weights {
+		total += w
+	}
+
+	n := m.rnd.Intn(total)
+	for i, w := range m.weights {
+		if n < w {
+			return m.workloads[i].Next()
+		}
+		n -= w
+	}
+	return m.workloads[len(m.workloads)-1].Next()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWorkloadNext(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        WorkloadConfig
+		objectSize int64
+		want       []ReadRange
+	}{
+		{
+			name:       "sequential whole object",
+			cfg:        WorkloadConfig{Type: sequentialWorkload},
+			objectSize: 0,
+			want:       []ReadRange{{0, -1}, {0, -1}},
+		},
+		{
+			name:       "sequential wraps around",
+			cfg:        WorkloadConfig{Type: sequentialWorkload, RangeSize: 4},
+			objectSize: 10,
+			want:       []ReadRange{{0, 4}, {4, 4}, {8, 2}, {0, 4}},
+		},
+		{
+			name:       "sequential range above object size",
+			cfg:        WorkloadConfig{Type: sequentialWorkload, RangeSize: 16},
+			objectSize: 10,
+			want:       []ReadRange{{0, 10}, {0, 10}},
+		},
+		{
+			name:       "strided default stride",
+			cfg:        WorkloadConfig{Type: stridedWorkload, RangeSize: 2},
+			objectSize: 10,
+			want:       []ReadRange{{0, 2}, {4, 2}, {8, 2}, {0, 2}},
+		},
+		{
+			name:       "strided wraps around",
+			cfg:        WorkloadConfig{Type: stridedWorkload, RangeSize: 2, Stride: 3},
+			objectSize: 10,
+			want:       []ReadRange{{0, 2}, {3, 2}, {6, 2}, {9, 1}, {0, 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := NewWorkload(&tt.cfg, tt.objectSize, rand.New(rand.NewSource(1)))
+			if err != nil {
+				t.Fatalf("NewWorkload() error = %v", err)
+			}
+			if w.Name() != tt.cfg.Type {
+				t.Fatalf("Name() = %q, want %q", w.Name(), tt.cfg.Type)
+			}
+			var got []ReadRange
+			for range tt.want {
+				got = append(got, w.Next())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkloadNextBounds(t *testing.T) {
+	const objectSize, rangeSize = 100, 8
+	for _, cfg := range []WorkloadConfig{
+		{Type: randomWorkload, RangeSize: rangeSize},
+		{Type: mixedWorkload, RangeSize: rangeSize, Stride: 30, MixedWeights: [3]int{1, 2, 1}},
+	} {
+		t.Run(cfg.Type, func(t *testing.T) {
+			w, err := NewWorkload(&cfg, objectSize, rand.New(rand.NewSource(1)))
+			if err != nil {
+				t.Fatalf("NewWorkload() error = %v", err)
+			}
+			for i := 0; i < 1000; i++ {
+				r := w.Next()
+				if r.Offset < 0 || r.Length <= 0 || r.Length > rangeSize || r.Offset+r.Length > objectSize {
+					t.Fatalf("Next() = %+v, out of the %d bytes object", r, objectSize)
+				}
+			}
+		})
+	}
+}
+
+func TestNewWorkloadErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         WorkloadConfig
+		objectSize  int64
+		errContains string
+	}{
+		{name: "unknown type", cfg: WorkloadConfig{Type: "zigzag"}, errContains: "unknown workload"},
+		{name: "negative range size", cfg: WorkloadConfig{Type: sequentialWorkload, RangeSize: -1}, errContains: "invalid range size"},
+		{name: "negative stride", cfg: WorkloadConfig{Type: stridedWorkload, RangeSize: 1, Stride: -1}, errContains: "invalid stride"},
+		{name: "random without range size", cfg: WorkloadConfig{Type: randomWorkload}, errContains: "requires a range size"},
+		{name: "strided without range size", cfg: WorkloadConfig{Type: stridedWorkload}, errContains: "requires a range size"},
+		{name: "mixed without range size", cfg: WorkloadConfig{Type: mixedWorkload, MixedWeights: [3]int{1, 1, 1}}, errContains: "requires a range size"},
+		{name: "negative mixed weight", cfg: WorkloadConfig{Type: mixedWorkload, RangeSize: 1, MixedWeights: [3]int{1, -1, 1}}, errContains: "must be >= 0"},
+		{name: "zero mixed weights", cfg: WorkloadConfig{Type: mixedWorkload, RangeSize: 1}, errContains: "at least one must be > 0"},
+		{name: "empty object", cfg: WorkloadConfig{Type: randomWorkload, RangeSize: 1}, objectSize: 0, errContains: "requires a non-empty object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWorkload(&tt.cfg, tt.objectSize, rand.New(rand.NewSource(1)))
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Fatalf("NewWorkload() error = %v, want it to contain %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestParseMixedWeights(t *testing.T) {
+	got, err := parseMixedWeights("2, 1,0")
+	if err != nil || got != [3]int{2, 1, 0} {
+		t.Fatalf("parseMixedWeights() = %v, %v, want [2 1 0]", got, err)
+	}
+	for _, s := range []string{"", "1,1", "1,1,1,1", "1,x,1"} {
+		if _, err := parseMixedWeights(s); err == nil {
+			t.Errorf("parseMixedWeights(%q) should fail", s)
+		}
+	}
+}